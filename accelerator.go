@@ -0,0 +1,92 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"strconv"
+	"strings"
+)
+
+// cgroup v1 devices controller whitelist, passed to Accelerators
+const devicesListFile = "devices.list"
+
+// Accelerator holds the metrics of an accelerator, such as a GPU, used by
+// a container, see Accelerators
+type Accelerator struct {
+	// identifies the device, such as its UUID or index
+	ID    string
+	Model string
+	// compute utilization by the container's processes in percent
+	Utilization float64
+	// device memory used by the container's processes and in total, in
+	// bytes
+	MemoryUsage uint64
+	MemoryTotal uint64
+	// other metrics, such as power draw or encoder utilization
+	Extra map[string]float64
+}
+
+// AcceleratorFunc returns the accelerator metrics of container id. pids
+// are its member processes, devices the entries of its devices.list, such
+// as "c 195:0 rwm", which is only found on cgroup v1.
+type AcceleratorFunc func(id string, pids []int, devices []string) []Accelerator
+
+// readAccelerators sets c.Accelerators using fn, see Accelerators.
+func (c *Cstats) readAccelerators(fn AcceleratorFunc, id string) error {
+	members, err := c.memberPIDs()
+	if err != nil {
+		return err
+	}
+	pids := make([]int, 0, len(members))
+	for _, s := range members {
+		if pid, err := strconv.Atoi(s); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	var devices []string
+	if p, ok := c.controlFiles[devicesListFile]; ok {
+		b, err := readFile(p)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				devices = append(devices, line)
+			}
+		}
+	}
+	c.Accelerators = fn(id, pids, devices)
+	return nil
+}
+
+// copyAccelerators returns a deep copy of a.
+func copyAccelerators(a []Accelerator) []Accelerator {
+	if a == nil {
+		return nil
+	}
+	n := make([]Accelerator, len(a))
+	for i, acc := range a {
+		n[i] = acc
+		if acc.Extra != nil {
+			n[i].Extra = make(map[string]float64, len(acc.Extra))
+			for k, v := range acc.Extra {
+				n[i].Extra[k] = v
+			}
+		}
+	}
+	return n
+}
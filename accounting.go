@@ -0,0 +1,153 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"time"
+)
+
+// Usage accumulated by a container over a period, see StartAccounting
+type Usage struct {
+	// the period, from the first to the last collection included
+	Start time.Time
+	End   time.Time
+	// CPU time used, in seconds
+	CPUSeconds float64
+	// Memory.RSS integrated over the period, in byte hours
+	RSSByteHours float64
+	// block device bytes read and written, summed over devices
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// accountSample is the last collection integrated into a container's
+// Usage. Fields are exported for SaveState
+type accountSample struct {
+	Time  time.Time
+	CPU   time.Duration
+	RSS   uint64
+	Read  uint64
+	Write uint64
+}
+
+type accountant struct {
+	maxGap time.Duration
+	usage  map[string]*Usage
+	last   map[string]accountSample
+}
+
+// StartAccounting accumulates the usage of every container across
+// collections, to be read with ReadAccounting. CPU time and I/O are taken
+// from the containers' cumulative counters and are not lost when
+// collections are missed. RSS is integrated between collections up to
+// maxGap apart, longer gaps are left out of RSSByteHours. A zero maxGap
+// integrates RSS over gaps of any length. Accounting restarts from zero
+// if already started.
+func StartAccounting(maxGap time.Duration) {
+	statsHolder.startAccounting(maxGap)
+}
+
+func (h *holder) startAccounting(maxGap time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	h.accounting = &accountant{
+		maxGap: maxGap,
+		usage:  make(map[string]*Usage),
+		last:   make(map[string]accountSample),
+	}
+}
+
+// StopAccounting stops accumulating usage and discards what was not read.
+func StopAccounting() {
+	statsHolder.stopAccounting()
+}
+
+func (h *holder) stopAccounting() {
+	h.Lock()
+	defer h.Unlock()
+	h.accounting = nil
+}
+
+// ReadAccounting returns the usage accumulated by each container, keyed by
+// container ID. Containers removed since are included until read with
+// reset. With reset, the next period of each container starts from its
+// last collection, so consecutive periods cover all usage once.
+func ReadAccounting(reset bool) map[string]Usage {
+	return statsHolder.readAccounting(reset)
+}
+
+func (h *holder) readAccounting(reset bool) map[string]Usage {
+	h.Lock()
+	defer h.Unlock()
+	a := h.accounting
+	if a == nil {
+		return nil
+	}
+	usage := make(map[string]Usage, len(a.usage))
+	for id, u := range a.usage {
+		usage[id] = *u
+	}
+	if reset {
+		for id, u := range a.usage {
+			if _, ok := h.containers[id]; !ok {
+				delete(a.usage, id)
+				delete(a.last, id)
+				continue
+			}
+			*u = Usage{Start: u.End, End: u.End}
+		}
+	}
+	return usage
+}
+
+// add integrates cs, collected at now, into the usage of container id.
+func (a *accountant) add(id string, cs *Cstats, now time.Time) {
+	cur := accountSample{
+		Time:  now,
+		CPU:   cs.CPU.Total(),
+		RSS:   cs.Memory.RSS,
+		Read:  cs.BlkIO.TotalReadBytes,
+		Write: cs.BlkIO.TotalWriteBytes,
+	}
+	u, ok := a.usage[id]
+	if !ok {
+		u = &Usage{Start: now}
+		a.usage[id] = u
+	}
+	u.End = now
+	prev, ok := a.last[id]
+	a.last[id] = cur
+	if !ok {
+		return
+	}
+	u.CPUSeconds += time.Duration(counterDelta(uint64(prev.CPU), uint64(cur.CPU))).Seconds()
+	u.ReadBytes += counterDelta(prev.Read, cur.Read)
+	u.WriteBytes += counterDelta(prev.Write, cur.Write)
+	if gap := now.Sub(prev.Time); gap > 0 && (a.maxGap == 0 || gap <= a.maxGap) {
+		u.RSSByteHours += (float64(prev.RSS) + float64(cur.RSS)) / 2 * gap.Hours()
+	}
+}
+
+// counterDelta returns the increase of a cumulative counter from prev to
+// cur. A counter which went backwards was reset, such as by the container
+// restarting, and cur is all of the increase.
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
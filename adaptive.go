@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const loadAvgFile = "/proc/loadavg"
+
+// adaptive tracks how much Watch stretches its interval, see
+// AdaptiveSampling.
+type adaptive struct {
+	factor int
+}
+
+// update adjusts the interval factor given the duration of the last
+// collection and returns the interval to wait for the next one. The
+// factor doubles while either threshold is exceeded and halves once both
+// are back under half of their threshold. See AdaptiveSampling for cfg.
+func (a *adaptive) update(cfg *Config, interval, took time.Duration) time.Duration {
+	if a.factor < 1 {
+		a.factor = 1
+	}
+	if !cfg.AdaptiveSampling {
+		a.factor = 1
+		return interval
+	}
+	load, err := hostLoad()
+	if err != nil {
+		// ignore load, latency alone still applies
+		load = 0
+	}
+	switch {
+	case took > cfg.AdaptiveMaxLatency || load > cfg.AdaptiveMaxLoad:
+		if a.factor*2 <= cfg.AdaptiveMaxFactor {
+			a.factor *= 2
+		}
+	case took < cfg.AdaptiveMaxLatency/2 && load < cfg.AdaptiveMaxLoad/2:
+		if a.factor > 1 {
+			a.factor /= 2
+		}
+	}
+	return interval * time.Duration(a.factor)
+}
+
+// hostLoad returns the host's one minute load average per CPU.
+var hostLoad = func() (float64, error) {
+	b, err := readFile(loadAvgFile)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return load / float64(runtime.NumCPU()), nil
+}
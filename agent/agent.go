@@ -0,0 +1,225 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package agent serves gocstat statistics over HTTP, for collection by
+// package github.com/porjo/gocstat/client.
+//
+// Endpoints:
+//
+//	GET /stats                   current statistics as a JSON object keyed by container ID
+//	GET /watch?interval=1s       a stream of JSON objects, one per interval
+//	GET /watch?delta=1           a stream of Frames, see Frame
+//	GET /watch?filter=EXPR       only the containers selected by EXPR, see gocstat.ParseFilter
+//
+// gocstat.Init must be called before serving requests. Statistics are
+// shared between clients using gocstat.ReadCachedStats, set
+// gocstat.MaxStaleness to choose how old they may be. Containers are keyed
+// by their IDs as replaced by gocstat.ExportID.
+//
+// Container statistics reveal what is running on a host, so agents
+// listening on a network port should serve TLS, see TLSConfig, and
+// require a bearer token, see RequireToken.
+package agent
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/internal/mergepatch"
+)
+
+// Default interval for /watch when none is requested
+var DefaultInterval = time.Second
+
+// Shortest interval accepted by /watch
+var MinInterval = 100 * time.Millisecond
+
+// Number of frames between keyframes for /watch?delta=1, unless the
+// client asks for another with the keyframe parameter
+var DefaultKeyframeInterval = 30
+
+// Frame is sent each interval by /watch?delta=1. A keyframe carries the
+// statistics in full, the following frames carry only what changed since
+// the previous frame as a JSON merge patch (RFC 7386). Containers which
+// disappeared are set to null.
+type Frame struct {
+	Keyframe bool                   `json:"keyframe"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// Handler returns an http.Handler serving the agent endpoints.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", serveStats)
+	mux.HandleFunc("/watch", serveWatch)
+	return mux
+}
+
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	v, err := gocstat.ReadView()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gocstat.Export(v.Map()))
+}
+
+func serveWatch(w http.ResponseWriter, r *http.Request) {
+	interval := DefaultInterval
+	if s := r.URL.Query().Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "invalid interval: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	if interval < MinInterval {
+		interval = MinInterval
+	}
+	delta, _ := strconv.ParseBool(r.URL.Query().Get("delta"))
+	keyframeInterval := DefaultKeyframeInterval
+	if s := r.URL.Query().Get("keyframe"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid keyframe interval", http.StatusBadRequest)
+			return
+		}
+		keyframeInterval = n
+	}
+	var filter *gocstat.Filter
+	if s := r.URL.Query().Get("filter"); s != "" {
+		f, err := gocstat.ParseFilter(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = f
+	}
+
+	ch, stop := gocstat.WatchFilter(interval, filter, nil)
+	defer stop()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var prev map[string]interface{}
+	frames := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case stats, ok := <-ch:
+			if !ok {
+				return
+			}
+			stats = gocstat.Export(stats)
+			var v interface{} = stats
+			if delta {
+				doc, err := toDocument(stats)
+				if err != nil {
+					return
+				}
+				f := Frame{Keyframe: frames%keyframeInterval == 0, Data: doc}
+				if !f.Keyframe {
+					f.Data = mergepatch.Diff(prev, doc)
+				}
+				prev = doc
+				frames++
+				v = f
+			}
+			if err := enc.Encode(v); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// toDocument converts stats to the generic form used for merge patches.
+func toDocument(stats gocstat.Cmap) (map[string]interface{}, error) {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	err = dec.Decode(&doc)
+	return doc, err
+}
+
+// RequireToken wraps h, rejecting requests which don't carry the header
+// "Authorization: Bearer <token>".
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TLSConfig returns a server TLS configuration using the PEM encoded
+// certificate and key files. If clientCAFile is not empty, clients must
+// present a certificate signed by one of the CAs it contains (mutual TLS).
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in '%s'", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Minimum number of samples in the trailing window before values are
+// checked for anomalies
+const minAnomalySamples = 5
+
+// Anomaly reports a container's CPU or I/O rate deviating from its
+// trailing window by more than the configured z-score
+type Anomaly struct {
+	ID string
+	// "cpu_pct", "read_bps" or "write_bps"
+	Metric string
+	Value  float64
+	// mean and standard deviation of the trailing window
+	Mean   float64
+	Stddev float64
+	// +Inf or -Inf when the window was flat, Stddev being zero
+	ZScore    float64
+	Timestamp time.Time
+}
+
+type anomalyDetector struct {
+	ch     chan<- Anomaly
+	window time.Duration
+	zscore float64
+}
+
+// NotifyAnomalies sends an Anomaly on ch whenever a container's CPU
+// utilization or I/O rate is more than zscore standard deviations away
+// from the mean of the preceding window of its history. HistoryRetention
+// must be at least window.
+//
+// Sending on ch does not block: anomalies are dropped if ch is not ready
+// to receive. Pass a nil ch to stop detection.
+func NotifyAnomalies(ch chan<- Anomaly, window time.Duration, zscore float64) error {
+	return statsHolder.notifyAnomalies(ch, window, zscore)
+}
+
+func (h *holder) notifyAnomalies(ch chan<- Anomaly, window time.Duration, zscore float64) error {
+	if retention := h.config().HistoryRetention; ch != nil && retention < window {
+		return fmt.Errorf("HistoryRetention %s is shorter than the anomaly window %s", retention, window)
+	}
+	h.Lock()
+	defer h.Unlock()
+	if ch == nil {
+		h.anomalies = nil
+		return nil
+	}
+	h.anomalies = &anomalyDetector{ch: ch, window: window, zscore: zscore}
+	return nil
+}
+
+// check compares the latest sample of samples against the ones before it
+// within the window.
+func (a *anomalyDetector) check(id string, samples []sample) {
+	if len(samples) < 2 {
+		return
+	}
+	cur := samples[len(samples)-1]
+	cutoff := cur.time.Add(-a.window)
+	var window []sample
+	for _, s := range samples[:len(samples)-1] {
+		if !s.time.Before(cutoff) {
+			window = append(window, s)
+		}
+	}
+	if len(window) < minAnomalySamples {
+		return
+	}
+
+	metrics := []struct {
+		name  string
+		value func(s sample) float64
+	}{
+		{"cpu_pct", func(s sample) float64 { return s.cpuPct }},
+		{"read_bps", func(s sample) float64 { return s.readBps }},
+		{"write_bps", func(s sample) float64 { return s.writeBps }},
+	}
+	for _, m := range metrics {
+		values := make([]float64, len(window))
+		for i, s := range window {
+			values[i] = m.value(s)
+		}
+		mean, stddev := meanStddev(values)
+		v := m.value(cur)
+		if v == mean {
+			continue
+		}
+		// any change from a flat window, such as writes to an idle
+		// container, is infinitely far from it
+		z := math.Inf(1)
+		if v < mean {
+			z = math.Inf(-1)
+		}
+		if stddev != 0 {
+			z = (v - mean) / stddev
+		}
+		if math.Abs(z) <= a.zscore {
+			continue
+		}
+		select {
+		case a.ch <- Anomaly{ID: id, Metric: m.name, Value: v, Mean: mean, Stddev: stddev, ZScore: z, Timestamp: cur.time}:
+		default:
+		}
+	}
+}
+
+func meanStddev(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(values)))
+}
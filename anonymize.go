@@ -0,0 +1,103 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ExportID, if set, replaces container IDs in the statistics handed to
+// sinks, in UsageRecords and in Export, for environments where full IDs
+// should not reach shared backends. It must return the same value for an
+// ID every time, so exported keys stay stable. See HashIDs and
+// TruncateIDs.
+var ExportID func(id string) string
+
+// HashIDs returns an ExportID function replacing IDs with their
+// HMAC-SHA256 keyed by key, hex encoded and truncated to length
+// characters if length is positive. Without the key IDs cannot be
+// recovered, or confirmed by hashing a known ID.
+func HashIDs(key string, length int) func(id string) string {
+	return func(id string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(id))
+		return truncate(hex.EncodeToString(mac.Sum(nil)), length)
+	}
+}
+
+// TruncateIDs returns an ExportID function keeping the first length
+// characters of IDs, as docker ps does. Short prefixes may collide on
+// hosts running many containers.
+func TruncateIDs(length int) func(id string) string {
+	return func(id string) string {
+		return truncate(id, length)
+	}
+}
+
+func truncate(s string, length int) string {
+	if length > 0 && len(s) > length {
+		return s[:length]
+	}
+	return s
+}
+
+// exportID returns id as replaced by ExportID.
+func exportID(id string) string {
+	if ExportID == nil {
+		return id
+	}
+	return ExportID(id)
+}
+
+// Export returns stats keyed by container IDs as replaced by ExportID, or
+// stats itself if ExportID is not set. IDs are also replaced in ParentID,
+// Sources and FS.Path.
+func Export(stats Cmap) Cmap {
+	if ExportID == nil {
+		return stats
+	}
+	exported := make(Cmap, len(stats))
+	for id, cs := range stats {
+		exported[ExportID(id)] = exportStats(id, cs)
+	}
+	return exported
+}
+
+// exportStats returns cs, or a copy of it with the fields holding id, such
+// as the cgroup directories of Sources, replaced by ExportID. The copy
+// shares the rest of cs.
+func exportStats(id string, cs *Cstats) *Cstats {
+	if ExportID == nil {
+		return cs
+	}
+	eid := ExportID(id)
+	n := *cs
+	if n.ParentID != "" {
+		n.ParentID = ExportID(n.ParentID)
+	}
+	if n.Sources != nil {
+		n.Sources = make(map[string]string, len(cs.Sources))
+		for s, dir := range cs.Sources {
+			n.Sources[s] = strings.Replace(dir, id, eid, -1)
+		}
+	}
+	n.FS.Path = strings.Replace(n.FS.Path, id, eid, -1)
+	return &n
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package audit provides a gocstat sink writing a timeline of container
+// lifecycle events as JSON lines: containers appearing and disappearing,
+// limits changing, freezing and thawing, and OOM kills. It is kept apart
+// from the numeric statistics, for audit logging.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/porjo/gocstat"
+)
+
+// Event types
+const (
+	Appeared      = "appeared"
+	Disappeared   = "disappeared"
+	LimitsChanged = "limits_changed"
+	Frozen        = "frozen"
+	Thawed        = "thawed"
+	OOMKill       = "oom_kill"
+)
+
+// Entry is written for each event
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Event     string    `json:"event"`
+	// for LimitsChanged, the limits which changed keyed by their
+	// gocstat.Field name without the "Limits." prefix, such as "Memory"
+	Changes map[string]Change `json:"changes,omitempty"`
+	// for OOMKill, the number of processes killed since the previous
+	// collection
+	Count uint64 `json:"count,omitempty"`
+}
+
+// Change of a limit. Limits which are not set are gocstat.Unlimited
+type Change struct {
+	Old float64 `json:"old"`
+	New float64 `json:"new"`
+}
+
+// limit fields compared for LimitsChanged
+var limits []gocstat.Field
+
+func init() {
+	for _, f := range gocstat.Schema() {
+		if strings.HasPrefix(f.Name, "Limits.") {
+			limits = append(limits, f)
+		}
+	}
+}
+
+// Sink writes the events found between the statistics it is sent.
+// Register it with gocstat.AddSink. The containers present when it is
+// first sent statistics are logged as appeared.
+type Sink struct {
+	enc  *json.Encoder
+	prev gocstat.Cmap
+}
+
+// New returns a Sink writing entries to w, one JSON object per line.
+func New(w io.Writer) *Sink {
+	return &Sink{enc: json.NewEncoder(w)}
+}
+
+// Send writes the events since the previous statistics, implementing
+// gocstat.Sink.
+func (s *Sink) Send(stats gocstat.Cmap) error {
+	now := time.Now()
+	var entries []Entry
+	for id, cs := range stats {
+		prev, ok := s.prev[id]
+		if !ok {
+			entries = append(entries, Entry{Time: now, Container: id, Event: Appeared})
+			continue
+		}
+		entries = append(entries, diff(id, prev, cs, now)...)
+	}
+	for id := range s.prev {
+		if _, ok := stats[id]; !ok {
+			entries = append(entries, Entry{Time: now, Container: id, Event: Disappeared})
+		}
+	}
+	s.prev = stats
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Container < entries[j].Container })
+	for _, e := range entries {
+		if err := s.enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diff returns the events of container id between prev and cur.
+func diff(id string, prev, cur *gocstat.Cstats, now time.Time) []Entry {
+	var entries []Entry
+	// limits are not compared before both have been read, see
+	// gocstat.SubsystemIntervals
+	if !prev.Limits.Timestamp.IsZero() && !cur.Limits.Timestamp.IsZero() {
+		changes := make(map[string]Change)
+		for _, f := range limits {
+			if o, n := f.Value(prev), f.Value(cur); o != n {
+				changes[strings.TrimPrefix(f.Name, "Limits.")] = Change{Old: o, New: n}
+			}
+		}
+		if len(changes) > 0 {
+			entries = append(entries, Entry{Time: now, Container: id, Event: LimitsChanged, Changes: changes})
+		}
+	}
+	if !prev.Cgroup.Frozen && cur.Cgroup.Frozen {
+		entries = append(entries, Entry{Time: now, Container: id, Event: Frozen})
+	} else if prev.Cgroup.Frozen && !cur.Cgroup.Frozen {
+		entries = append(entries, Entry{Time: now, Container: id, Event: Thawed})
+	}
+	// the count going down means the cgroup was recreated
+	if cur.Memory.OOMKills > prev.Memory.OOMKills {
+		entries = append(entries, Entry{Time: now, Container: id, Event: OOMKill, Count: cur.Memory.OOMKills - prev.Memory.OOMKills})
+	}
+	return entries
+}
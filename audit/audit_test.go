@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/porjo/gocstat"
+)
+
+func container(memoryLimit uint64, frozen bool, oomKills uint64) *gocstat.Cstats {
+	cs := &gocstat.Cstats{}
+	cs.Limits.Memory = memoryLimit
+	cs.Limits.Timestamp = time.Now()
+	cs.Cgroup.Frozen = frozen
+	cs.Memory.OOMKills = oomKills
+	return cs
+}
+
+func TestSend(t *testing.T) {
+	var buf bytes.Buffer
+	s := New(&buf)
+	for _, stats := range []gocstat.Cmap{
+		{"a": container(1<<30, false, 0), "b": container(1<<30, false, 0)},
+		{"a": container(2<<30, true, 2)},
+		{"a": container(2<<30, false, 2)},
+	} {
+		if err := s.Send(stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e.Container+" "+e.Event)
+		switch e.Event {
+		case LimitsChanged:
+			if c := e.Changes["Memory"]; len(e.Changes) != 1 || c.Old != 1<<30 || c.New != 2<<30 {
+				t.Errorf("unexpected changes %+v", e.Changes)
+			}
+		case OOMKill:
+			if e.Count != 2 {
+				t.Errorf("expected 2 OOM kills, got %d", e.Count)
+			}
+		}
+	}
+	expected := []string{"a appeared", "b appeared", "a limits_changed", "a frozen", "a oom_kill", "b disappeared", "a thawed"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, events)
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Baseline is a usage profile of containers, such as recorded from a
+// known-good run, keyed by container ID. Containers whose IDs differ
+// between runs can be keyed by a stable name instead, such as from their
+// Metadata, in both the baseline and the usage compared with it
+type Baseline map[string]Summary
+
+// Deviation reports a metric of a container differing from its baseline
+type Deviation struct {
+	ID string
+	// "cpu_pct", "rss", "read_bps" or "write_bps"
+	Metric string
+	// "mean" or "p95"
+	Stat     string
+	Value    float64
+	Baseline float64
+	// (Value - Baseline) / Baseline, +Inf for a zero Baseline
+	Change float64
+}
+
+// RecordBaseline returns the Summary of every container over the last
+// window of its history, see Summarize.
+func RecordBaseline(window time.Duration) Baseline {
+	return statsHolder.recordBaseline(window)
+}
+
+func (h *holder) recordBaseline(window time.Duration) Baseline {
+	h.Lock()
+	defer h.Unlock()
+	cutoff := time.Now().Add(-window)
+	b := make(Baseline, len(h.history))
+	for id, samples := range h.history {
+		b[id] = summarizeSamples(samples, cutoff)
+	}
+	return b
+}
+
+// SaveBaseline writes b to w as JSON.
+func SaveBaseline(w io.Writer, b Baseline) error {
+	return json.NewEncoder(w).Encode(b)
+}
+
+// LoadBaseline reads a baseline written by SaveBaseline.
+func LoadBaseline(r io.Reader) (Baseline, error) {
+	var b Baseline
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// CompareBaseline compares the mean and 95th percentile of each metric of
+// cur against baseline, returning those which changed by more than
+// tolerance, a fraction of the baseline value, in either direction.
+// Containers missing from either, or without samples, are not compared.
+// Deviations are ordered by container, metric and statistic.
+func CompareBaseline(baseline, cur Baseline, tolerance float64) []Deviation {
+	ids := make([]string, 0, len(cur))
+	for id := range cur {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var deviations []Deviation
+	for _, id := range ids {
+		base, ok := baseline[id]
+		if !ok || base.Samples == 0 || cur[id].Samples == 0 {
+			continue
+		}
+		c := cur[id]
+		metrics := []struct {
+			name      string
+			cur, base SummaryStat
+		}{
+			{"cpu_pct", c.CPUPct, base.CPUPct},
+			{"rss", c.RSS, base.RSS},
+			{"read_bps", c.ReadBps, base.ReadBps},
+			{"write_bps", c.WriteBps, base.WriteBps},
+		}
+		for _, m := range metrics {
+			for _, st := range []struct {
+				name      string
+				cur, base float64
+			}{
+				{"mean", m.cur.Mean, m.base.Mean},
+				{"p95", m.cur.P95, m.base.P95},
+			} {
+				if st.cur == st.base {
+					continue
+				}
+				change := math.Inf(1)
+				if st.base != 0 {
+					change = (st.cur - st.base) / st.base
+				}
+				if math.Abs(change) <= tolerance {
+					continue
+				}
+				deviations = append(deviations, Deviation{
+					ID:       id,
+					Metric:   m.name,
+					Stat:     st.name,
+					Value:    st.cur,
+					Baseline: st.base,
+					Change:   change,
+				})
+			}
+		}
+	}
+	return deviations
+}
@@ -17,25 +17,77 @@
 package gocstat
 
 import (
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	blkIOIOPSFile  = "blkio.throttle.io_serviced"
 	blkIOBytesFile = "blkio.throttle.io_service_bytes"
+	// I/O scheduler statistics, used when throttling statistics are unavailable
+	blkIOCFQIOPSFile  = "blkio.io_serviced"
+	blkIOCFQBytesFile = "blkio.io_service_bytes"
+	blkIOBFQIOPSFile  = "blkio.bfq.io_serviced"
+	blkIOBFQBytesFile = "blkio.bfq.io_service_bytes"
+	// including nested cgroups, see HierarchicalBlkIO
+	blkIOIOPSRecursiveFile     = "blkio.throttle.io_serviced_recursive"
+	blkIOBytesRecursiveFile    = "blkio.throttle.io_service_bytes_recursive"
+	blkIOCFQIOPSRecursiveFile  = "blkio.io_serviced_recursive"
+	blkIOCFQBytesRecursiveFile = "blkio.io_service_bytes_recursive"
+	blkIOBFQIOPSRecursiveFile  = "blkio.bfq.io_serviced_recursive"
+	blkIOBFQBytesRecursiveFile = "blkio.bfq.io_service_bytes_recursive"
+	// requests queued, cgroup v1 CFQ and BFQ only
+	blkIOCFQQueuedFile          = "blkio.io_queued"
+	blkIOBFQQueuedFile          = "blkio.bfq.io_queued"
+	blkIOCFQQueuedRecursiveFile = "blkio.io_queued_recursive"
+	blkIOBFQQueuedRecursiveFile = "blkio.bfq.io_queued_recursive"
+	// cgroup v2, always hierarchical
+	iOStatFile = "io.stat"
 )
 
 // Block device input/output statistics
 type BlkIOStat struct {
 	Bytes BlkServiced
 	IOPS  BlkServiced
+	// requests currently queued by the I/O scheduler, per device. Read and
+	// Write, like Sync and Async, each count all queued requests. A
+	// sustained non-zero value indicates the container is waiting on I/O.
+	// cgroup v1 with the CFQ or BFQ scheduler only, BFQ requiring
+	// CONFIG_BFQ_CGROUP_DEBUG. cgroup v2 has no equivalent, Latency.Depth
+	// is the queue depth allowed by io.latency rather than the number of
+	// requests in flight
+	Queued BlkServiced
+	// Bytes and IOPS summed across devices
+	TotalReadBytes  uint64
+	TotalWriteBytes uint64
+	TotalReadOps    uint64
+	TotalWriteOps   uint64
+	// io.latency controller statistics, cgroup v2 only. Devices are
+	// only listed once an io.latency target is set for them
+	Latency []BlkLatency
+}
+
+// io.latency statistics for a block device
+type BlkLatency struct {
+	// block device major number
+	Major uint64
+	// block device minor number
+	Minor uint64
+	// current queue depth allowed by the controller
+	Depth uint64
+	// running average latency in microseconds
+	AvgLat uint64
+	// sampling window in milliseconds
+	Win uint64
 }
 
 // Block device tallies
 type BlkServiced struct {
-	path      string
 	Timestamp time.Time
 	Devices   []BlkDevice
 }
@@ -53,62 +105,197 @@ type BlkDevice struct {
 	Sync uint64
 	// asynchronous operation count
 	Async uint64
+	// file backing a loop device, see ResolveLoopDevices
+	BackingFile string
 }
 
-func (b *BlkServiced) create(content string) {
+// create parses "major:minor operation value" lines. Lines are grouped by
+// device rather than by position, so a device whose lines are not
+// contiguous still yields a single entry. Devices are listed in the order
+// they first appear; per-cgroup "Total" lines are ignored. Devices are
+// filtered according to cfg.BlkIODevices.
+func (b *BlkServiced) create(cfg *Config, content string) {
 	b.Timestamp = time.Now()
-	lines := strings.Split(content, "\n")
-	lastDeviceStr := ""
-	tmpContent := make([]string, 0)
 	b.Devices = make([]BlkDevice, 0)
-	for i, line := range lines {
+	index := make(map[[2]uint64]int)
+	for _, line := range strings.Split(content, "\n") {
 		fields := strings.Fields(line)
 		if len(fields) != 3 {
 			continue
 		}
-		deviceStr := fields[0]
-
-		if deviceStr != lastDeviceStr && i > 0 {
-			bd := BlkDevice{}
-			bd.create(tmpContent)
-			b.Devices = append(b.Devices, bd)
-			tmpContent = make([]string, 0)
+		major, minor, ok := parseDevice(fields[0])
+		if !ok || !wantDevice(cfg, fields[0]) {
+			continue
 		}
-		tmpContent = append(tmpContent, line)
-		lastDeviceStr = deviceStr
-	}
-	if len(tmpContent) != 0 {
-		bd := &BlkDevice{}
-		bd.create(tmpContent)
-		b.Devices = append(b.Devices, *bd)
+		key := [2]uint64{major, minor}
+		n, ok := index[key]
+		if !ok {
+			n = len(b.Devices)
+			index[key] = n
+			b.Devices = append(b.Devices, BlkDevice{Major: major, Minor: minor})
+		}
+		b.Devices[n].set(fields[1], fields[2])
 	}
 }
 
-func (b *BlkDevice) create(lines []string) {
-	for _, line := range lines {
+// createIOStat parses io.stat, made up of "major:minor key=value ..." lines.
+// Byte and operation counts go to Bytes and IOPS, which have no Sync and
+// Async counts on cgroup v2.
+func (b *BlkIOStat) createIOStat(cfg *Config, content string) {
+	now := time.Now()
+	b.Bytes = BlkServiced{Timestamp: now, Devices: make([]BlkDevice, 0)}
+	b.IOPS = BlkServiced{Timestamp: now, Devices: make([]BlkDevice, 0)}
+	b.Latency = nil
+	for _, line := range strings.Split(content, "\n") {
 		fields := strings.Fields(line)
-		if len(fields) != 3 {
+		if len(fields) < 2 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok || !wantDevice(cfg, fields[0]) {
 			continue
 		}
-		deviceStr := fields[0]
-		op := fields[1]
-		unit := fields[2]
-
-		device := strings.Split(deviceStr, ":")
-		if len(device) > 1 {
-			b.Major, _ = strconv.ParseUint(device[0], 10, 64)
-			b.Minor, _ = strconv.ParseUint(device[1], 10, 64)
+		kv := parseEqualValues(fields[1:])
+		if _, ok := kv["rbytes"]; ok {
+			b.Bytes.Devices = append(b.Bytes.Devices, BlkDevice{Major: major, Minor: minor, Read: kv["rbytes"], Write: kv["wbytes"]})
+			b.IOPS.Devices = append(b.IOPS.Devices, BlkDevice{Major: major, Minor: minor, Read: kv["rios"], Write: kv["wios"]})
+		}
+		if _, ok := kv["depth"]; ok {
+			b.Latency = append(b.Latency, BlkLatency{
+				Major:  major,
+				Minor:  minor,
+				Depth:  kv["depth"],
+				AvgLat: kv["avg_lat"],
+				Win:    kv["win"],
+			})
 		}
+	}
+}
+
+// sumDevices sets the totals from the Bytes and IOPS devices.
+func (b *BlkIOStat) sumDevices() {
+	b.TotalReadBytes, b.TotalWriteBytes = 0, 0
+	for _, d := range b.Bytes.Devices {
+		b.TotalReadBytes += d.Read
+		b.TotalWriteBytes += d.Write
+	}
+	b.TotalReadOps, b.TotalWriteOps = 0, 0
+	for _, d := range b.IOPS.Devices {
+		b.TotalReadOps += d.Read
+		b.TotalWriteOps += d.Write
+	}
+}
 
-		switch op {
-		case "Read":
-			b.Read, _ = strconv.ParseUint(unit, 10, 64)
-		case "Write":
-			b.Write, _ = strconv.ParseUint(unit, 10, 64)
-		case "Sync":
-			b.Sync, _ = strconv.ParseUint(unit, 10, 64)
-		case "Async":
-			b.Async, _ = strconv.ParseUint(unit, 10, 64)
+// resolveLoopDevices sets the BackingFile of the loop devices in Bytes,
+// IOPS and Queued, from sysfs mounted at sysPath.
+func (b *BlkIOStat) resolveLoopDevices(sysPath string) {
+	files := make(map[string]string)
+	for _, devices := range [][]BlkDevice{b.Bytes.Devices, b.IOPS.Devices, b.Queued.Devices} {
+		for i := range devices {
+			key := devices[i].Key()
+			f, ok := files[key]
+			if !ok {
+				f = backingFile(sysPath, key)
+				files[key] = f
+			}
+			devices[i].BackingFile = f
 		}
 	}
 }
+
+// backingFile returns the file backing the loop device key, or "" if it
+// is not a loop device. The file can change as loop devices are detached
+// and reused, so it is not cached.
+func backingFile(sysPath, key string) string {
+	if !strings.HasPrefix(deviceName(sysPath, key), "loop") {
+		return ""
+	}
+	b, err := readFile(filepath.Join(sysPath, "dev", "block", key, "loop", "backing_file"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// deviceNames caches the kernel names of block devices by their
+// /sys/dev/block link.
+var deviceNames = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// wantDevice reports whether the statistics of the device key,
+// "major:minor", are to be reported according to cfg.BlkIODevices.
+func wantDevice(cfg *Config, key string) bool {
+	if len(cfg.BlkIODevices) == 0 {
+		return true
+	}
+	name := ""
+	for _, p := range cfg.BlkIODevices {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+		if strings.Contains(p, ":") {
+			continue
+		}
+		if name == "" {
+			name = deviceName(cfg.SysPath, key)
+		}
+		if ok, _ := path.Match(p, name); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceName returns the kernel name of the block device key, such as
+// "nvme0n1", from its /sys/dev/block link in sysfs mounted at sysPath, or
+// "" if it is not found.
+func deviceName(sysPath, key string) string {
+	link := filepath.Join(sysPath, "dev", "block", key)
+	deviceNames.Lock()
+	defer deviceNames.Unlock()
+	if name, ok := deviceNames.m[link]; ok {
+		return name
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		return ""
+	}
+	name := filepath.Base(target)
+	deviceNames.m[link] = name
+	return name
+}
+
+// Key returns the device's "major:minor" identifier.
+func (b BlkDevice) Key() string {
+	return strconv.FormatUint(b.Major, 10) + ":" + strconv.FormatUint(b.Minor, 10)
+}
+
+// ByDevice returns Devices keyed by "major:minor", for joining the Bytes
+// and IOPS tallies of a device or comparing samples.
+func (b *BlkServiced) ByDevice() map[string]BlkDevice {
+	m := make(map[string]BlkDevice, len(b.Devices))
+	for _, d := range b.Devices {
+		m[d.Key()] = d
+	}
+	return m
+}
+
+// set records value against the operation op.
+func (b *BlkDevice) set(op, value string) {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return
+	}
+	switch op {
+	case "Read":
+		b.Read = n
+	case "Write":
+		b.Write = n
+	case "Sync":
+		b.Sync = n
+	case "Async":
+		b.Async = n
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"unsafe"
+)
+
+// Approximate memory retained by the package, in bytes
+type RetainedMemory struct {
+	// samples kept for Summarize and NotifyAnomalies
+	History int
+	// the last collection, shared by ReadCachedStats, and each container's
+	// previous sample
+	Snapshot int
+}
+
+// Total returns the sum of the retained memory.
+func (r RetainedMemory) Total() int {
+	return r.History + r.Snapshot
+}
+
+// Retained returns an estimate of the memory held in history and
+// snapshots, see MemoryBudget.
+func Retained() RetainedMemory {
+	return statsHolder.lockedRetained()
+}
+
+func (h *holder) lockedRetained() RetainedMemory {
+	h.Lock()
+	defer h.Unlock()
+	return h.retained()
+}
+
+func (h *holder) retained() RetainedMemory {
+	var r RetainedMemory
+	for id, samples := range h.history {
+		r.History += len(id) + cap(samples)*int(unsafe.Sizeof(sample{}))
+	}
+	for id, cs := range h.snapshot {
+		r.Snapshot += len(id) + cs.size()
+	}
+	return r
+}
+
+// size estimates the memory used by c, excluding its file paths.
+func (c *Cstats) size() int {
+	n := int(unsafe.Sizeof(*c))
+	n += (len(c.BlkIO.Bytes.Devices) + len(c.BlkIO.IOPS.Devices) + len(c.BlkIO.Queued.Devices)) * int(unsafe.Sizeof(BlkDevice{}))
+	n += len(c.BlkIO.Latency) * int(unsafe.Sizeof(BlkLatency{}))
+	n += len(c.Limits.IO) * int(unsafe.Sizeof(IOLimit{}))
+	n += (len(c.Limits.IOWeightDevices) + len(c.Limits.BFQWeightDevices)) * int(unsafe.Sizeof(DeviceWeight{}))
+	n += len(c.Limits.IOLatency) * int(unsafe.Sizeof(IOLatencyTarget{}))
+	n += len(c.Utilization.IO) * int(unsafe.Sizeof(IOUtilization{}))
+	n += len(c.CPU.PerCPU) * 8
+	for k := range c.CPU.Extra {
+		n += len(k) + 8
+	}
+	for k := range c.Memory.Extra {
+		n += len(k) + 8
+	}
+	for _, s := range c.Cgroup.Controllers {
+		n += int(unsafe.Sizeof(s)) + len(s)
+	}
+	for _, s := range c.Cgroup.SubtreeControl {
+		n += int(unsafe.Sizeof(s)) + len(s)
+	}
+	for _, iface := range c.Net.Interfaces {
+		n += int(unsafe.Sizeof(iface)) + len(iface.Name)
+	}
+	for _, fs := range c.Tmpfs {
+		n += int(unsafe.Sizeof(fs)) + len(fs.Path)
+	}
+	for _, a := range c.Accelerators {
+		n += int(unsafe.Sizeof(a)) + len(a.ID) + len(a.Model) + len(a.Extra)*16
+	}
+	for k, v := range c.Metadata {
+		n += len(k) + len(v)
+	}
+	for k, v := range c.Sources {
+		n += len(k) + len(v)
+	}
+	for k := range c.Deltas.Counters {
+		n += len(k) + 8
+	}
+	for k := range c.Deltas.Rates {
+		n += len(k) + 8
+	}
+	for k := range c.Derived {
+		n += len(k) + 8
+	}
+	return n
+}
+
+// compact trims history to fit MemoryBudget. Histories of inactive
+// containers go first, then the oldest samples of every container. The
+// holder must be locked.
+func (h *holder) compact() {
+	budget := h.config().MemoryBudget
+	if budget <= 0 {
+		return
+	}
+	r := h.retained()
+	if r.Total() <= budget {
+		return
+	}
+	for id := range h.history {
+		if cs, ok := h.snapshot[id]; ok && cs.Inactive {
+			delete(h.history, id)
+		}
+	}
+	r = h.retained()
+	if r.Total() <= budget || len(h.history) == 0 {
+		return
+	}
+	keep := (budget - r.Snapshot) / int(unsafe.Sizeof(sample{})) / len(h.history)
+	for id, samples := range h.history {
+		if len(samples) <= keep {
+			continue
+		}
+		if keep <= 0 {
+			delete(h.history, id)
+			continue
+		}
+		// copy so the trimmed samples can be freed
+		h.history[id] = append([]sample(nil), samples[len(samples)-keep:]...)
+	}
+}
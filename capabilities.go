@@ -0,0 +1,109 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cgroup v1 resource controllers, each mounted as its own hierarchy or
+// co-mounted with others (e.g. "cpu,cpuacct")
+var v1Controllers = map[string]bool{
+	"blkio":      true,
+	"cpu":        true,
+	"cpuacct":    true,
+	"cpuset":     true,
+	"devices":    true,
+	"freezer":    true,
+	"hugetlb":    true,
+	"memory":     true,
+	"misc":       true,
+	"net_cls":    true,
+	"net_prio":   true,
+	"perf_event": true,
+	"pids":       true,
+	"rdma":       true,
+}
+
+// CgroupInfo describes the cgroup hierarchy found at BasePath
+type CgroupInfo struct {
+	// cgroup version, 1 or 2. Hybrid hosts, with v1 controllers and an
+	// empty unified hierarchy, report 1
+	Version int
+	// controllers mounted (v1) or enabled in the root cgroup.controllers
+	// (v2), sorted by name
+	Controllers []string
+}
+
+// Has reports whether controller is available.
+func (c CgroupInfo) Has(controller string) bool {
+	for _, name := range c.Controllers {
+		if name == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities reports the cgroup version and controllers found by Init,
+// so callers can tell statistics which are unavailable from zero values.
+func Capabilities() CgroupInfo {
+	return statsHolder.capabilities()
+}
+
+func (h *holder) capabilities() CgroupInfo {
+	h.Lock()
+	defer h.Unlock()
+	info := h.info
+	info.Controllers = append([]string(nil), info.Controllers...)
+	return info
+}
+
+// probeCgroups inspects the hierarchy mounted at basePath.
+func probeCgroups(basePath string) (CgroupInfo, error) {
+	b, err := ioutil.ReadFile(filepath.Join(basePath, "cgroup.controllers"))
+	if err == nil {
+		controllers := strings.Fields(string(b))
+		sort.Strings(controllers)
+		return CgroupInfo{Version: 2, Controllers: controllers}, nil
+	}
+	if !os.IsNotExist(err) {
+		return CgroupInfo{}, err
+	}
+
+	entries, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return CgroupInfo{}, err
+	}
+	info := CgroupInfo{Version: 1}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		for _, name := range strings.Split(e.Name(), ",") {
+			if v1Controllers[name] && !info.Has(name) {
+				info.Controllers = append(info.Controllers, name)
+			}
+		}
+	}
+	sort.Strings(info.Controllers)
+	return info, nil
+}
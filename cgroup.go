@@ -0,0 +1,253 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	cgroupStatFile    = "cgroup.stat"
+	cgroupEventsFile  = "cgroup.events"
+	cgroupProcsFile   = "cgroup.procs"
+	cgroupThreadsFile = "cgroup.threads"
+	cgroupTypeFile    = "cgroup.type"
+	// cgroup v2 controllers available to the cgroup, and enabled for its
+	// children
+	cgroupControllersFile    = "cgroup.controllers"
+	cgroupSubtreeControlFile = "cgroup.subtree_control"
+)
+
+// Statistics about the container's cgroup itself. All fields except Procs
+// are cgroup v2 only
+type CgroupStat struct {
+	// number of processes in the cgroup, not including descendant cgroups.
+	// Not available for threaded cgroups
+	Procs uint64
+	// number of threads in the cgroup, not including descendant cgroups
+	Threads uint64
+	// "domain", "domain threaded", "domain invalid" or "threaded"
+	Type string
+
+	// controllers enabled for the cgroup by its parent's subtree_control,
+	// sorted by name. Statistics of controllers not listed, such as memory
+	// or io, are absent from the cgroup
+	Controllers []string
+	// controllers the cgroup enables for its children, sorted by name
+	SubtreeControl []string
+
+	// number of visible descendant cgroups
+	NrDescendants uint64
+	// number of removed descendant cgroups still held by the kernel.
+	// A steadily growing count usually means leaked kernel memory
+	NrDyingDescendants uint64
+
+	// the cgroup or one of its descendants contains live processes
+	Populated bool
+	// the cgroup is frozen. Also reported on cgroup v1 by the freezer controller
+	Frozen    bool
+	Timestamp time.Time
+}
+
+func (c *CgroupStat) create(content string) {
+	kv := parseKeyValues(content)
+	c.NrDescendants = kv["nr_descendants"]
+	c.NrDyingDescendants = kv["nr_dying_descendants"]
+	c.Timestamp = time.Now()
+}
+
+func (c *CgroupStat) createEvents(content string) {
+	kv := parseKeyValues(content)
+	c.Populated = kv["populated"] == 1
+	c.Frozen = kv["frozen"] == 1
+	c.Timestamp = time.Now()
+}
+
+// HasController reports whether controller is enabled for the cgroup, as
+// listed in Controllers.
+func (c CgroupStat) HasController(controller string) bool {
+	for _, name := range c.Controllers {
+		if name == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// parseControllers parses a space separated list of controllers.
+func parseControllers(content string) []string {
+	controllers := strings.Fields(content)
+	sort.Strings(controllers)
+	return controllers
+}
+
+func (c *CgroupStat) createProcs(content string) {
+	c.Procs = uint64(len(strings.Fields(content)))
+	c.Timestamp = time.Now()
+}
+
+// memberPIDs returns the IDs of c's member processes.
+func (c *Cstats) memberPIDs() ([]string, error) {
+	p, ok := c.files[cgroupProcsFile]
+	if !ok {
+		return nil, nil
+	}
+	b, err := readFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+// threadedErr reports whether err, returned reading the file name, is
+// expected because the container's cgroup is threaded. Threaded cgroups
+// have no domain controller (memory, io) files and refuse reads of
+// cgroup.procs; files which disappeared are forgotten.
+func (c *Cstats) threadedErr(name string, err error) bool {
+	if c.Cgroup.Type != "threaded" {
+		return false
+	}
+	if name == cgroupProcsFile && errors.Is(err, syscall.EOPNOTSUPP) {
+		return true
+	}
+	if os.IsNotExist(err) && (strings.HasPrefix(name, "memory.") || strings.HasPrefix(name, "io.")) {
+		delete(c.files, name)
+		return true
+	}
+	return false
+}
+
+// populated reports whether the container has member processes, using
+// cgroup.events where available and falling back to cgroup.procs. Containers
+// with neither file are assumed to be populated.
+func (c *Cstats) populated() bool {
+	if _, ok := c.files[cgroupEventsFile]; ok {
+		return c.Cgroup.Populated
+	}
+	if _, ok := c.files[cgroupProcsFile]; ok {
+		return c.Cgroup.Procs > 0
+	}
+	return true
+}
+
+// CgroupEvent reports a change to a container's cgroup.events file
+type CgroupEvent struct {
+	ID        string
+	Populated bool
+	Frozen    bool
+	Timestamp time.Time
+}
+
+type eventWatcher struct {
+	in *inotify
+	ch chan<- CgroupEvent
+	// closed once run returns
+	done chan struct{}
+
+	sync.Mutex
+	// cgroup.events path -> container ID
+	ids map[string]string
+}
+
+// NotifyCgroupEvents uses inotify to watch the cgroup.events file of every
+// discovered container (cgroup v2 only) and sends a CgroupEvent on ch each
+// time it changes, so containers emptying or freezing are seen immediately
+// rather than on the next ReadStats.
+//
+// Init must be called first. Sending on ch does not block: events are
+// dropped if ch is not ready to receive. A later call replaces ch.
+func NotifyCgroupEvents(ch chan<- CgroupEvent) error {
+	return statsHolder.notifyCgroupEvents(ch)
+}
+
+func (h *holder) notifyCgroupEvents(ch chan<- CgroupEvent) error {
+	h.Lock()
+	defer h.Unlock()
+	if h.containers == nil {
+		return fmt.Errorf("not initialized")
+	}
+	in, err := newInotify()
+	if err != nil {
+		return err
+	}
+	w := &eventWatcher{in: in, ch: ch, done: make(chan struct{}), ids: make(map[string]string)}
+	if h.events != nil {
+		h.events.close()
+	}
+	h.events = w
+	w.watch(h.containers)
+	go w.run()
+	return nil
+}
+
+// close stops the watcher, see inotify.close.
+func (w *eventWatcher) close() {
+	w.in.close()
+}
+
+// watch adds watches for containers not yet being watched.
+func (w *eventWatcher) watch(containers Cmap) {
+	w.Lock()
+	defer w.Unlock()
+	for id, cs := range containers {
+		p, ok := cs.files[cgroupEventsFile]
+		if !ok {
+			continue
+		}
+		if err := w.in.add(p, inModify); err == nil {
+			w.ids[p] = id
+		}
+	}
+}
+
+func (w *eventWatcher) run() {
+	defer close(w.done)
+	for {
+		events, err := w.in.read()
+		if err != nil {
+			return
+		}
+		for _, ev := range events {
+			w.Lock()
+			id, ok := w.ids[ev.path]
+			if ev.mask&inIgnored != 0 {
+				delete(w.ids, ev.path)
+			}
+			w.Unlock()
+			if !ok || ev.mask&inModify == 0 {
+				continue
+			}
+			b, err := readFile(ev.path)
+			if err != nil {
+				continue
+			}
+			c := CgroupStat{}
+			c.createEvents(string(b))
+			select {
+			case w.ch <- CgroupEvent{ID: id, Populated: c.Populated, Frozen: c.Frozen, Timestamp: c.Timestamp}:
+			default:
+			}
+		}
+	}
+}
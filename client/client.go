@@ -0,0 +1,235 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package client reads gocstat statistics from a remote agent, see package
+// github.com/porjo/gocstat/agent. It mirrors the ReadStats and Watch
+// functions of package gocstat.
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/internal/mergepatch"
+)
+
+// Client reads statistics from the agent at URL.
+type Client struct {
+	// base URL of the agent, e.g. http://host:9595
+	URL string
+	// used to make requests, http.DefaultClient if nil. Set its
+	// Transport's TLSClientConfig to use TLS, see TLSConfig
+	HTTPClient *http.Client
+	// sent as a bearer token if not empty, see agent.RequireToken
+	Token string
+	// ask for delta encoded Watch streams, which only carry changes
+	// between intervals, see agent.Frame
+	Delta bool
+	// expression selecting the containers of Watch streams, applied by the
+	// agent, see gocstat.ParseFilter
+	Filter string
+}
+
+// New returns a Client for the agent at url.
+func New(url string) *Client {
+	return &Client{URL: url}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("agent returned %s for %s", resp.Status, path)
+	}
+	return resp, nil
+}
+
+// TLSConfig returns a client TLS configuration trusting the PEM encoded CAs
+// in caFile, or the system roots if it is empty. If certFile and keyFile are
+// not empty the certificate is presented to the agent (mutual TLS).
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in '%s'", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ReadStats retrieves the agent's current container statistics.
+func (c *Client) ReadStats() (gocstat.Cmap, error) {
+	resp, err := c.get("/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stats := make(gocstat.Cmap)
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Watch asks the agent to send its statistics every interval and delivers
+// them on the returned channel until the returned stop function is called
+// or the connection fails, after which the channel is closed.
+//
+// As with gocstat.Watch, sending does not block and errChan is optional.
+func (c *Client) Watch(interval time.Duration, errChan chan<- error) (<-chan gocstat.Cmap, func()) {
+	ch := make(chan gocstat.Cmap, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	var mu sync.Mutex
+	var resp *http.Response
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			mu.Lock()
+			if resp != nil {
+				resp.Body.Close()
+			}
+			mu.Unlock()
+		})
+	}
+	sendErr := func(err error) {
+		if errChan == nil {
+			return
+		}
+		select {
+		case errChan <- err:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		path := "/watch?interval=" + url.QueryEscape(interval.String())
+		if c.Delta {
+			path += "&delta=1"
+		}
+		if c.Filter != "" {
+			path += "&filter=" + url.QueryEscape(c.Filter)
+		}
+		r, err := c.get(path)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		mu.Lock()
+		resp = r
+		mu.Unlock()
+		select {
+		case <-done:
+			r.Body.Close()
+			return
+		default:
+		}
+		defer r.Body.Close()
+
+		dec := json.NewDecoder(r.Body)
+		var f frame
+		for {
+			stats, err := f.decode(dec, c.Delta)
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					sendErr(err)
+				}
+				return
+			}
+			select {
+			case ch <- stats:
+			default:
+			}
+		}
+	}()
+	return ch, stop
+}
+
+// frame decodes Watch streams, tracking the current document of delta
+// encoded streams.
+type frame struct {
+	Keyframe bool                   `json:"keyframe"`
+	Data     map[string]interface{} `json:"data"`
+
+	doc map[string]interface{}
+}
+
+func (f *frame) decode(dec *json.Decoder, delta bool) (gocstat.Cmap, error) {
+	stats := make(gocstat.Cmap)
+	if !delta {
+		err := dec.Decode(&stats)
+		return stats, err
+	}
+
+	dec.UseNumber()
+	f.Keyframe, f.Data = false, nil
+	if err := dec.Decode(f); err != nil {
+		return nil, err
+	}
+	if f.Keyframe {
+		f.doc = f.Data
+	} else if f.doc == nil {
+		return nil, fmt.Errorf("delta frame received before a keyframe")
+	} else {
+		mergepatch.Apply(f.doc, f.Data)
+	}
+	b, err := json.Marshal(f.doc)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &stats)
+	return stats, err
+}
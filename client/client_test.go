@@ -0,0 +1,118 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package client
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/agent"
+)
+
+func TestClient(t *testing.T) {
+	gocstat.BasePath = "../testdata/cgroup"
+	if err := gocstat.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(agent.Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 container, found %d", len(stats))
+	}
+	for _, stat := range stats {
+		if stat.Memory.RSS == 0 || stat.CPU.User == 0 {
+			t.Errorf("expected non-zero Memory.RSS and CPU.User")
+		}
+	}
+
+	ch, stop := c.Watch(100*time.Millisecond, nil)
+	for i := 0; i < 2; i++ {
+		select {
+		case stats := <-ch:
+			if len(stats) != 1 {
+				t.Errorf("expected 1 container, found %d", len(stats))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for statistics")
+		}
+	}
+	stop()
+	for range ch {
+	}
+}
+
+func TestClientDelta(t *testing.T) {
+	srv := httptest.NewServer(agent.Handler())
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Delta = true
+
+	expected, err := gocstat.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, stop := c.Watch(100*time.Millisecond, nil)
+	defer stop()
+	// the first frame is a keyframe, the following ones are patches
+	for i := 0; i < 3; i++ {
+		select {
+		case stats := <-ch:
+			if len(stats) != len(expected) {
+				t.Fatalf("expected %d containers, found %d", len(expected), len(stats))
+			}
+			for id, stat := range expected {
+				if stats[id] == nil || stats[id].Memory.RSS != stat.Memory.RSS ||
+					stats[id].Limits.CPUQuota != stat.Limits.CPUQuota {
+					t.Errorf("container %s: statistics differ after applying deltas", id)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for statistics")
+		}
+	}
+}
+
+func TestClientTLSToken(t *testing.T) {
+	srv := httptest.NewTLSServer(agent.RequireToken("s3cret", agent.Handler()))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.HTTPClient = srv.Client()
+	if _, err := c.ReadStats(); err == nil {
+		t.Errorf("expected request without a token to fail")
+	}
+	c.Token = "wrong"
+	if _, err := c.ReadStats(); err == nil {
+		t.Errorf("expected request with the wrong token to fail")
+	}
+	c.Token = "s3cret"
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Errorf("expected 1 container, found %d", len(stats))
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// gocstat-agent serves container statistics over HTTP for remote
+// collection with package github.com/porjo/gocstat/client.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/agent"
+	"github.com/porjo/gocstat/docker"
+	"github.com/porjo/gocstat/ecs"
+	"github.com/porjo/gocstat/nomad"
+	"github.com/porjo/gocstat/rootless"
+)
+
+func main() {
+	listen := flag.String("listen", ":9595", "address to listen on")
+	basePath := flag.String("base-path", gocstat.BasePath, "cgroup directory to search for containers")
+	dirRegexp := flag.String("container-regexp", gocstat.ContainerDirRegexp, "regexp matching container directories, the first group is used as the container ID")
+	runtimes := flag.String("runtimes", "", "comma separated runtimes whose containers are tracked, of docker, containerd, crio, podman, lxc and systemd, instead of -container-regexp")
+	fallbackIDs := flag.Bool("fallback-ids", false, "also report cgroups with processes not matching -container-regexp, using their directory name as ID")
+	maxStaleness := flag.Duration("max-staleness", time.Second, "share statistics between requests when younger than this")
+	align := flag.Bool("align", false, "align /watch ticks to the wall clock")
+	jitter := flag.Duration("jitter", 0, "delay /watch ticks by a random duration up to this much")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, enables TLS")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	clientCA := flag.String("tls-client-ca", "", "PEM CA file, clients must present a certificate signed by one of its CAs")
+	tokenFile := flag.String("token-file", "", "file holding a bearer token clients must present")
+	useECS := flag.Bool("ecs", false, "discover Amazon ECS containers and label them from the local ECS agent, -container-regexp is ignored")
+	useNomad := flag.Bool("nomad", false, "discover Nomad exec and raw_exec tasks and label them with their allocation and task, -container-regexp is ignored")
+	useDocker := flag.Bool("docker", false, "label containers with their name, image and Compose and Swarm project from the Docker daemon")
+	hostLabels := flag.String("host-labels", "", "comma separated key=value labels added to every container, such as host=$(hostname),region=eu-west-1")
+	idKeyFile := flag.String("id-hash-key-file", "", "file holding a key container IDs are hashed with before being served")
+	idLength := flag.Int("id-length", 0, "truncate served container IDs, or their hashes with -id-hash-key-file, to this many characters")
+	stateFile := flag.String("state-file", "", "file the last statistics are saved to on exit and restored from on start, so rates resume after a restart")
+	useRootless := flag.Bool("rootless", false, "discover rootless Docker and Podman containers in the delegated cgroup tree of the current user, -base-path and -container-regexp are ignored")
+	flag.Parse()
+
+	gocstat.BasePath = *basePath
+	gocstat.ContainerDirRegexp = *dirRegexp
+	gocstat.FallbackIDs = *fallbackIDs
+	if *runtimes != "" {
+		for _, name := range strings.Split(*runtimes, ",") {
+			m, ok := gocstat.BuiltinMatchers[strings.TrimSpace(name)]
+			if !ok {
+				log.Fatalf("unknown runtime '%s'", name)
+			}
+			gocstat.Matchers = append(gocstat.Matchers, m)
+		}
+	}
+	if *useECS {
+		gocstat.ContainerDirRegexp = ecs.DirRegexp
+	}
+	if *useNomad {
+		gocstat.ContainerDirRegexp = nomad.DirRegexp
+	}
+	if *useRootless {
+		rootless.Configure(os.Getuid())
+	}
+	labels, err := gocstat.ParseLabels(*hostLabels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gocstat.HostLabels = labels
+	if *idKeyFile != "" {
+		b, err := ioutil.ReadFile(*idKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gocstat.ExportID = gocstat.HashIDs(strings.TrimSpace(string(b)), *idLength)
+	} else if *idLength > 0 {
+		gocstat.ExportID = gocstat.TruncateIDs(*idLength)
+	}
+	gocstat.MaxStaleness = *maxStaleness
+	// keep the collections of the watchdog from being served from cache
+	if timeout := watchdogInterval(); timeout > 0 && gocstat.MaxStaleness >= timeout/2 {
+		gocstat.MaxStaleness = timeout / 4
+	}
+	gocstat.AlignTicks = *align
+	gocstat.TickJitter = *jitter
+	errChan := make(chan error, 1)
+	if err := gocstat.Init(errChan); err != nil {
+		log.Fatal(err)
+	}
+	if *stateFile != "" {
+		if err := loadState(*stateFile); err != nil {
+			log.Printf("restoring state: %s", err)
+		}
+		saveStateOnExit(*stateFile)
+	}
+	go func() {
+		for err := range errChan {
+			var ce *gocstat.ContainerError
+			if errors.As(err, &ce) {
+				log.Printf("scanning for containers: %s", err)
+				continue
+			}
+			log.Fatalf("scanning for containers: %s", err)
+		}
+	}()
+	if *useECS {
+		ecsErrs := make(chan error, 1)
+		ecs.New().Run(time.Minute, ecsErrs)
+		go func() {
+			for err := range ecsErrs {
+				log.Printf("labelling ECS containers: %s", err)
+			}
+		}()
+	}
+
+	if *useNomad {
+		nomadErrs := make(chan error, 1)
+		nomad.Run(time.Minute, nomadErrs)
+		go func() {
+			for err := range nomadErrs {
+				log.Printf("labelling Nomad tasks: %s", err)
+			}
+		}()
+	}
+	if *useDocker {
+		dockerErrs := make(chan error, 1)
+		docker.New().Run(time.Minute, dockerErrs)
+		go func() {
+			for err := range dockerErrs {
+				log.Printf("labelling Docker containers: %s", err)
+			}
+		}()
+	}
+
+	handler := agent.Handler()
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			log.Fatalf("token file '%s' is empty", *tokenFile)
+		}
+		handler = agent.RequireToken(token, handler)
+	}
+	srv := &http.Server{Addr: *listen, Handler: handler}
+
+	if *tlsCert == "" && *clientCA != "" {
+		log.Fatal("-tls-client-ca requires -tls-cert")
+	}
+	if *tlsCert != "" {
+		cfg, err := agent.TLSConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig = cfg
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("notifying systemd: %s", err)
+	}
+	if timeout := watchdogInterval(); timeout > 0 {
+		go watchdog(timeout)
+	}
+
+	if srv.TLSConfig == nil {
+		log.Printf("listening on %s", *listen)
+		log.Fatal(srv.Serve(ln))
+	}
+	log.Printf("listening on %s (TLS)", *listen)
+	log.Fatal(srv.ServeTLS(ln, "", ""))
+}
@@ -0,0 +1,406 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"io"
+	"time"
+)
+
+// Config configures a Collector, see New
+type Config struct {
+	// directory to start search, BasePath if empty
+	BasePath string
+	// process directories which match this regex, see ContainerDirRegexp,
+	// which is used if empty
+	ContainerDirRegexp string
+	// matchers of the containers to track, see Matchers, which are used if
+	// nil. ContainerDirRegexp is ignored unless both are empty
+	Matchers []Matcher
+	// interval between scans of BasePath for containers, 30 seconds if
+	// zero
+	ScanInterval time.Duration
+	// optional, used for reporting scanning errors like the errChan of
+	// Init
+	Errors chan<- error
+
+	// The settings of the package variables of the same name, for this
+	// Collector only. ProcPath, SysPath, WatchBlockTimeout and the
+	// Adaptive thresholds take the value of the package variable when zero
+	ProcPath           string
+	SysPath            string
+	WatchDirs          bool
+	ParentStats        bool
+	FallbackIDs        bool
+	HierarchicalMemory bool
+	HierarchicalBlkIO  bool
+	BlkIODevices       []string
+	ResolveLoopDevices bool
+	SecureReads        bool
+	SkipUnreadable     bool
+	ExcludeEmpty       bool
+	MaxStaleness       time.Duration
+	HistoryRetention   time.Duration
+	AlignTicks         bool
+	TickJitter         time.Duration
+	WatchBackpressure  BackpressurePolicy
+	WatchBlockTimeout  time.Duration
+	SelfCheck          bool
+	SubsystemIntervals map[string]time.Duration
+	AdaptiveSampling   bool
+	AdaptiveMaxLatency time.Duration
+	AdaptiveMaxLoad    float64
+	AdaptiveMaxFactor  int
+	MaxContainers      int
+	ContainerOverflow  OverflowPolicy
+	MemoryBudget       int
+	TaskStates         bool
+	SchedStats         bool
+	FSUsage            bool
+	FSPathTemplate     string
+	TmpfsUsage         bool
+	NetStats           bool
+	PSSUsage           bool
+	CollectionBudget   time.Duration
+	CollectionDeadline time.Duration
+	Accelerators       AcceleratorFunc
+	HostLabels         map[string]string
+	BeforeCollect      func()
+	OnContainer        func(id string, cs *Cstats) bool
+	AfterCollect       func(stats Cmap, took time.Duration, err error)
+}
+
+// packageConfig returns the settings of the package variables, which
+// apply to the package level functions.
+func packageConfig() *Config {
+	return &Config{
+		BasePath:           BasePath,
+		ContainerDirRegexp: ContainerDirRegexp,
+		Matchers:           Matchers,
+		ScanInterval:       namesUpdateInterval,
+		ProcPath:           ProcPath,
+		SysPath:            SysPath,
+		WatchDirs:          WatchDirs,
+		ParentStats:        ParentStats,
+		FallbackIDs:        FallbackIDs,
+		HierarchicalMemory: HierarchicalMemory,
+		HierarchicalBlkIO:  HierarchicalBlkIO,
+		BlkIODevices:       BlkIODevices,
+		ResolveLoopDevices: ResolveLoopDevices,
+		SecureReads:        SecureReads,
+		SkipUnreadable:     SkipUnreadable,
+		ExcludeEmpty:       ExcludeEmpty,
+		MaxStaleness:       MaxStaleness,
+		HistoryRetention:   HistoryRetention,
+		AlignTicks:         AlignTicks,
+		TickJitter:         TickJitter,
+		WatchBackpressure:  WatchBackpressure,
+		WatchBlockTimeout:  WatchBlockTimeout,
+		SelfCheck:          SelfCheck,
+		SubsystemIntervals: SubsystemIntervals,
+		AdaptiveSampling:   AdaptiveSampling,
+		AdaptiveMaxLatency: AdaptiveMaxLatency,
+		AdaptiveMaxLoad:    AdaptiveMaxLoad,
+		AdaptiveMaxFactor:  AdaptiveMaxFactor,
+		MaxContainers:      MaxContainers,
+		ContainerOverflow:  ContainerOverflow,
+		MemoryBudget:       MemoryBudget,
+		TaskStates:         TaskStates,
+		SchedStats:         SchedStats,
+		FSUsage:            FSUsage,
+		FSPathTemplate:     FSPathTemplate,
+		TmpfsUsage:         TmpfsUsage,
+		NetStats:           NetStats,
+		PSSUsage:           PSSUsage,
+		CollectionBudget:   CollectionBudget,
+		CollectionDeadline: CollectionDeadline,
+		Accelerators:       Accelerators,
+		HostLabels:         HostLabels,
+		BeforeCollect:      BeforeCollect,
+		OnContainer:        OnContainer,
+		AfterCollect:       AfterCollect,
+	}
+}
+
+// config returns the settings of h: those of its Collector, or the package
+// variables, read anew on every call.
+func (h *holder) config() *Config {
+	if h.cfg != nil {
+		return h.cfg
+	}
+	return packageConfig()
+}
+
+// config returns the settings of the holder tracking c, or the package
+// variables if c is not tracked by a Collector.
+func (c *Cstats) config() *Config {
+	if c.cfg != nil {
+		return c.cfg
+	}
+	return packageConfig()
+}
+
+// Collector collects the statistics of the containers found under its own
+// BasePath, independently of the package level functions and of other
+// Collectors, so that several can run in one process. Its settings are
+// those of its Config, the package variables only apply to the package
+// level functions, with the exception of AllowWrites and ExportID.
+type Collector struct {
+	h *holder
+}
+
+// New returns a Collector configured by cfg. Like Init, the cgroup
+// controllers available are probed and BasePath is scanned once before New
+// returns, then rescanned until Close is called.
+func New(cfg Config) (*Collector, error) {
+	if cfg.BasePath == "" {
+		cfg.BasePath = BasePath
+	}
+	if cfg.ContainerDirRegexp == "" {
+		cfg.ContainerDirRegexp = ContainerDirRegexp
+	}
+	if cfg.Matchers == nil {
+		cfg.Matchers = Matchers
+	}
+	if cfg.ScanInterval <= 0 {
+		cfg.ScanInterval = namesUpdateInterval
+	}
+	if cfg.ProcPath == "" {
+		cfg.ProcPath = ProcPath
+	}
+	if cfg.SysPath == "" {
+		cfg.SysPath = SysPath
+	}
+	if cfg.WatchBlockTimeout <= 0 {
+		cfg.WatchBlockTimeout = WatchBlockTimeout
+	}
+	if cfg.AdaptiveMaxLatency <= 0 {
+		cfg.AdaptiveMaxLatency = AdaptiveMaxLatency
+	}
+	if cfg.AdaptiveMaxLoad <= 0 {
+		cfg.AdaptiveMaxLoad = AdaptiveMaxLoad
+	}
+	if cfg.AdaptiveMaxFactor <= 0 {
+		cfg.AdaptiveMaxFactor = AdaptiveMaxFactor
+	}
+	c := &Collector{h: &holder{cfg: &cfg}}
+	if err := c.h.init(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadStats is like the package level ReadStats, for the containers of c.
+func (c *Collector) ReadStats() (Cmap, error) {
+	return c.h.readStats()
+}
+
+// ReadCachedStats is like the package level ReadCachedStats, for the
+// containers of c.
+func (c *Collector) ReadCachedStats() (Cmap, error) {
+	return c.h.readCachedStats()
+}
+
+// StartAccounting is like the package level StartAccounting, for the
+// containers of c.
+func (c *Collector) StartAccounting(maxGap time.Duration) {
+	c.h.startAccounting(maxGap)
+}
+
+// StopAccounting is like the package level StopAccounting, for the
+// containers of c.
+func (c *Collector) StopAccounting() {
+	c.h.stopAccounting()
+}
+
+// ReadAccounting is like the package level ReadAccounting, for the
+// containers of c.
+func (c *Collector) ReadAccounting(reset bool) map[string]Usage {
+	return c.h.readAccounting(reset)
+}
+
+// NotifyAnomalies is like the package level NotifyAnomalies, for the
+// containers of c.
+func (c *Collector) NotifyAnomalies(ch chan<- Anomaly, window time.Duration, zscore float64) error {
+	return c.h.notifyAnomalies(ch, window, zscore)
+}
+
+// RecordBaseline is like the package level RecordBaseline, for the
+// containers of c.
+func (c *Collector) RecordBaseline(window time.Duration) Baseline {
+	return c.h.recordBaseline(window)
+}
+
+// Retained is like the package level Retained, for the containers of c.
+func (c *Collector) Retained() RetainedMemory {
+	return c.h.lockedRetained()
+}
+
+// Capabilities is like the package level Capabilities, for the
+// containers of c.
+func (c *Collector) Capabilities() CgroupInfo {
+	return c.h.capabilities()
+}
+
+// NotifyCgroupEvents is like the package level NotifyCgroupEvents, for the
+// containers of c.
+func (c *Collector) NotifyCgroupEvents(ch chan<- CgroupEvent) error {
+	return c.h.notifyCgroupEvents(ch)
+}
+
+// SetMemoryLimit is like the package level SetMemoryLimit, for the
+// containers of c.
+func (c *Collector) SetMemoryLimit(id string, bytes uint64) error {
+	return c.h.setMemoryLimit(id, bytes)
+}
+
+// SetCPUQuota is like the package level SetCPUQuota, for the containers of c.
+func (c *Collector) SetCPUQuota(id string, quota, period uint64) error {
+	return c.h.setCPUQuota(id, quota, period)
+}
+
+// SetIOMax is like the package level SetIOMax, for the containers of c.
+func (c *Collector) SetIOMax(id string, limit IOLimit) error {
+	return c.h.setIOMax(id, limit)
+}
+
+// Freeze is like the package level Freeze, for the containers of c.
+func (c *Collector) Freeze(id string) error {
+	return c.h.setFrozen(id, true)
+}
+
+// Thaw is like the package level Thaw, for the containers of c.
+func (c *Collector) Thaw(id string) error {
+	return c.h.setFrozen(id, false)
+}
+
+// Kill is like the package level Kill, for the containers of c.
+func (c *Collector) Kill(id string) error {
+	return c.h.kill(id)
+}
+
+// AddDerived is like the package level AddDerived, for the containers of c.
+func (c *Collector) AddDerived(fn DerivedFunc) func() {
+	return c.h.addDerived(fn)
+}
+
+// Summarize is like the package level Summarize, for the containers of c.
+func (c *Collector) Summarize(id string, window time.Duration) (Summary, error) {
+	return c.h.summary(id, window)
+}
+
+// SetMetadata is like the package level SetMetadata, for the containers of c.
+func (c *Collector) SetMetadata(id, key, value string) error {
+	return c.h.setMetadata(id, key, value)
+}
+
+// NotifyOverflow is like the package level NotifyOverflow, for the
+// containers of c.
+func (c *Collector) NotifyOverflow(ch chan<- Overflow) {
+	c.h.notifyOverflow(ch)
+}
+
+// ListContainers is like the package level ListContainers, for the
+// containers of c.
+func (c *Collector) ListContainers() []ContainerInfo {
+	return c.h.listContainers()
+}
+
+// Pause is like the package level Pause, for the containers of c.
+func (c *Collector) Pause(id string) error {
+	return c.h.setPaused(id, true)
+}
+
+// Resume is like the package level Resume, for the containers of c.
+func (c *Collector) Resume(id string) error {
+	return c.h.setPaused(id, false)
+}
+
+// ProbePermissions is like the package level ProbePermissions, for the
+// containers of c.
+func (c *Collector) ProbePermissions() (PermissionReport, error) {
+	return c.h.probePermissions()
+}
+
+// LastCollection is like the package level LastCollection, for the
+// containers of c.
+func (c *Collector) LastCollection() CollectionReport {
+	return c.h.lastCollection()
+}
+
+// SetPriority is like the package level SetPriority, for the containers of c.
+func (c *Collector) SetPriority(id string, priority int) error {
+	return c.h.setPriority(id, priority)
+}
+
+// NotifyPruned is like the package level NotifyPruned, for the
+// containers of c.
+func (c *Collector) NotifyPruned(ch chan<- Pruned) {
+	c.h.notifyPruned(ch)
+}
+
+// Replay is like the package level Replay, for the containers of c.
+func (c *Collector) Replay(dec *Decoder, fn func(Snapshot) error) error {
+	return c.h.replay(dec, fn)
+}
+
+// SetSampleInterval is like the package level SetSampleInterval, for the
+// containers of c.
+func (c *Collector) SetSampleInterval(id, subsystem string, interval time.Duration) error {
+	return c.h.setSampleInterval(id, subsystem, interval)
+}
+
+// AddScope is like the package level AddScope, for the containers of c.
+func (c *Collector) AddScope(scope Scope) error {
+	return c.h.addScope(scope)
+}
+
+// RemoveScope is like the package level RemoveScope, for the containers of c.
+func (c *Collector) RemoveScope(name string) {
+	c.h.removeScope(name)
+}
+
+// AddSink is like the package level AddSink, for the containers of c.
+func (c *Collector) AddSink(sink Sink, selector string, errChan chan<- error) (func(), error) {
+	return c.h.addSink(sink, selector, errChan)
+}
+
+// SaveState is like the package level SaveState, for the containers of c.
+func (c *Collector) SaveState(w io.Writer) error {
+	return c.h.saveState(w)
+}
+
+// LoadState is like the package level LoadState, for the containers of c.
+func (c *Collector) LoadState(r io.Reader) error {
+	return c.h.loadState(r)
+}
+
+// Watch is like the package level Watch, for the containers of c.
+func (c *Collector) Watch(interval time.Duration, errChan chan<- error) (<-chan Cmap, func()) {
+	return c.h.watch(interval, nil, errChan)
+}
+
+// WatchFilter is like the package level WatchFilter, for the containers of c.
+func (c *Collector) WatchFilter(interval time.Duration, filter *Filter, errChan chan<- error) (<-chan Cmap, func()) {
+	return c.h.watch(interval, filter, errChan)
+}
+
+// Close stops rescanning for containers and frees the containers tracked,
+// like Shutdown.
+func (c *Collector) Close() {
+	c.h.shutdown(nil)
+}
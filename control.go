@@ -0,0 +1,231 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	// cgroup v1
+	freezerStateFile = "freezer.state"
+	// cgroup v2
+	cgroupFreezeFile = "cgroup.freeze"
+	cgroupKillFile   = "cgroup.kill"
+)
+
+// controlFiles are the files written by Freeze, Thaw and Kill, and other
+// files read on demand rather than on every collection.
+var controlFiles = map[string]bool{
+	freezerStateFile: true,
+	cgroupFreezeFile: true,
+	cgroupKillFile:   true,
+	devicesListFile:  true,
+}
+
+// Enable the functions which modify cgroups, such as SetMemoryLimit.
+// They return an error unless this is set
+var AllowWrites = false
+
+// SetMemoryLimit sets the memory limit of container id in bytes.
+// Pass Unlimited to remove the limit.
+func SetMemoryLimit(id string, bytes uint64) error {
+	return statsHolder.setMemoryLimit(id, bytes)
+}
+
+func (h *holder) setMemoryLimit(id string, bytes uint64) error {
+	return h.writeFiles(id, func(cs *Cstats) ([]fileWrite, error) {
+		files := cs.limitFiles
+		if p, ok := files[memMaxFile]; ok {
+			return []fileWrite{{p, formatLimit(bytes, "max")}}, nil
+		}
+		if p, ok := files[memLimitFile]; ok {
+			return []fileWrite{{p, formatLimit(bytes, "-1")}}, nil
+		}
+		return nil, fmt.Errorf("no memory limit file found for container %s", id)
+	})
+}
+
+// SetCPUQuota sets the CPU time in microseconds container id may use per
+// period, also in microseconds. Pass Unlimited as quota to remove the limit.
+func SetCPUQuota(id string, quota, period uint64) error {
+	return statsHolder.setCPUQuota(id, quota, period)
+}
+
+func (h *holder) setCPUQuota(id string, quota, period uint64) error {
+	return h.writeFiles(id, func(cs *Cstats) ([]fileWrite, error) {
+		files := cs.limitFiles
+		if p, ok := files[cPUMaxFile]; ok {
+			return []fileWrite{{p, formatLimit(quota, "max") + " " + strconv.FormatUint(period, 10)}}, nil
+		}
+		quotaPath, ok1 := files[cPUQuotaFile]
+		periodPath, ok2 := files[cPUPeriodFile]
+		if ok1 && ok2 {
+			// the period is set first as the quota is validated against it
+			return []fileWrite{
+				{periodPath, strconv.FormatUint(period, 10)},
+				{quotaPath, formatLimit(quota, "-1")},
+			}, nil
+		}
+		return nil, fmt.Errorf("no CPU quota files found for container %s", id)
+	})
+}
+
+// SetIOMax sets the I/O throttling limits of container id for the block
+// device limit.Major:limit.Minor. Fields set to Unlimited remove that limit,
+// fields set to 0 leave it unchanged.
+func SetIOMax(id string, limit IOLimit) error {
+	return statsHolder.setIOMax(id, limit)
+}
+
+func (h *holder) setIOMax(id string, limit IOLimit) error {
+	dev := fmt.Sprintf("%d:%d", limit.Major, limit.Minor)
+	limits := []struct {
+		key, file string
+		v         uint64
+	}{
+		{"rbps", blkIOReadBpsFile, limit.ReadBps},
+		{"wbps", blkIOWriteBpsFile, limit.WriteBps},
+		{"riops", blkIOReadIOPSFile, limit.ReadIOPS},
+		{"wiops", blkIOWriteIOPSFile, limit.WriteIOPS},
+	}
+	return h.writeFiles(id, func(cs *Cstats) ([]fileWrite, error) {
+		files := cs.limitFiles
+		if p, ok := files[iOMaxFile]; ok {
+			// keys left out keep their value
+			content := dev
+			for _, l := range limits {
+				if l.v != 0 {
+					content += " " + l.key + "=" + formatLimit(l.v, "max")
+				}
+			}
+			if content == dev {
+				return nil, nil
+			}
+			return []fileWrite{{p, content}}, nil
+		}
+		// cgroup v1 removes a device's limit when it is set to 0
+		var writes []fileWrite
+		for _, l := range limits {
+			if l.v == 0 {
+				continue
+			}
+			p, ok := files[l.file]
+			if !ok {
+				return nil, fmt.Errorf("no %s file found for container %s", l.file, id)
+			}
+			writes = append(writes, fileWrite{p, dev + " " + formatLimit(l.v, "0")})
+		}
+		return writes, nil
+	})
+}
+
+// Freeze suspends every process in container id.
+func Freeze(id string) error {
+	return statsHolder.setFrozen(id, true)
+}
+
+// Thaw resumes the processes of container id after Freeze.
+func Thaw(id string) error {
+	return statsHolder.setFrozen(id, false)
+}
+
+func (h *holder) setFrozen(id string, frozen bool) error {
+	return h.writeFiles(id, func(cs *Cstats) ([]fileWrite, error) {
+		if p, ok := cs.controlFiles[cgroupFreezeFile]; ok {
+			if frozen {
+				return []fileWrite{{p, "1"}}, nil
+			}
+			return []fileWrite{{p, "0"}}, nil
+		}
+		if p, ok := cs.controlFiles[freezerStateFile]; ok {
+			if frozen {
+				return []fileWrite{{p, "FROZEN"}}, nil
+			}
+			return []fileWrite{{p, "THAWED"}}, nil
+		}
+		return nil, fmt.Errorf("no freezer file found for container %s", id)
+	})
+}
+
+// Kill sends SIGKILL to every process in container id, cgroup v2 only.
+func Kill(id string) error {
+	return statsHolder.kill(id)
+}
+
+func (h *holder) kill(id string) error {
+	return h.writeFiles(id, func(cs *Cstats) ([]fileWrite, error) {
+		if p, ok := cs.controlFiles[cgroupKillFile]; ok {
+			return []fileWrite{{p, "1"}}, nil
+		}
+		return nil, fmt.Errorf("no %s file found for container %s, cgroup v2 is required", cgroupKillFile, id)
+	})
+}
+
+type fileWrite struct {
+	path    string
+	content string
+}
+
+// writeFiles writes the files chosen by plan for container id, then
+// rereads the container's limits. Every file is opened before any is
+// written, so a missing file changes nothing.
+func (h *holder) writeFiles(id string, plan func(cs *Cstats) ([]fileWrite, error)) error {
+	if !AllowWrites {
+		return fmt.Errorf("writes are disabled, see AllowWrites")
+	}
+	h.Lock()
+	defer h.Unlock()
+	cs, ok := h.containers[id]
+	if !ok {
+		return fmt.Errorf("container %s not found", id)
+	}
+	writes, err := plan(cs)
+	if err != nil {
+		return err
+	}
+	// unlike ioutil.WriteFile, never create or truncate files
+	fs := make([]*os.File, 0, len(writes))
+	defer func() {
+		for _, f := range fs {
+			f.Close()
+		}
+	}()
+	for _, w := range writes {
+		f, err := openFile(w.path, os.O_WRONLY)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, f)
+	}
+	for i, w := range writes {
+		if _, err := fs[i].WriteString(w.content); err != nil {
+			return fmt.Errorf("error writing '%s', err %s", w.path, err)
+		}
+	}
+	return cs.readLimits()
+}
+
+// formatLimit formats v for a cgroup file, using unlimited for Unlimited.
+func formatLimit(v uint64, unlimited string) string {
+	if v == Unlimited {
+		return unlimited
+	}
+	return strconv.FormatUint(v, 10)
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cgroup v1, in USER_HZ ticks
+	cPUFile = "cpuacct.stat"
+	// cgroup v2 usage in microseconds. The cgroup v1 cpu controller has a
+	// file of the same name holding throttling statistics only
+	cPUStatFile = "cpu.stat"
+	// cgroup v1 total and per CPU usage, in nanoseconds
+	cPUUsageFile       = "cpuacct.usage"
+	cPUUsagePerCPUFile = "cpuacct.usage_percpu"
+)
+
+// Unit of the CPUStat counters
+type CPUUnit string
+
+const (
+	// USER_HZ ticks, 1/100th of a second, from cgroup v1 cpuacct.stat
+	CPUUnitTicks CPUUnit = "ticks"
+	// microseconds, from cgroup v2 cpu.stat
+	CPUUnitMicroseconds CPUUnit = "usec"
+)
+
+// CPU time consumed by the container
+type CPUStat struct {
+	// time spent in user and kernel mode, in Units
+	User   uint64
+	System uint64
+	// total time, in Units. cgroup v2 only, where it is accounted
+	// separately from User and System and may differ slightly from their sum
+	Usage uint64
+	// unit of the counters, CPUUnitTicks if empty
+	Units CPUUnit
+	// total time in nanoseconds, and the same for each CPU of the host,
+	// in the order of their numbers. cgroup v1 only (cpuacct.usage,
+	// cpuacct.usage_percpu)
+	UsageNanoseconds uint64
+	PerCPU           []uint64
+	// number of periods in which the cgroup used more than its quota by
+	// bursting (cpu.max.burst, cpu.cfs_burst_us), and the time it spent
+	// beyond its quota. From cpu.stat, on kernels 5.14 and later
+	NrBursts  uint64
+	BurstTime time.Duration
+	// number of CFS periods elapsed with the cgroup runnable, those in
+	// which it was throttled for exhausting its quota, and the time it
+	// spent throttled. From cpu.stat
+	NrPeriods     uint64
+	NrThrottled   uint64
+	ThrottledTime time.Duration
+	// keys of cpuacct.stat other than user and system, added by some
+	// kernels, cgroup v1 only
+	Extra     map[string]uint64
+	Timestamp time.Time
+}
+
+// UserTime returns User as a duration.
+func (c CPUStat) UserTime() time.Duration {
+	return c.duration(c.User)
+}
+
+// SystemTime returns System as a duration.
+func (c CPUStat) SystemTime() time.Duration {
+	return c.duration(c.System)
+}
+
+// Total returns the total CPU time, Usage or UsageNanoseconds when known
+// and otherwise User plus System.
+func (c CPUStat) Total() time.Duration {
+	if c.Usage != 0 {
+		return c.duration(c.Usage)
+	}
+	if c.UsageNanoseconds != 0 {
+		return time.Duration(c.UsageNanoseconds)
+	}
+	return c.duration(c.User + c.System)
+}
+
+// parsePerCPU parses cpuacct.usage_percpu, a line of values.
+func parsePerCPU(content string) []uint64 {
+	fields := strings.Fields(content)
+	v := make([]uint64, 0, len(fields))
+	for _, f := range fields {
+		n, _ := strconv.ParseUint(f, 10, 64)
+		v = append(v, n)
+	}
+	return v
+}
+
+func (c CPUStat) duration(v uint64) time.Duration {
+	switch c.Units {
+	case CPUUnitMicroseconds:
+		return time.Duration(v) * time.Microsecond
+	case CPUUnitTicks, "":
+		return time.Duration(v) * time.Second / userHZ
+	}
+	return 0
+}
+
+func (c *CPUStat) create(content string) {
+	kv := parseKeyValues(content)
+	user, ok := kv["user"]
+	system, ok2 := kv["system"]
+	if !ok || !ok2 {
+		return
+	}
+	c.User, c.System = user, system
+	delete(kv, "user")
+	delete(kv, "system")
+	c.Extra = nil
+	if len(kv) > 0 {
+		c.Extra = kv
+	}
+	c.Units = CPUUnitTicks
+	c.Timestamp = time.Now()
+}
+
+// createCPUStat parses cpu.stat. The cgroup v1 file has no usage keys,
+// only throttling and burst statistics are taken from it.
+func (c *CPUStat) createCPUStat(content string) {
+	kv := parseKeyValues(content)
+	c.NrPeriods = kv["nr_periods"]
+	c.NrThrottled = kv["nr_throttled"]
+	c.ThrottledTime = time.Duration(kv["throttled_usec"]) * time.Microsecond
+	c.NrBursts = kv["nr_bursts"]
+	c.BurstTime = time.Duration(kv["burst_usec"]) * time.Microsecond
+	// cgroup v1, in nanoseconds
+	if ns, ok := kv["throttled_time"]; ok {
+		c.ThrottledTime = time.Duration(ns)
+	}
+	if ns, ok := kv["burst_time"]; ok {
+		c.BurstTime = time.Duration(ns)
+	}
+	usage, ok := kv["usage_usec"]
+	if !ok {
+		return
+	}
+	c.Usage = usage
+	c.User = kv["user_usec"]
+	c.System = kv["system_usec"]
+	c.Units = CPUUnitMicroseconds
+	c.Timestamp = time.Now()
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"strings"
+	"time"
+)
+
+// Increase of a container's counters between two samples, and their per
+// second rates, see ComputeDeltas
+type Deltas struct {
+	// increase of each Counter field of Schema, keyed by metric name.
+	// Counters which were not read since the previous sample, see
+	// SubsystemIntervals, or which went down, as when a container restarts,
+	// are left out
+	Counters map[string]float64
+	// Counters divided by the seconds between the reads of each
+	Rates map[string]float64
+
+	// CPU time used per second as a percentage of one CPU. Unlike
+	// Utilization.CPUPct it is not relative to the quota
+	CPUPct float64
+	// block device bytes and operations per second, summed over devices
+	ReadBps   float64
+	WriteBps  float64
+	ReadIOPS  float64
+	WriteIOPS float64
+	// network bytes per second, see NetStats
+	RxBps float64
+	TxBps float64
+}
+
+// readTime returns when the counters of each top level field of Cstats
+// holding Counter fields were read.
+var readTime = map[string]func(c *Cstats) time.Time{
+	"Memory": func(c *Cstats) time.Time { return c.Memory.Timestamp },
+	"CPU":    func(c *Cstats) time.Time { return c.CPU.Timestamp },
+	"BlkIO":  func(c *Cstats) time.Time { return c.BlkIO.Bytes.Timestamp },
+	"Net":    func(c *Cstats) time.Time { return c.Net.Timestamp },
+}
+
+// ComputeDeltas returns the increase of the counters of cur since prev,
+// and their rates. Cstats.Deltas is computed with it on each collection,
+// relative to the container's previous collection.
+func ComputeDeltas(cur, prev *Cstats) Deltas {
+	d := Deltas{Counters: make(map[string]float64), Rates: make(map[string]float64)}
+	for _, f := range schema {
+		if f.Type != Counter {
+			continue
+		}
+		at, ok := readTime[strings.SplitN(f.Name, ".", 2)[0]]
+		if !ok {
+			continue
+		}
+		secs := at(cur).Sub(at(prev)).Seconds()
+		c, p := f.Value(cur), f.Value(prev)
+		if secs <= 0 || at(prev).IsZero() || c < p {
+			continue
+		}
+		d.Counters[f.Metric] = c - p
+		d.Rates[f.Metric] = (c - p) / secs
+	}
+	d.CPUPct = d.Rates["cpu_usage_seconds_total"] * 100
+	d.ReadBps = d.Rates["blkio_read_bytes_total"]
+	d.WriteBps = d.Rates["blkio_write_bytes_total"]
+	d.ReadIOPS = d.Rates["blkio_read_ops_total"]
+	d.WriteIOPS = d.Rates["blkio_write_ops_total"]
+	d.RxBps = d.Rates["net_receive_bytes_total"]
+	d.TxBps = d.Rates["net_transmit_bytes_total"]
+	return d
+}
+
+// copyFloats returns a copy of m.
+func copyFloats(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	n := make(map[string]float64, len(m))
+	for k, v := range m {
+		n[k] = v
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"math"
+	"sync"
+)
+
+// DerivedFunc computes custom metrics for container id from its current
+// sample and the previous one, which is nil for a new container
+type DerivedFunc func(id string, cur, prev *Cstats) map[string]float64
+
+type derivedMetric struct {
+	fn DerivedFunc
+}
+
+// AddDerived registers fn to be called for every container on each
+// collection, after Utilization is computed. Its results are merged into
+// Cstats.Derived, a later registration overwriting the keys of an earlier
+// one, and so reach every sink and the agent. Values which are not finite
+// are dropped. fn is called with collection in progress and must not call
+// functions of this package.
+//
+// Calling the returned function removes fn.
+func AddDerived(fn DerivedFunc) func() {
+	return statsHolder.addDerived(fn)
+}
+
+func (h *holder) addDerived(fn DerivedFunc) func() {
+	d := &derivedMetric{fn: fn}
+	h.Lock()
+	h.derived = append(h.derived, d)
+	h.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.Lock()
+			defer h.Unlock()
+			for i, r := range h.derived {
+				if r == d {
+					h.derived = append(h.derived[:i:i], h.derived[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// derive returns the derived metrics of container id. The holder must be
+// locked.
+func (h *holder) derive(id string, cur, prev *Cstats) map[string]float64 {
+	if len(h.derived) == 0 {
+		return nil
+	}
+	m := make(map[string]float64)
+	for _, d := range h.derived {
+		for k, v := range d.fn(id, cur, prev) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			m[k] = v
+		}
+	}
+	return m
+}
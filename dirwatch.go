@@ -0,0 +1,140 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// dirWatcher follows the creation and removal of cgroup directories below
+// a holder's base path, see WatchDirs.
+type dirWatcher struct {
+	in *inotify
+	h  *holder
+}
+
+// watchDirs starts watching the directories below h.basePath. The holder
+// must be locked.
+func (h *holder) watchDirs() error {
+	in, err := newInotify()
+	if err != nil {
+		return err
+	}
+	w := &dirWatcher{in: in, h: h}
+	w.addTree(h.basePath)
+	h.dirs = w
+	go w.run()
+	return nil
+}
+
+// addTree watches root and the directories below it, except those of
+// containers, whose children are nested cgroups of the same container.
+// Directories which cannot be watched, such as once
+// fs.inotify.max_user_watches is reached, are left to the periodic scan.
+func (w *dirWatcher) addTree(root string) {
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if _, _, ok := w.h.match(p); ok && p != root {
+			return filepath.SkipDir
+		}
+		w.in.add(p, inCreate|inDelete|inMovedTo|inMovedFrom|inOnlyDir)
+		return nil
+	})
+}
+
+// close stops the watcher, see inotify.close.
+func (w *dirWatcher) close() {
+	w.in.close()
+}
+
+func (w *dirWatcher) run() {
+	for {
+		events, err := w.in.read()
+		if err != nil {
+			return
+		}
+		for _, ev := range events {
+			if ev.mask&inIsDir == 0 || ev.name == "" {
+				continue
+			}
+			p := filepath.Join(ev.path, ev.name)
+			switch {
+			case ev.mask&(inCreate|inMovedTo) != 0:
+				w.added(p)
+			case ev.mask&(inDelete|inMovedFrom) != 0:
+				w.removed(p)
+			}
+		}
+	}
+}
+
+// added scans directory p, which was just created, for containers.
+func (w *dirWatcher) added(p string) {
+	h := w.h
+	h.Lock()
+	defer h.Unlock()
+	if h.dirs != w {
+		return
+	}
+	filepath.Walk(p, h.walk)
+	for _, cs := range h.containers {
+		if cs.under(p) {
+			cs.updateSources(h.basePath)
+			cs.readLimits()
+		}
+	}
+	if h.events != nil {
+		h.events.watch(h.containers)
+	}
+	if _, _, ok := h.match(p); !ok {
+		w.addTree(p)
+	}
+}
+
+// removed stops tracking the containers whose files were in directory p,
+// which was just removed.
+func (w *dirWatcher) removed(p string) {
+	h := w.h
+	h.Lock()
+	defer h.Unlock()
+	if h.dirs != w {
+		return
+	}
+	for id, cs := range h.containers {
+		if cs.under(p) {
+			h.prune(id, &os.PathError{Op: "rmdir", Path: p, Err: syscall.ENOENT})
+		}
+	}
+}
+
+// under reports whether the files of c are in directory dir or below it.
+func (c *Cstats) under(dir string) bool {
+	dir += string(filepath.Separator)
+	for _, files := range []map[string]string{c.files, c.limitFiles, c.controlFiles} {
+		for _, p := range files {
+			if strings.HasPrefix(p, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
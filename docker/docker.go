@@ -0,0 +1,134 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package docker labels containers with their name, image and the Compose
+// and Swarm labels set by Docker, read from the Docker Engine API.
+//
+// The Compose project and service and the Swarm stack and service are
+// stored under short keys which can be used with gocstat.GroupBy and
+// gocstat.ParseSelector:
+//
+//	stop := docker.New().Run(time.Minute, errChan)
+//	defer stop()
+//	...
+//	for project, containers := range gocstat.GroupBy(stats, docker.ComposeProjectKey) {
+//		...
+//	}
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/internal/enrich"
+)
+
+// Metadata keys set by Enrich
+const (
+	NameKey           = "docker.name"
+	ImageKey          = "docker.image"
+	ComposeProjectKey = "docker.compose_project"
+	ComposeServiceKey = "docker.compose_service"
+	SwarmStackKey     = "docker.swarm_stack"
+	SwarmServiceKey   = "docker.swarm_service"
+)
+
+// container labels Docker uses for Compose and Swarm
+var groupLabels = map[string]string{
+	"com.docker.compose.project":    ComposeProjectKey,
+	"com.docker.compose.service":    ComposeServiceKey,
+	"com.docker.stack.namespace":    SwarmStackKey,
+	"com.docker.swarm.service.name": SwarmServiceKey,
+}
+
+// DefaultSocket is the Docker Engine API socket used by New
+var DefaultSocket = "/var/run/docker.sock"
+
+// Enricher labels containers from the Docker Engine API at URL.
+type Enricher struct {
+	// base URL of the Docker Engine API
+	URL string
+	// used to make requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// New returns an Enricher for the local Docker daemon listening on
+// DefaultSocket.
+func New() *Enricher {
+	socket := DefaultSocket
+	return &Enricher{
+		URL: "http://docker",
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+type container struct {
+	Id     string
+	Names  []string
+	Image  string
+	Labels map[string]string
+}
+
+// Enrich sets the Docker labels of every running container, see
+// gocstat.SetMetadata. Containers not yet discovered by gocstat are
+// skipped and labelled by a later call.
+func (e *Enricher) Enrich() error {
+	var containers []container
+	if err := e.get("/containers/json", &containers); err != nil {
+		return err
+	}
+	for _, c := range containers {
+		labels := map[string]string{
+			ImageKey: c.Image,
+		}
+		if len(c.Names) > 0 {
+			labels[NameKey] = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for label, key := range groupLabels {
+			labels[key] = c.Labels[label]
+		}
+		for k, v := range labels {
+			if err := gocstat.SetMetadata(c.Id, k, v); err != nil {
+				// not discovered yet
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Run calls Enrich immediately and then every interval until the returned
+// stop function is called. errChan is optional and used for reporting
+// Enrich errors, it is never closed by Run.
+func (e *Enricher) Run(interval time.Duration, errChan chan<- error) func() {
+	return enrich.Run(interval, e.Enrich, errChan)
+}
+
+func (e *Enricher) get(path string, v interface{}) error {
+	return enrich.Get(e.HTTPClient, "Docker", e.URL, path, v)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/porjo/gocstat"
+)
+
+func TestEnrich(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	base := t.TempDir()
+	dir := filepath.Join(base, "memory", "system.slice", "docker-"+id+".scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.usage_in_bytes"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gocstat.BasePath = base
+	if err := gocstat.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[{"Id": "%s", "Names": ["/shop_web_1"], "Image": "nginx:1.21",
+			"Labels": {"com.docker.compose.project": "shop", "com.docker.compose.service": "web",
+				"com.docker.stack.namespace": "prod", "com.docker.swarm.service.name": "prod_web"}},
+			{"Id": "%s", "Names": ["/other"], "Image": "redis", "Labels": {}}]`,
+			id, strings.Repeat("cd", 32))
+	}))
+	defer srv.Close()
+
+	e := &Enricher{URL: srv.URL}
+	if err := e.Enrich(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := gocstat.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, ok := stats[id]
+	if !ok {
+		t.Fatalf("container %s not found in %v", id, stats)
+	}
+	want := map[string]string{
+		NameKey:           "shop_web_1",
+		ImageKey:          "nginx:1.21",
+		ComposeProjectKey: "shop",
+		ComposeServiceKey: "web",
+		SwarmStackKey:     "prod",
+		SwarmServiceKey:   "prod_web",
+	}
+	for k, v := range want {
+		if cs.Metadata[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, cs.Metadata[k])
+		}
+	}
+	if groups := gocstat.GroupBy(stats, ComposeServiceKey); len(groups["web"]) != 1 {
+		t.Errorf("expected one container in compose service web, got %v", groups)
+	}
+
+	e.URL = srv.URL + "/missing"
+	if err := e.Enrich(); err == nil {
+		t.Errorf("expected an error from a failing daemon")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package ecs labels containers started by the Amazon ECS agent on EC2
+// with their cluster, task and container name, read from the agent's
+// introspection API.
+//
+// Set gocstat.ContainerDirRegexp to DirRegexp before gocstat.Init, then
+// call Enricher.Enrich periodically, or use Enricher.Run:
+//
+//	gocstat.ContainerDirRegexp = ecs.DirRegexp
+//	if err := gocstat.Init(errChan); err != nil {
+//		log.Fatal(err)
+//	}
+//	stop := ecs.New().Run(time.Minute, errChan)
+//	defer stop()
+package ecs
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/internal/enrich"
+)
+
+// DirRegexp matches the cgroup directories the ECS agent creates for
+// containers, <controller>/ecs/<task ID>/<container ID>, capturing the
+// Docker container ID
+const DirRegexp = `.*/ecs/[0-9a-f-]+/([0-9a-f]{64}).*`
+
+// Metadata keys set by Enrich
+const (
+	ClusterKey       = "ecs.cluster"
+	TaskARNKey       = "ecs.task_arn"
+	TaskFamilyKey    = "ecs.task_family"
+	ContainerNameKey = "ecs.container_name"
+)
+
+// Enricher labels containers from the ECS agent at URL.
+type Enricher struct {
+	// base URL of the ECS agent introspection API
+	URL string
+	// used to make requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// New returns an Enricher for the local ECS agent.
+func New() *Enricher {
+	return &Enricher{
+		URL:        "http://localhost:51678",
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type metadata struct {
+	Cluster string
+}
+
+type tasks struct {
+	Tasks []struct {
+		Arn        string
+		Family     string
+		Containers []struct {
+			DockerId string
+			Name     string
+		}
+	}
+}
+
+// Enrich sets the ECS labels of every container the agent knows about,
+// see gocstat.SetMetadata. Containers not yet discovered by gocstat are
+// skipped and labelled by a later call.
+func (e *Enricher) Enrich() error {
+	var m metadata
+	if err := e.get("/v1/metadata", &m); err != nil {
+		return err
+	}
+	var t tasks
+	if err := e.get("/v1/tasks", &t); err != nil {
+		return err
+	}
+	for _, task := range t.Tasks {
+		for _, c := range task.Containers {
+			labels := map[string]string{
+				ClusterKey:       m.Cluster,
+				TaskARNKey:       task.Arn,
+				TaskFamilyKey:    task.Family,
+				ContainerNameKey: c.Name,
+			}
+			for k, v := range labels {
+				if err := gocstat.SetMetadata(c.DockerId, k, v); err != nil {
+					// not discovered yet
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Run calls Enrich immediately and then every interval until the returned
+// stop function is called. errChan is optional and used for reporting
+// Enrich errors, it is never closed by Run.
+func (e *Enricher) Run(interval time.Duration, errChan chan<- error) func() {
+	return enrich.Run(interval, e.Enrich, errChan)
+}
+
+func (e *Enricher) get(path string, v interface{}) error {
+	return enrich.Get(e.HTTPClient, "ECS agent", e.URL, path, v)
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package ecs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/porjo/gocstat"
+)
+
+func TestEnrich(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	base := t.TempDir()
+	dir := filepath.Join(base, "memory", "ecs", "0123456789abcdef0123456789abcdef", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.usage_in_bytes"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gocstat.BasePath = base
+	gocstat.ContainerDirRegexp = DirRegexp
+	if err := gocstat.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/metadata":
+			fmt.Fprint(w, `{"Cluster": "prod", "Version": "Amazon ECS Agent - v1.57.0"}`)
+		case "/v1/tasks":
+			fmt.Fprintf(w, `{"Tasks": [{"Arn": "arn:aws:ecs:eu-west-1:1:task/prod/0123", "Family": "web",
+				"Containers": [{"DockerId": "%s", "Name": "nginx"}, {"DockerId": "%s", "Name": "sidecar"}]}]}`,
+				id, strings.Repeat("cd", 32))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e := &Enricher{URL: srv.URL}
+	if err := e.Enrich(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := gocstat.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, ok := stats[id]
+	if !ok {
+		t.Fatalf("container %s not found in %v", id, stats)
+	}
+	want := map[string]string{
+		ClusterKey:       "prod",
+		TaskARNKey:       "arn:aws:ecs:eu-west-1:1:task/prod/0123",
+		TaskFamilyKey:    "web",
+		ContainerNameKey: "nginx",
+	}
+	for k, v := range want {
+		if cs.Metadata[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, cs.Metadata[k])
+		}
+	}
+
+	e.URL = srv.URL + "/missing"
+	if err := e.Enrich(); err == nil {
+		t.Errorf("expected an error from a failing agent")
+	}
+}
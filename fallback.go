@@ -0,0 +1,84 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// fallbackID returns the ID of the container filePath belongs to when
+// ContainerDirRegexp does not match it and FallbackIDs is set, or "" if it
+// does not belong to one. A directory is a container if it is not a
+// hierarchy root and holds stat files and member processes. The holder
+// must be locked.
+func (h *holder) fallbackID(filePath string, isDir bool) string {
+	dir := filePath
+	if !isDir {
+		dir = filepath.Dir(filePath)
+	}
+	id := sanitizeID(filepath.Base(dir))
+	if cs, ok := h.containers[id]; ok {
+		if cs.Unmatched {
+			return id
+		}
+		// an ID ContainerDirRegexp extracted elsewhere
+		return ""
+	}
+	if !isDir {
+		return ""
+	}
+	if rel := h.hierarchyPath(h.basePath, dir); rel == "" || rel == "." {
+		return ""
+	}
+	if !hasStatFiles(dir) {
+		return ""
+	}
+	b, err := readFile(filepath.Join(dir, cgroupProcsFile))
+	if err != nil || len(strings.TrimSpace(string(b))) == 0 {
+		return ""
+	}
+	return id
+}
+
+// hasStatFiles reports whether dir holds any of statFiles.
+func hasStatFiles(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if _, ok := statFiles[e.Name()]; ok && !e.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeID replaces the characters of a directory name other than
+// letters, digits, '-', '_' and '.' with '_', so that it can be used as an
+// ID in metric labels and file names.
+func sanitizeID(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
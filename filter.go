@@ -0,0 +1,325 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter selects containers by an expression, see ParseFilter
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+type filterNode interface {
+	match(id string, c *Cstats) bool
+}
+
+type filterAnd struct{ l, r filterNode }
+type filterOr struct{ l, r filterNode }
+type filterNot struct{ n filterNode }
+
+func (n filterAnd) match(id string, c *Cstats) bool { return n.l.match(id, c) && n.r.match(id, c) }
+func (n filterOr) match(id string, c *Cstats) bool  { return n.l.match(id, c) || n.r.match(id, c) }
+func (n filterNot) match(id string, c *Cstats) bool { return !n.n.match(id, c) }
+
+// filterString compares a string property of a container
+type filterString struct {
+	get   func(id string, c *Cstats) string
+	equal bool
+	value string
+}
+
+func (n filterString) match(id string, c *Cstats) bool {
+	return (n.get(id, c) == n.value) == n.equal
+}
+
+// filterNumber compares a Schema field
+type filterNumber struct {
+	field Field
+	op    string
+	value float64
+}
+
+func (n filterNumber) match(id string, c *Cstats) bool {
+	v := n.field.Value(c)
+	switch n.op {
+	case "==":
+		return v == n.value
+	case "!=":
+		return v != n.value
+	case "<":
+		return v < n.value
+	case "<=":
+		return v <= n.value
+	case ">":
+		return v > n.value
+	case ">=":
+		return v >= n.value
+	}
+	return false
+}
+
+// ParseFilter parses a filter expression such as
+//
+//	runtime==docker && mem.rss>1Gi
+//
+// made up of comparisons joined by "&&" and "||", optionally negated by
+// "!" and grouped by parentheses. A comparison is a property, an operator
+// among ==, !=, <, <=, > and >=, and a value.
+//
+// Properties are either strings, compared with == and != to a bare word or
+// a double quoted string:
+//
+//	id         the container ID, as collected rather than as exported
+//	runtime    see Cstats.Runtime
+//	label.KEY  the Metadata label KEY, empty if not set
+//
+// or numbers, the Schema fields, named by Field.Name or Field.Metric
+// ignoring case, such as "Memory.RSS" or "memory_rss_bytes", "mem." being
+// short for "memory.". Numbers may have a suffix among k, M, G, T and Ki,
+// Mi, Gi, Ti, for powers of 1000 and 1024.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{expr: expr}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter '%s': unexpected '%s'", expr, p.tokens[p.pos])
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// String returns the expression f was parsed from.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether container id, with statistics c, is selected by f.
+func (f *Filter) Match(id string, c *Cstats) bool {
+	return f.root.match(id, c)
+}
+
+// Apply returns the containers of stats selected by f. A nil f selects
+// all of them.
+func (f *Filter) Apply(stats Cmap) Cmap {
+	if f == nil {
+		return stats
+	}
+	selected := make(Cmap, len(stats))
+	for id, c := range stats {
+		if f.Match(id, c) {
+			selected[id] = c
+		}
+	}
+	return selected
+}
+
+type filterParser struct {
+	expr   string
+	tokens []string
+	pos    int
+}
+
+// lex splits p.expr into operators, parentheses, quoted strings, keeping
+// their quotes, and words.
+func (p *filterParser) lex() error {
+	s := p.expr
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			p.tokens = append(p.tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()!<>", r):
+			p.tokens = append(p.tokens, s[i:i+1])
+			i++
+		case r == '"':
+			j := strings.IndexByte(s[i+1:], '"')
+			if j < 0 {
+				return fmt.Errorf("filter '%s': unterminated string", p.expr)
+			}
+			p.tokens = append(p.tokens, s[i:i+j+2])
+			i += j + 2
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()!<>=&|\"", rune(s[j])) {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("filter '%s': unexpected '%c'", p.expr, s[i])
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	n, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var r filterNode
+		if r, err = p.and(); err == nil {
+			n = filterOr{n, r}
+		}
+	}
+	return n, err
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	n, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var r filterNode
+		if r, err = p.unary(); err == nil {
+			n = filterAnd{n, r}
+		}
+	}
+	return n, err
+}
+
+func (p *filterParser) unary() (filterNode, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		n, err := p.unary()
+		return filterNot{n}, err
+	case "(":
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("filter '%s': missing ')'", p.expr)
+		}
+		return n, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterNode, error) {
+	name, op, value := p.next(), p.next(), p.next()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("filter '%s': expected a comparison after '%s'", p.expr, name)
+	}
+	if value == "" || strings.ContainsAny(value[:1], "()!<>=&|") {
+		return nil, fmt.Errorf("filter '%s': missing value after '%s %s'", p.expr, name, op)
+	}
+
+	if get := stringProperty(name); get != nil {
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("filter '%s': %s can only be compared with == and !=", p.expr, name)
+		}
+		return filterString{get: get, equal: op == "==", value: strings.Trim(value, `"`)}, nil
+	}
+	field, ok := schemaField(name)
+	if !ok {
+		return nil, fmt.Errorf("filter '%s': unknown property '%s'", p.expr, name)
+	}
+	v, err := parseQuantity(value)
+	if err != nil {
+		return nil, fmt.Errorf("filter '%s': %s", p.expr, err)
+	}
+	return filterNumber{field: field, op: op, value: v}, nil
+}
+
+// stringProperty returns the getter of the string property name, nil if
+// there is none.
+func stringProperty(name string) func(id string, c *Cstats) string {
+	switch {
+	case name == "id":
+		return func(id string, c *Cstats) string { return id }
+	case name == "runtime":
+		return func(id string, c *Cstats) string { return c.Runtime }
+	case strings.HasPrefix(name, "label."):
+		key := strings.TrimPrefix(name, "label.")
+		return func(id string, c *Cstats) string { return c.Metadata[key] }
+	}
+	return nil
+}
+
+// schemaField returns the Schema field named name, see ParseFilter.
+func schemaField(name string) (Field, bool) {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "mem.") {
+		name = "memory." + strings.TrimPrefix(name, "mem.")
+	}
+	for _, f := range schema {
+		if strings.ToLower(f.Name) == name || f.Metric == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// quantitySuffixes are the multipliers of the suffixes of filter numbers
+var quantitySuffixes = map[string]float64{
+	"k": 1e3, "M": 1e6, "G": 1e9, "T": 1e12,
+	"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40,
+}
+
+// parseQuantity parses a number with an optional suffix, such as "1.5Gi".
+func parseQuantity(s string) (float64, error) {
+	i := strings.IndexFunc(s, unicode.IsLetter)
+	if i < 0 {
+		i = len(s)
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s'", s)
+	}
+	if suffix := s[i:]; suffix != "" {
+		m, ok := quantitySuffixes[suffix]
+		if !ok {
+			return 0, fmt.Errorf("invalid suffix '%s'", suffix)
+		}
+		v *= m
+	}
+	return v, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Disk usage of a container's filesystem, see FSUsage
+type FSStat struct {
+	// path measured
+	Path string
+	// bytes allocated to files under Path when measured with
+	// FSPathTemplate, otherwise bytes used on the filesystem mounted at the
+	// container's root
+	Usage uint64
+	// size and bytes available to unprivileged users of the filesystem
+	// holding Path
+	Capacity  uint64
+	Available uint64
+	// inodes used by the files under Path, or on the whole filesystem,
+	// measured like Usage. Filesystems which allocate inodes dynamically,
+	// such as btrfs, report no Inodes or InodesFree
+	InodesUsed uint64
+	// inodes on the filesystem holding Path, and free ones
+	Inodes     uint64
+	InodesFree uint64
+	Timestamp  time.Time
+}
+
+// fsPath returns the path to measure for container id, and whether its
+// usage is that of the files below it rather than its whole filesystem.
+func (c *Cstats) fsPath(cfg *Config, id string) (path string, walk bool, err error) {
+	if cfg.FSPathTemplate != "" {
+		return strings.Replace(cfg.FSPathTemplate, "{id}", id, -1), true, nil
+	}
+	pids, err := c.memberPIDs()
+	if err != nil || len(pids) == 0 {
+		return "", false, err
+	}
+	return filepath.Join(cfg.ProcPath, pids[0], "root"), false, nil
+}
+
+// readFS measures the filesystem usage of container id. Containers without
+// member processes, or whose path doesn't exist, are left unmeasured.
+func (c *Cstats) readFS(cfg *Config, id string) error {
+	path, walk, err := c.fsPath(cfg, id)
+	if err != nil || path == "" {
+		return err
+	}
+	st, err := statfs(path)
+	if err != nil {
+		return ignoreMissing(err)
+	}
+	fs := st.stat(path)
+	if walk {
+		if fs.Usage, fs.InodesUsed, err = diskUsage(path); err != nil {
+			return ignoreMissing(err)
+		}
+	}
+	c.FS = fs
+	return nil
+}
+
+// readTmpfs measures the tmpfs mounts, such as /dev/shm, in the mount
+// namespace of c's first member process, read from procfs mounted at
+// procPath. Their content is held in memory.
+func (c *Cstats) readTmpfs(procPath string) error {
+	pids, err := c.memberPIDs()
+	if err != nil || len(pids) == 0 {
+		return err
+	}
+	root := filepath.Join(procPath, pids[0], "root")
+	b, err := readFile(filepath.Join(procPath, pids[0], "mounts"))
+	if err != nil {
+		return ignoreMissing(err)
+	}
+	var mounts []FSStat
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[2] != "tmpfs" {
+			continue
+		}
+		target := unescapeMount(fields[1])
+		st, err := statfs(filepath.Join(root, target))
+		if err != nil {
+			if err = ignoreMissing(err); err != nil {
+				return err
+			}
+			continue
+		}
+		mounts = append(mounts, st.stat(target))
+	}
+	c.Tmpfs = mounts
+	return nil
+}
+
+// unescapeMount decodes the octal escapes, such as \040 for a space, used
+// for special characters in /proc/<pid>/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// fsInfo is the result of statfs, in bytes and inodes
+type fsInfo struct {
+	size, free, avail uint64
+	files, filesFree  uint64
+}
+
+// stat returns the FSStat of path on the filesystem described by f.
+func (f fsInfo) stat(path string) FSStat {
+	return FSStat{
+		Path:       path,
+		Usage:      f.size - f.free,
+		Capacity:   f.size,
+		Available:  f.avail,
+		InodesUsed: f.files - f.filesFree,
+		Inodes:     f.files,
+		InodesFree: f.filesFree,
+		Timestamp:  time.Now(),
+	}
+}
+
+// ignoreMissing drops errors for paths which no longer exist, such as
+// /proc entries of processes which exited, as they don't mean the
+// container went away.
+func ignoreMissing(err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
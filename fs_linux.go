@@ -0,0 +1,79 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build linux
+
+package gocstat
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// statfs describes the filesystem holding path.
+func statfs(path string) (fsInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsInfo{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	bsize := uint64(st.Bsize)
+	return fsInfo{
+		size:      st.Blocks * bsize,
+		free:      st.Bfree * bsize,
+		avail:     st.Bavail * bsize,
+		files:     st.Files,
+		filesFree: st.Ffree,
+	}, nil
+}
+
+// fileOwner returns the user ID owning the file described by info.
+func fileOwner(info os.FileInfo) (uid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(st.Uid), true
+}
+
+// diskUsage returns the bytes allocated to the files below path, like
+// du(1), and the number of inodes they use, counting hard linked files
+// once. Files removed while walking are skipped.
+func diskUsage(path string) (total, inodes uint64, err error) {
+	seen := make(map[uint64]bool)
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if st.Nlink > 1 && !info.IsDir() {
+			if seen[st.Ino] {
+				return nil
+			}
+			seen[st.Ino] = true
+		}
+		total += uint64(st.Blocks) * 512
+		inodes++
+		return nil
+	})
+	return total, inodes, err
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build !linux
+
+package gocstat
+
+import (
+	"fmt"
+	"os"
+)
+
+func statfs(path string) (fsInfo, error) {
+	return fsInfo{}, fmt.Errorf("filesystem usage is only supported on linux")
+}
+
+func diskUsage(path string) (total, inodes uint64, err error) {
+	return 0, 0, fmt.Errorf("filesystem usage is only supported on linux")
+}
+
+func fileOwner(info os.FileInfo) (uid int, ok bool) {
+	return 0, false
+}
@@ -20,6 +20,10 @@
 // Containers removed from the system are automatically pruned
 // from the list of discovered containers.
 //
+// The package level functions share one set of containers. To run several
+// independent collectors in one process, such as for two cgroup
+// hierarchies, see New.
+//
 // The following example shows how to initalize the package and poll
 // statistics in a for loop:
 //
@@ -50,49 +54,468 @@
 //		fmt.Printf("errChan %s\n", err)
 //	}
 //
+// Init, ReadStats and the background scan are safe for concurrent use.
+// ReadStats returns a copy of the current statistics which belongs to
+// the caller; it is never modified by the package afterwards.
 package gocstat
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"math"
 	//	"log"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	memFile = "memory.stat"
-	cPUFile = "cpuacct.stat"
-)
-
 var (
 	// Directory to start search
 	BasePath = "/sys/fs/cgroup"
 
+	// procfs mount point, read for TaskStates
+	ProcPath = "/proc"
+
+	// sysfs mount point, read for the block device names of BlkIODevices
+	// and ResolveLoopDevices
+	SysPath = "/sys"
+
 	// Process directories which match this regex. The section enclosed in parentheses
 	// will be used as the container ID
 	ContainerDirRegexp = `.*docker-([0-9a-z]{64})\.scope.*`
 
-	re                  *regexp.Regexp
-	statsHolder         *holder
+	// Matchers of the containers to track, tried in order, such as
+	// BuiltinMatchers. ContainerDirRegexp is used when empty
+	Matchers []Matcher
+
+	// Watch the directories below BasePath with inotify, so containers are
+	// tracked as soon as their cgroup is created and forgotten once it is
+	// removed, rather than on the next scan. Scans continue every 30
+	// seconds, catching directories which could not be watched, such as
+	// beyond fs.inotify.max_user_watches. Linux only
+	WatchDirs = false
+
+	// Also collect the parent cgroup of each container, such as a
+	// Kubernetes pod slice or docker.slice, reporting it as an entry with
+	// Cstats.Parent set, under its directory name with unusual characters
+	// replaced by '_'. It shows the overhead of a pod beyond its
+	// containers, such as the pause container, and slice level limits.
+	// Containers refer to it with Cstats.ParentID. Parents directly below
+	// the hierarchy root are not collected
+	ParentStats = false
+
+	// Track cgroups which ContainerDirRegexp does not match, but which
+	// hold stat files and member processes, using their directory name,
+	// with unusual characters replaced by '_', as ID. They are flagged
+	// with Cstats.Unmatched. This makes containers of unknown runtimes
+	// visible, along with any other service having its own cgroup
+	FallbackIDs = false
+
+	// Report hierarchy-inclusive memory counters (the total_* fields of
+	// cgroup v1 memory.stat), which include any nested cgroups created
+	// inside the container. cgroup v2 counters are always hierarchical.
+	HierarchicalMemory = false
+
+	// Likewise, report block I/O including nested cgroups, from the
+	// blkio *_recursive files of cgroup v1 where available. cgroup v2's
+	// io.stat is always hierarchical.
+	HierarchicalBlkIO = false
+
+	// Only report block I/O of the devices matching one of these patterns,
+	// either "major:minor", such as "8:0" or "259:*", or a kernel device
+	// name, such as "nvme*", as listed in /sys/dev/block. Patterns use the
+	// syntax of path.Match. Other devices, such as loop devices and device
+	// mapper snapshots, are skipped while parsing. Nil reports every device
+	BlkIODevices []string
+
+	// Report the file backing each loop device in BlkDevice.BackingFile,
+	// so I/O through loop devices can be attributed to an image or volume
+	// file. Overlay filesystems have no block device of their own and are
+	// not covered
+	ResolveLoopDevices = false
+
+	// Open the files below BasePath with openat2(2), refusing to follow
+	// symlinks or to resolve outside of BasePath, protecting agents running
+	// with elevated privileges from cgroup path trickery. Requires Linux
+	// 5.6 or later, Init fails otherwise
+	SecureReads = false
+
+	// Skip the files the current user is not permitted to read instead of
+	// failing ReadStats, leaving their statistics at zero. For agents run
+	// unprivileged, such as on the delegated cgroup tree of a user, see
+	// ProbePermissions for what is skipped
+	SkipUnreadable = false
+
+	// Leave containers whose cgroup has no member processes out of ReadStats.
+	// Otherwise they are included with Cstats.Inactive set
+	ExcludeEmpty = false
+
+	// Maximum age of the statistics returned by ReadCachedStats and Watch
+	// before they are collected again. Zero collects on every call
+	MaxStaleness = time.Duration(0)
+
+	// How long a history of each container's statistics is kept for
+	// Summarize. Zero disables the history
+	HistoryRetention = time.Duration(0)
+
+	// Align Watch ticks to multiples of the interval since the Unix epoch,
+	// so hosts sample at the same wall clock times
+	AlignTicks = false
+
+	// Delay each Watch tick by a random duration up to this much, so agents
+	// started together don't all read at once
+	TickJitter = time.Duration(0)
+
+	// What Watch does when its receiver is slow, and how long it waits
+	// under BackpressureBlock
+	WatchBackpressure = BackpressureDropNewest
+	WatchBlockTimeout = time.Second
+
+	// Validate the content of each stat file, and the fields parsed from
+	// them, making ReadStats return an error on mismatch. Intended for tests
+	// and for checking the package against new kernels
+	SelfCheck = false
+
+	// Minimum interval between reads of each subsystem's files, keyed by
+	// "memory", "cpu", "io", "cgroup", "pids", "fs" (FSUsage), "net"
+	// (NetStats) or "pss" (PSSUsage). Until it
+	// passes, ReadStats reports the previous values. Unlisted subsystems are
+	// read every time. Intervals can be set per container with
+	// SetSampleInterval
+	SubsystemIntervals map[string]time.Duration
+
+	// Stretch the Watch interval, doubling it up to AdaptiveMaxFactor
+	// times, while collection takes longer than AdaptiveMaxLatency or the
+	// host's load average per CPU exceeds AdaptiveMaxLoad. The interval is
+	// restored as both fall back under half their threshold
+	AdaptiveSampling   = false
+	AdaptiveMaxLatency = 250 * time.Millisecond
+	AdaptiveMaxLoad    = 2.0
+	AdaptiveMaxFactor  = 8
+
+	// Maximum number of containers tracked, zero for no limit. Containers
+	// found beyond it are handled according to ContainerOverflow and
+	// reported to NotifyOverflow
+	MaxContainers     = 0
+	ContainerOverflow = OverflowDropNewest
+
+	// Approximate memory, in bytes, to be retained in history and snapshots,
+	// see Retained. History is trimmed, oldest samples first, to stay within
+	// it. Zero for no limit
+	MemoryBudget = 0
+
+	// Count the scheduler states of every task of each container in
+	// Cstats.Tasks. This reads a /proc file per task
+	TaskStates = false
+
+	// Sum the CPU time and scheduler run delay of every task of each
+	// container, from /proc/<pid>/task/<tid>/schedstat, in Cstats.Tasks.
+	// This reads a /proc file per task and requires a kernel built with
+	// CONFIG_SCHED_INFO
+	SchedStats = false
+
+	// Measure each container's filesystem usage in Cstats.FS. By default
+	// that is statfs of the filesystem mounted at the root of its first
+	// member process. When FSPathTemplate is set, the files below it,
+	// with {id} replaced by the container ID, are measured instead, such as
+	// a runtime's writable layer directory. Walking it is costly, consider
+	// a SubsystemIntervals entry for "fs"
+	FSUsage        = false
+	FSPathTemplate = ""
+
+	// Measure the tmpfs mounts, such as /dev/shm, in each container's mount
+	// namespace in Cstats.Tmpfs. Their content uses memory without being
+	// obvious from memory.stat. Part of the "fs" subsystem
+	TmpfsUsage = false
+
+	// Read each container's network counters in Cstats.Net, from
+	// /proc/<pid>/net/dev of its first member process, which lists the
+	// interfaces of its network namespace. Containers sharing the host's
+	// network namespace report the host's counters. Subsystem "net"
+	NetStats = false
+
+	// Sum the proportional set size of each container's processes in
+	// Cstats.Memory.PSS, from /proc/<pid>/smaps_rollup. Unlike RSS, shared
+	// pages are not counted in full by every process. The kernel walks the
+	// page tables of each process to produce it, which is costly for large
+	// processes, consider a SubsystemIntervals entry for "pss". Requires
+	// Linux 4.14
+	PSSUsage = false
+
+	// Time allowed for each collection, zero for no limit. Containers are
+	// collected in order of priority, see SetPriority, then of CPU
+	// utilization in the previous collection. Once it is exceeded, the
+	// remaining containers without a positive priority are skipped and
+	// reported with their previous statistics and Cstats.Skipped set
+	CollectionBudget = time.Duration(0)
+
+	// Deadline of each collection, zero for none. Once it passes, every
+	// remaining container is skipped regardless of priority, so slow
+	// containers degrade monitoring instead of stalling it. It is only
+	// checked between containers: a read which blocks, as on a hung cgroup
+	// filesystem, is not interrupted and stalls the collection past the
+	// deadline. See LastCollection for what was skipped
+	CollectionDeadline = time.Duration(0)
+
+	// Called for each container on every collection to fill
+	// Cstats.Accelerators, such as with GPU metrics from NVML matched by
+	// process ID or by the devices in devices.list, nil when unused. It is
+	// called with collection in progress and must not call functions of
+	// this package
+	Accelerators AcceleratorFunc
+
+	// Labels describing this host, such as its hostname, region or node
+	// role, added to the Metadata of every container in the statistics
+	// collected, so they are carried by everything exported or streamed.
+	// Labels of the container set with SetMetadata take precedence
+	HostLabels map[string]string
+
+	// Collection hooks, nil when unused. They are called with collection in
+	// progress and must not call functions of this package.
+	//
+	// BeforeCollect is called at the start of each collection.
+	BeforeCollect func()
+	// OnContainer is called with each container once read and its
+	// Utilization computed, and may modify it. Returning false leaves the
+	// container out of this collection's statistics.
+	OnContainer func(id string, cs *Cstats) bool
+	// AfterCollect is called at the end of each collection with the
+	// statistics collected, which must not be modified, or nil on error,
+	// how long collection took and its error, if any.
+	AfterCollect func(stats Cmap, took time.Duration, err error)
+
+	statsHolder         = &holder{}
 	namesUpdateInterval = time.Duration(30 * time.Second)
 )
 
+// Unlimited is reported for limits which are not set ("max").
+const Unlimited = math.MaxUint64
+
+// statFiles maps the base name of each file read by ReadStats
+// to the function which parses its content into Cstats.
+var statFiles = map[string]func(cs *Cstats, content string){
+	memFile:             func(cs *Cstats, content string) { cs.Memory.create(content, cs.config().HierarchicalMemory) },
+	memZswapCurrentFile: func(cs *Cstats, content string) { cs.Memory.ZswapCurrent = parseUint(content) },
+	memZswapMaxFile:     func(cs *Cstats, content string) { cs.Memory.ZswapMax = parseLimit(content) },
+	memUsageFile:        func(cs *Cstats, content string) { cs.Memory.Usage = parseUint(content) },
+	memCurrentFile:      func(cs *Cstats, content string) { cs.Memory.Usage = parseUint(content) },
+	memEventsFile:       func(cs *Cstats, content string) { cs.Memory.createEvents(content) },
+	memEventsLocalFile:  func(cs *Cstats, content string) { cs.Memory.createEventsLocal(content) },
+	memOOMControlFile:   func(cs *Cstats, content string) { cs.Memory.OOMKills = parseKeyValues(content)["oom_kill"] },
+	cPUFile:             func(cs *Cstats, content string) { cs.CPU.create(content) },
+	cPUStatFile:         func(cs *Cstats, content string) { cs.CPU.createCPUStat(content) },
+	blkIOIOPSFile:       func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOBytesFile:      func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+	blkIOCFQIOPSFile:    func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOCFQBytesFile:   func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+	blkIOBFQIOPSFile:    func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOBFQBytesFile:   func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+	blkIOCFQQueuedFile:  func(cs *Cstats, content string) { cs.BlkIO.Queued.create(cs.config(), content) },
+	blkIOBFQQueuedFile:  func(cs *Cstats, content string) { cs.BlkIO.Queued.create(cs.config(), content) },
+	iOStatFile:          func(cs *Cstats, content string) { cs.BlkIO.createIOStat(cs.config(), content) },
+	cgroupStatFile:      func(cs *Cstats, content string) { cs.Cgroup.create(content) },
+	cgroupEventsFile:    func(cs *Cstats, content string) { cs.Cgroup.createEvents(content) },
+	cgroupProcsFile:     func(cs *Cstats, content string) { cs.Cgroup.createProcs(content) },
+	cgroupThreadsFile:   func(cs *Cstats, content string) { cs.Cgroup.Threads = uint64(len(strings.Fields(content))) },
+	cgroupTypeFile:      func(cs *Cstats, content string) { cs.Cgroup.Type = strings.TrimSpace(content) },
+	freezerStateFile:    func(cs *Cstats, content string) { cs.Cgroup.Frozen = strings.TrimSpace(content) == "FROZEN" },
+	pidsCurrentFile:     func(cs *Cstats, content string) { cs.Pids.create(content) },
+
+	blkIOIOPSRecursiveFile:     func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOBytesRecursiveFile:    func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+	blkIOCFQIOPSRecursiveFile:  func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOCFQBytesRecursiveFile: func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+	blkIOBFQIOPSRecursiveFile:  func(cs *Cstats, content string) { cs.BlkIO.IOPS.create(cs.config(), content) },
+	blkIOBFQBytesRecursiveFile: func(cs *Cstats, content string) { cs.BlkIO.Bytes.create(cs.config(), content) },
+
+	blkIOCFQQueuedRecursiveFile: func(cs *Cstats, content string) { cs.BlkIO.Queued.create(cs.config(), content) },
+	blkIOBFQQueuedRecursiveFile: func(cs *Cstats, content string) { cs.BlkIO.Queued.create(cs.config(), content) },
+
+	cgroupControllersFile:    func(cs *Cstats, content string) { cs.Cgroup.Controllers = parseControllers(content) },
+	cgroupSubtreeControlFile: func(cs *Cstats, content string) { cs.Cgroup.SubtreeControl = parseControllers(content) },
+
+	memMaxUsageFile: func(cs *Cstats, content string) { cs.Memory.MaxUsage = parseUint(content) },
+	memPeakFile:     func(cs *Cstats, content string) { cs.Memory.MaxUsage = parseUint(content) },
+	memFailcntFile:  func(cs *Cstats, content string) { cs.Memory.Failcnt = parseUint(content) },
+
+	cPUUsageFile:       func(cs *Cstats, content string) { cs.CPU.UsageNanoseconds = parseUint(content) },
+	cPUUsagePerCPUFile: func(cs *Cstats, content string) { cs.CPU.PerCPU = parsePerCPU(content) },
+}
+
+// alternativeFiles lists stat files providing the same statistics, in
+// order of preference. Only the first found is read. Recursive files are
+// only considered when HierarchicalBlkIO is set.
+var alternativeFiles = [][]string{
+	{blkIOIOPSRecursiveFile, blkIOCFQIOPSRecursiveFile, blkIOBFQIOPSRecursiveFile,
+		blkIOIOPSFile, blkIOCFQIOPSFile, blkIOBFQIOPSFile},
+	{blkIOBytesRecursiveFile, blkIOCFQBytesRecursiveFile, blkIOBFQBytesRecursiveFile,
+		blkIOBytesFile, blkIOCFQBytesFile, blkIOBFQBytesFile},
+	{blkIOCFQQueuedRecursiveFile, blkIOBFQQueuedRecursiveFile,
+		blkIOCFQQueuedFile, blkIOBFQQueuedFile},
+}
+
+// recursiveFiles are the stat files including nested cgroups
+var recursiveFiles = map[string]bool{
+	blkIOIOPSRecursiveFile:     true,
+	blkIOBytesRecursiveFile:    true,
+	blkIOCFQIOPSRecursiveFile:  true,
+	blkIOCFQBytesRecursiveFile: true,
+	blkIOBFQIOPSRecursiveFile:  true,
+	blkIOBFQBytesRecursiveFile: true,
+
+	blkIOCFQQueuedRecursiveFile: true,
+	blkIOBFQQueuedRecursiveFile: true,
+}
+
 type holder struct {
+	// snapshots dropped by Watch, first for 64-bit alignment
+	watchDropped uint64
+
 	sync.Mutex
+	// settings of a Collector, nil for the package variables, see config
+	cfg        *Config
+	info       CgroupInfo
+	matchers   []Matcher
 	containers Cmap
+	events     *eventWatcher
+	dirs       *dirWatcher
+
+	// statistics from the last collection, shared by ReadCachedStats
+	snapshot     Cmap
+	snapshotTime time.Time
+	// the snapshot is held by a View, and must be copied before being
+	// modified, see modifySnapshot
+	snapshotShared bool
+	// the snapshot statistics copied since it was last shared, nil if none
+	// of them are held by a View
+	snapshotCopied map[string]bool
+
+	// container ID -> samples, oldest first
+	history   map[string][]sample
+	anomalies *anomalyDetector
+	sinks     []*sinkRoute
+
+	// containers dropped by MaxContainers as of the last scan, and so far
+	// in the current one
+	rejected     map[string]bool
+	scanRejected map[string]bool
+	overflow     chan<- Overflow
+	pruned       chan<- Pruned
+
+	derived []*derivedMetric
+
+	accounting *accountant
+
+	// base path being scanned, and scopes in the order added
+	basePath string
+	scopes   []*Scope
+
+	// number of the last collection
+	generation uint64
+	// outcome of the last complete collection
+	report CollectionReport
+
+	// closed to stop rescanning, see Shutdown
+	stop chan struct{}
 }
 
 type Cstats struct {
 	Memory MemStat
 	CPU    CPUStat
 	BlkIO  BlkIOStat
+	Cgroup CgroupStat
+	Pids   PidsStat
+	Tasks  TaskStat
+	FS     FSStat
+	Net    NetStat
+	// tmpfs mounts, paths are mount points inside the container
+	Tmpfs  []FSStat
+	Limits Limits
+
+	// accelerators used, see Accelerators
+	Accelerators []Accelerator
+
+	// usage relative to Limits
+	Utilization Utilization
+
+	// counter increases and rates since the previous collection, empty for
+	// a new container
+	Deltas Deltas
+
+	// the cgroup has no member processes, typically an exited container
+	// whose scope has not been removed yet
+	Inactive bool
+
+	// the container was not collected, for CollectionBudget or
+	// CollectionDeadline, and these are its previous statistics, if any
+	Skipped bool
+
+	// the collection was cut short by CollectionBudget or
+	// CollectionDeadline and other containers may be Skipped, see
+	// LastCollection
+	Overrun bool
+
+	// the ID is the cgroup's directory name, as ContainerDirRegexp did not
+	// match it, see FallbackIDs
+	Unmatched bool
+	// runtime of the Matcher which found the container, empty for
+	// ContainerDirRegexp
+	Runtime string
+
+	// these are the statistics of the parent cgroup of containers, such as
+	// a Kubernetes pod's, rather than of a container, see ParentStats
+	Parent bool
+	// ID of the container's parent cgroup entry, if ParentStats is set
+	ParentID string
+
+	// subsystem -> directory, relative to BasePath, its files are read
+	// from. The same ID may be found in several hierarchies, see
+	// preferPath
+	Sources map[string]string
+
+	// labels attached by the caller, see SetMetadata
+	Metadata map[string]string
+
+	// custom metrics, see AddDerived
+	Derived map[string]float64
+
+	// number of the collection these statistics are from, incremented by
+	// every ReadStats and every ReadCachedStats which collects, starting
+	// from 1 at Init. A gap between consecutive values seen by a consumer
+	// means it missed a collection
+	Generation uint64
+	// number of collections including this container, counting from 1 when
+	// it was found. Together with the ID it identifies a sample
+	Revision uint64
+
+	// stat file base name -> path
+	files map[string]string
+	// limit file base name -> path
+	limitFiles map[string]string
+	// control file base name -> path
+	controlFiles map[string]string
+	// sample taken by the previous ReadStats
+	prev *Cstats
+	// per container SubsystemIntervals overrides, see SetSampleInterval
+	intervals map[string]time.Duration
+	// subsystem -> time its files were last read
+	lastRead map[string]time.Time
+	// cgroup directory relative to the hierarchy root, and the Scope it
+	// belongs to, if any
+	path  string
+	scope *Scope
+	// collection priority, see SetPriority
+	priority int
+	// excluded from collection, see Pause
+	paused bool
+	// settings of the Collector tracking the container, nil for the
+	// package variables, see config
+	cfg *Config
 }
 
 // Map key corresponds with the container ID.
@@ -101,201 +524,558 @@ type Cstats struct {
 // https://code.google.com/p/go/issues/detail?id=3117
 type Cmap map[string]*Cstats
 
-type stat interface {
-	create(content string)
+// parseUint parses a file holding a single unsigned value.
+func parseUint(content string) uint64 {
+	v, _ := strconv.ParseUint(strings.TrimSpace(content), 10, 64)
+	return v
 }
 
-type CPUStat struct {
-	User      uint64
-	System    uint64
-	path      string
-	Timestamp time.Time
-}
-
-type MemStat struct {
-	RSS       uint64
-	Cache     uint64
-	path      string
-	Timestamp time.Time
+// parseLimit is like parseUint but also accepts "max", returned as Unlimited.
+func parseLimit(content string) uint64 {
+	content = strings.TrimSpace(content)
+	if content == "max" {
+		return Unlimited
+	}
+	return parseUint(content)
 }
 
-func (c *CPUStat) create(content string) {
-	lines := strings.Split(content, "\n")
-	if len(lines) < 2 {
-		return
-	}
-	for i, line := range lines {
+// parseKeyValues parses files made up of "key value" lines, such as memory.stat.
+func parseKeyValues(content string) map[string]uint64 {
+	kv := make(map[string]uint64)
+	for _, line := range strings.Split(content, "\n") {
 		fields := strings.Fields(line)
-		if len(fields) < 2 {
+		if len(fields) != 2 {
 			continue
 		}
-		switch i {
-		case 0:
-			c.User, _ = strconv.ParseUint(fields[1], 10, 64)
-		case 1:
-			c.System, _ = strconv.ParseUint(fields[1], 10, 64)
-		default:
-			break
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
 		}
+		kv[fields[0]] = v
 	}
-	c.Timestamp = time.Now()
+	return kv
 }
 
-func (m *MemStat) create(content string) {
-	lines := strings.Split(content, "\n")
-	if len(lines) < 2 {
-		return
-	}
-	for i, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
+// parseEqualValues parses "key=value" fields, as found in io.stat and io.max.
+// Values of "max" are returned as Unlimited.
+func parseEqualValues(fields []string) map[string]uint64 {
+	kv := make(map[string]uint64)
+	for _, f := range fields {
+		s := strings.SplitN(f, "=", 2)
+		if len(s) != 2 {
 			continue
 		}
-		switch i {
-		case 0:
-			m.Cache, _ = strconv.ParseUint(fields[1], 10, 64)
-		case 1:
-			m.RSS, _ = strconv.ParseUint(fields[1], 10, 64)
-		default:
-			break
-		}
+		kv[s[0]] = parseLimit(s[1])
 	}
-	m.Timestamp = time.Now()
+	return kv
 }
 
 // Init initalizes the package and must be run before ReadStats().
-// A goroutine is launched to periodically scan BasePath for containers.
+// The cgroup controllers available at BasePath are probed, see Capabilities,
+// and BasePath is scanned once before Init returns, then a goroutine is
+// launched to periodically rescan it for containers.
 // errChan is optional and used by the goroutine for reporting any errors.
+// A *ContainerError leaves the scan running, any other error stops it and
+// closes errChan.
 func Init(errChan chan<- error) error {
-	var err error
-	re, err = regexp.Compile(ContainerDirRegexp)
+	cfg := packageConfig()
+	cfg.Errors = errChan
+	return statsHolder.init(*cfg)
+}
+
+// InitContext is like Init, calling Shutdown once ctx is done.
+func InitContext(ctx context.Context, errChan chan<- error) error {
+	if err := Init(errChan); err != nil {
+		return err
+	}
+	statsHolder.Lock()
+	stop := statsHolder.stop
+	statsHolder.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			statsHolder.shutdown(stop)
+		case <-stop:
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the goroutine launched by Init, and NotifyCgroupEvents if
+// used, and frees the containers tracked and their statistics and
+// history. Registrations such as sinks and scopes are kept. ReadStats
+// fails until Init is called again.
+func Shutdown() {
+	statsHolder.shutdown(nil)
+}
+
+// shutdown stops scanning and frees the holder's state, if stop is nil or
+// the scan it belongs to is still current.
+func (h *holder) shutdown(stop chan struct{}) {
+	h.Lock()
+	defer h.Unlock()
+	if stop != nil && stop != h.stop {
+		return
+	}
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	if h.events != nil {
+		h.events.close()
+		h.events = nil
+	}
+	if h.dirs != nil {
+		h.dirs.close()
+		h.dirs = nil
+	}
+	h.containers = nil
+	h.snapshot = nil
+	h.history = nil
+	h.rejected = nil
+	h.scanRejected = nil
+	h.accounting = nil
+	h.openSecureRoot("", false)
+}
+
+// ContainerError is an error with a single container found by a scan,
+// which goes on with the other containers.
+type ContainerError struct {
+	ID  string
+	Err error
+}
+
+func (e *ContainerError) Error() string {
+	return fmt.Sprintf("container %s: %s", e.ID, e.Err)
+}
+
+func (e *ContainerError) Unwrap() error {
+	return e.Err
+}
+
+// init probes and scans cfg.BasePath, then launches a goroutine to rescan
+// it until shutdown, replacing that of a previous init.
+func (h *holder) init(cfg Config) error {
+	for _, p := range cfg.BlkIODevices {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid BlkIODevices pattern '%s', err %s", p, err)
+		}
+	}
+	if err := h.openSecureRoot(cfg.BasePath, cfg.SecureReads); err != nil {
+		return err
+	}
+	matchers := cfg.Matchers
+	if len(matchers) == 0 {
+		m, err := RegexpMatcher("", cfg.ContainerDirRegexp)
+		if err != nil {
+			return err
+		}
+		matchers = []Matcher{m}
+	}
+	basePath := cfg.BasePath
+	info, err := probeCgroups(basePath)
 	if err != nil {
 		return err
 	}
-	statsHolder = &holder{}
-	statsHolder.containers = make(Cmap)
+	h.Lock()
+	h.info = info
+	h.matchers = matchers
+	h.basePath = basePath
+	h.containers = make(Cmap)
+	h.snapshot = nil
+	h.history = nil
+	h.rejected = nil
+	h.generation = 0
+	if h.stop != nil {
+		close(h.stop)
+	}
+	stop := make(chan struct{})
+	h.stop = stop
+	if h.dirs != nil {
+		h.dirs.close()
+		h.dirs = nil
+	}
+	h.Unlock()
+	if err := h.updatePaths(basePath, cfg.Errors); err != nil {
+		return err
+	}
+	if cfg.WatchDirs {
+		h.Lock()
+		err := h.watchDirs()
+		h.Unlock()
+		if err != nil {
+			return err
+		}
+	}
 	go func() {
 		for {
-			err := updatePaths(BasePath)
-			if err != nil && errChan != nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(cfg.ScanInterval):
+			}
+			err := h.updatePaths(basePath, cfg.Errors)
+			if err != nil && cfg.Errors != nil {
 				select {
-				case errChan <- err:
+				case cfg.Errors <- err:
 				default:
 				}
-				close(errChan)
+				close(cfg.Errors)
 				return
 			}
-			time.Sleep(namesUpdateInterval)
 		}
 	}()
 	return nil
 }
 
-func updatePaths(path string) error {
-	statsHolder.Lock()
-	defer statsHolder.Unlock()
+// updatePaths scans path for containers. The errors of single containers
+// are sent to errChan, if not nil, as a *ContainerError without stopping
+// the scan.
+func (h *holder) updatePaths(path string, errChan chan<- error) error {
+	h.Lock()
+	defer h.Unlock()
 
-	if err := filepath.Walk(path, walkFn); err != nil {
+	err := filepath.Walk(path, h.walk)
+	h.updateParents()
+	h.endScan()
+	if err != nil {
 		return fmt.Errorf("error walking path '%s', err %s", path, err)
 	}
+	for id, cs := range h.containers {
+		cs.updateSources(h.basePath)
+		if err := cs.readLimits(); err != nil && errChan != nil {
+			select {
+			case errChan <- &ContainerError{ID: id, Err: err}:
+			default:
+			}
+		}
+	}
+	if h.events != nil {
+		h.events.watch(h.containers)
+	}
 	return nil
 }
 
 // Retrieve current container statistics.
+//
+// The returned map is a copy and may be retained or modified by the caller.
 func ReadStats() (Cmap, error) {
-	if statsHolder == nil {
+	return statsHolder.readStats()
+}
+
+func (h *holder) readStats() (Cmap, error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.containers == nil {
 		return nil, fmt.Errorf("not initialized")
 	}
-	statsHolder.Lock()
-	defer statsHolder.Unlock()
-	for id, cs := range statsHolder.containers {
-		if cs.Memory.path != "" {
-			b, err := readFile(cs.Memory.path)
+	if err := h.collect(); err != nil {
+		return nil, err
+	}
+	return h.copySnapshot(), nil
+}
+
+// ReadCachedStats is like ReadStats but returns the statistics collected by
+// the last call to ReadStats or ReadCachedStats if they are no older than
+// MaxStaleness, letting many readers share one sample.
+func ReadCachedStats() (Cmap, error) {
+	return statsHolder.readCachedStats()
+}
+
+func (h *holder) readCachedStats() (Cmap, error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.containers == nil {
+		return nil, fmt.Errorf("not initialized")
+	}
+	if h.snapshot == nil || time.Since(h.snapshotTime) > h.config().MaxStaleness {
+		if err := h.collect(); err != nil {
+			return nil, err
+		}
+	}
+	return h.copySnapshot(), nil
+}
+
+// collect reads the statistics of every container and stores them as the
+// holder's snapshot. The holder must be locked.
+func (h *holder) collect() (err error) {
+	start := time.Now()
+	cfg := h.config()
+	if cfg.BeforeCollect != nil {
+		cfg.BeforeCollect()
+	}
+	if cfg.AfterCollect != nil {
+		defer func() {
+			stats := h.snapshot
 			if err != nil {
-				if os.IsNotExist(err) {
-					delete(statsHolder.containers, id)
-					continue
-				}
-				return nil, err
+				stats = nil
 			}
-			statsHolder.containers[id].Memory.create(string(b))
+			cfg.AfterCollect(stats, time.Since(start), err)
+		}()
+	}
+	h.generation++
+	report := CollectionReport{Generation: h.generation, Start: start}
+	retries := atomic.LoadUint64(&readRetries)
+	snapshot := make(Cmap, len(h.containers))
+	for _, id := range h.collectionOrder() {
+		cs := h.containers[id]
+		if cs.paused {
+			continue
 		}
-		if cs.CPU.path != "" {
-			b, err := readFile(cs.CPU.path)
-			if err != nil {
-				if os.IsNotExist(err) {
-					delete(statsHolder.containers, id)
-					continue
-				}
-				return nil, err
+		if overrun(cfg, cs, time.Since(start)) {
+			report.Overrun = true
+			report.Skipped = append(report.Skipped, id)
+			snapshot[id] = cs.skipped(h.generation)
+			continue
+		}
+		prev := cs.prev
+		if err := cs.read(id); err != nil {
+			if os.IsNotExist(err) {
+				h.prune(id, err)
+				continue
 			}
-			statsHolder.containers[id].CPU.create(string(b))
+			return err
 		}
-		if cs.BlkIO.Bytes.path != "" {
-			//err := readFile(cs.BlkIO.Bytes.path, id, &statsHolder.containers[id].BlkIO.Bytes)
-			b, err := readFile(cs.BlkIO.Bytes.path)
-			if err != nil {
-				if os.IsNotExist(err) {
-					delete(statsHolder.containers, id)
-					continue
-				}
-				return nil, err
+		cs.Inactive = !cs.populated()
+		cs.Utilization = utilization(cs, prev)
+		cs.Deltas = Deltas{}
+		if prev != nil {
+			cs.Deltas = ComputeDeltas(cs, prev)
+		}
+		cs.Derived = h.derive(id, cs, prev)
+		if cfg.OnContainer != nil && !cfg.OnContainer(id, cs) {
+			continue
+		}
+		cs.Generation = h.generation
+		cs.Revision++
+		now := time.Now()
+		h.record(cfg, id, cs, prev, now)
+		if h.accounting != nil {
+			h.accounting.add(id, cs, now)
+		}
+		cs.prev = cs.clone()
+		snapshot[id] = cs.prev
+		report.Collected++
+	}
+	report.Took = time.Since(start)
+	report.Retries = atomic.LoadUint64(&readRetries) - retries
+	h.report = report
+	for _, cs := range snapshot {
+		cs.addHostLabels(cfg.HostLabels)
+		cs.Overrun = report.Overrun
+	}
+	h.snapshot = snapshot
+	h.snapshotTime = time.Now()
+	h.snapshotShared = false
+	h.snapshotCopied = nil
+	h.compact()
+	h.dispatch()
+	return nil
+}
+
+// copySnapshot returns a copy of the last collected statistics for the
+// caller. The holder must be locked.
+func (h *holder) copySnapshot() Cmap {
+	excludeEmpty := h.config().ExcludeEmpty
+	stats := make(Cmap, len(h.snapshot))
+	for id, cs := range h.snapshot {
+		if cs.Inactive && excludeEmpty {
+			continue
+		}
+		stats[id] = cs.clone()
+	}
+	return stats
+}
+
+// clone returns a deep copy of c.
+func (c *Cstats) clone() *Cstats {
+	n := *c
+	n.BlkIO.Bytes.Devices = append([]BlkDevice(nil), c.BlkIO.Bytes.Devices...)
+	n.BlkIO.IOPS.Devices = append([]BlkDevice(nil), c.BlkIO.IOPS.Devices...)
+	n.BlkIO.Queued.Devices = append([]BlkDevice(nil), c.BlkIO.Queued.Devices...)
+	n.BlkIO.Latency = append([]BlkLatency(nil), c.BlkIO.Latency...)
+	if c.Memory.Extra != nil {
+		n.Memory.Extra = make(map[string]uint64, len(c.Memory.Extra))
+		for k, v := range c.Memory.Extra {
+			n.Memory.Extra[k] = v
+		}
+	}
+	if c.CPU.Extra != nil {
+		n.CPU.Extra = make(map[string]uint64, len(c.CPU.Extra))
+		for k, v := range c.CPU.Extra {
+			n.CPU.Extra[k] = v
+		}
+	}
+	n.CPU.PerCPU = append([]uint64(nil), c.CPU.PerCPU...)
+	n.Cgroup.Controllers = append([]string(nil), c.Cgroup.Controllers...)
+	n.Cgroup.SubtreeControl = append([]string(nil), c.Cgroup.SubtreeControl...)
+	n.Limits.IO = append([]IOLimit(nil), c.Limits.IO...)
+	n.Limits.IOWeightDevices = append([]DeviceWeight(nil), c.Limits.IOWeightDevices...)
+	n.Limits.BFQWeightDevices = append([]DeviceWeight(nil), c.Limits.BFQWeightDevices...)
+	n.Limits.IOLatency = append([]IOLatencyTarget(nil), c.Limits.IOLatency...)
+	n.Utilization.IO = append([]IOUtilization(nil), c.Utilization.IO...)
+	n.Tmpfs = append([]FSStat(nil), c.Tmpfs...)
+	n.Net.Interfaces = append([]NetInterface(nil), c.Net.Interfaces...)
+	n.Accelerators = copyAccelerators(c.Accelerators)
+	n.Metadata = copyMetadata(c.Metadata)
+	n.Sources = copyMetadata(c.Sources)
+	n.Deltas.Counters = copyFloats(c.Deltas.Counters)
+	n.Deltas.Rates = copyFloats(c.Deltas.Rates)
+	n.Derived = copyFloats(c.Derived)
+	n.files = nil
+	n.limitFiles = nil
+	n.intervals = nil
+	n.lastRead = nil
+	n.controlFiles = nil
+	n.path = ""
+	n.scope = nil
+	n.priority = 0
+	n.paused = false
+	n.prev = nil
+	return &n
+}
+
+// read reads and parses every stat file found for the container.
+func (c *Cstats) read(id string) error {
+	cfg := c.config()
+	// cgroup.type is read first as it decides which errors are expected
+	if p, ok := c.files[cgroupTypeFile]; ok {
+		b, err := readFile(p)
+		if err != nil {
+			return err
+		}
+		statFiles[cgroupTypeFile](c, string(b))
+	}
+	skip := c.due(cfg, time.Now())
+	for name, path := range c.files {
+		if name == cgroupTypeFile || c.hasPreferred(cfg, name) || skip[subsystem(name)] {
+			continue
+		}
+		b, err := readFile(path)
+		if err != nil {
+			if c.threadedErr(name, err) || unreadable(cfg, err) {
+				continue
 			}
-			statsHolder.containers[id].BlkIO.Bytes.create(string(b))
+			return err
 		}
-		if cs.BlkIO.IOPS.path != "" {
-			//err := readFile(cs.BlkIO.IOPS.path, id, &statsHolder.containers[id].BlkIO.IOPS)
-			b, err := readFile(cs.BlkIO.IOPS.path)
-			if err != nil {
-				if os.IsNotExist(err) {
-					delete(statsHolder.containers, id)
-					continue
-				}
-				return nil, err
+		if cfg.SelfCheck {
+			if err := checkFile(name, path, string(b)); err != nil {
+				return err
 			}
-			statsHolder.containers[id].BlkIO.IOPS.create(string(b))
 		}
+		statFiles[name](c, string(b))
 	}
-	return statsHolder.containers, nil
+	c.BlkIO.sumDevices()
+	if cfg.ResolveLoopDevices && !skip["io"] {
+		c.BlkIO.resolveLoopDevices(cfg.SysPath)
+	}
+	if (cfg.TaskStates || cfg.SchedStats) && !skip["cgroup"] {
+		if err := c.readTasks(cfg); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.FSUsage && !skip["fs"] {
+		if err := c.readFS(cfg, id); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.TmpfsUsage && !skip["fs"] {
+		if err := c.readTmpfs(cfg.ProcPath); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.NetStats && !skip["net"] {
+		if err := c.readNet(cfg.ProcPath); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.PSSUsage && !skip["pss"] {
+		if err := c.readPSS(cfg.ProcPath); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.Accelerators != nil {
+		if err := c.readAccelerators(cfg.Accelerators, id); err != nil && !c.threadedErr(cgroupProcsFile, err) && !unreadable(cfg, err) {
+			return err
+		}
+	}
+	if cfg.SelfCheck {
+		return c.check()
+	}
+	return nil
 }
 
-func readFile(path string) (b []byte, err error) {
-	b, err = ioutil.ReadFile(path)
-	if err != nil {
-		return
+// hasPreferred reports whether name is not to be read, because it is
+// recursive and HierarchicalBlkIO is not set, or a file preferred over it
+// was found.
+func (c *Cstats) hasPreferred(cfg *Config, name string) bool {
+	if recursiveFiles[name] && !cfg.HierarchicalBlkIO {
+		return true
+	}
+	for _, alts := range alternativeFiles {
+		for i, alt := range alts {
+			if alt != name {
+				continue
+			}
+			for _, p := range alts[:i] {
+				if recursiveFiles[p] && !cfg.HierarchicalBlkIO {
+					continue
+				}
+				if _, ok := c.files[p]; ok {
+					return true
+				}
+			}
+			return false
+		}
 	}
-	return
+	return false
 }
 
-func walkFn(filePath string, info os.FileInfo, err error) error {
+func (h *holder) walk(filePath string, info os.FileInfo, err error) error {
 	if err != nil {
 		return nil
 	}
 
-	matches := re.FindStringSubmatch(filePath)
-	if len(matches) < 2 {
+	id, runtime, ok := h.match(filePath)
+	unmatched := false
+	if !ok && h.config().FallbackIDs {
+		id, unmatched = h.fallbackID(filePath, info.IsDir()), true
+	}
+	if id == "" {
 		return nil
 	}
-	id := matches[1]
 	if info.IsDir() {
-		if _, ok := statsHolder.containers[id]; !ok {
-			statsHolder.containers[id] = &Cstats{}
+		if _, ok := h.containers[id]; !ok && h.admit(id) {
+			cs := &Cstats{
+				Runtime:      runtime,
+				Unmatched:    unmatched,
+				files:        make(map[string]string),
+				limitFiles:   make(map[string]string),
+				controlFiles: make(map[string]string),
+				path:         h.hierarchyPath(h.basePath, filePath),
+				cfg:          h.cfg,
+			}
+			h.containers[id] = cs
+			h.assignScope(id, cs)
 		}
 	} else {
-		if _, ok := statsHolder.containers[id]; ok {
-			baseName := path.Base(info.Name())
-			switch baseName {
-			case memFile:
-				statsHolder.containers[id].Memory.path = filePath
-			case cPUFile:
-				statsHolder.containers[id].CPU.path = filePath
-			case blkIOIOPSFile:
-				statsHolder.containers[id].BlkIO.Bytes.path = filePath
-			case blkIOBytesFile:
-				statsHolder.containers[id].BlkIO.IOPS.path = filePath
-			}
+		if cs, ok := h.containers[id]; ok {
+			cs.addFile(path.Base(info.Name()), filePath)
 		}
 	}
 
 	return nil
 }
+
+// addFile records filePath if baseName is a stat, limit or control file.
+func (c *Cstats) addFile(baseName, filePath string) {
+	if _, ok := statFiles[baseName]; ok {
+		c.files[baseName] = preferPath(c.files[baseName], filePath)
+	}
+	if _, ok := limitFiles[baseName]; ok {
+		c.limitFiles[baseName] = preferPath(c.limitFiles[baseName], filePath)
+	}
+	if controlFiles[baseName] {
+		c.controlFiles[baseName] = preferPath(c.controlFiles[baseName], filePath)
+	}
+}
@@ -17,8 +17,24 @@
 package gocstat
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	//	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestInit(t *testing.T) {
@@ -29,9 +45,39 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestCapabilities(t *testing.T) {
+	info := Capabilities()
+	if info.Version != 1 {
+		t.Errorf("expected cgroup v1, got v%d", info.Version)
+	}
+	for _, name := range []string{"blkio", "cpu", "cpuacct", "memory", "pids"} {
+		if !info.Has(name) {
+			t.Errorf("expected controller %s in %v", name, info.Controllers)
+		}
+	}
+	if info.Has("freezer") {
+		t.Errorf("expected no freezer controller")
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("pids memory io cpu\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := probeCgroups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 2 || len(info.Controllers) != 4 || info.Controllers[0] != "cpu" {
+		t.Errorf("expected cgroup v2 with sorted controllers, got %+v", info)
+	}
+}
+
 func TestContainersLen(t *testing.T) {
-	if len(statsHolder.containers) != 1 {
-		t.Errorf("Expected 1 container, found %d", len(statsHolder.containers))
+	statsHolder.Lock()
+	n := len(statsHolder.containers)
+	statsHolder.Unlock()
+	if n != 1 {
+		t.Errorf("Expected 1 container, found %d", n)
 	}
 }
 
@@ -47,6 +93,18 @@ func TestReadStats(t *testing.T) {
 		if stat.Memory.RSS == 0 {
 			t.Errorf("Memory.RSS: expected non-zero value")
 		}
+		if stat.Memory.Pgfault == 0 {
+			t.Errorf("Memory.Pgfault: expected non-zero value")
+		}
+		if stat.Memory.Pgmajfault == 0 {
+			t.Errorf("Memory.Pgmajfault: expected non-zero value")
+		}
+		if stat.Memory.ActiveAnon == 0 || stat.Memory.ActiveFile == 0 || stat.Memory.InactiveFile == 0 {
+			t.Errorf("Memory.ActiveAnon/ActiveFile/InactiveFile: expected non-zero values")
+		}
+		if stat.Memory.MappedFile == 0 {
+			t.Errorf("Memory.MappedFile: expected non-zero value")
+		}
 		if stat.CPU.User == 0 {
 			t.Errorf("CPU.User: expected non-zero value")
 		}
@@ -68,3 +126,2893 @@ func TestReadStats(t *testing.T) {
 		}
 	}
 }
+
+func TestReadStatsConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				stats, err := ReadStats()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// results belong to the caller and may be modified freely
+				for _, stat := range stats {
+					stat.Memory.RSS = 0
+					for k := range stat.BlkIO.Bytes.Devices {
+						stat.BlkIO.Bytes.Devices[k].Read = 0
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			if err := statsHolder.updatePaths(BasePath, nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		if stat.Memory.RSS == 0 {
+			t.Errorf("Memory.RSS: modified by a previous caller")
+		}
+	}
+}
+
+func TestMemStatZswap(t *testing.T) {
+	m := MemStat{}
+	m.create("anon 4096\nfile 8192\nzswap 1024\nzswapped 4096\nzswpin 3\nzswpout 7\nzswpwb 1\n", false)
+	if m.Zswap != 1024 || m.Zswapped != 4096 {
+		t.Errorf("Zswap/Zswapped: expected 1024/4096, got %d/%d", m.Zswap, m.Zswapped)
+	}
+	if m.Zswpin != 3 || m.Zswpout != 7 || m.Zswpwb != 1 {
+		t.Errorf("Zswpin/Zswpout/Zswpwb: expected 3/7/1, got %d/%d/%d", m.Zswpin, m.Zswpout, m.Zswpwb)
+	}
+	if v := parseLimit("max\n"); v != Unlimited {
+		t.Errorf("parseLimit(max): expected Unlimited, got %d", v)
+	}
+	if v := parseLimit("1048576\n"); v != 1048576 {
+		t.Errorf("parseLimit: expected 1048576, got %d", v)
+	}
+}
+
+func TestMemStatHierarchical(t *testing.T) {
+	content := "cache 100\nrss 200\ntotal_cache 1100\ntotal_rss 1200\n"
+	m := MemStat{}
+	m.create(content, false)
+	if m.Cache != 100 || m.RSS != 200 || m.Hierarchical {
+		t.Errorf("expected local counters 100/200, got %d/%d (hierarchical %v)", m.Cache, m.RSS, m.Hierarchical)
+	}
+
+	m.create(content, true)
+	if m.Cache != 1100 || m.RSS != 1200 || !m.Hierarchical {
+		t.Errorf("expected total counters 1100/1200, got %d/%d (hierarchical %v)", m.Cache, m.RSS, m.Hierarchical)
+	}
+}
+
+func TestMemStatUnevictable(t *testing.T) {
+	m := MemStat{}
+	m.create("unevictable 1048576\ntotal_unevictable 2097152\n", false)
+	if m.Unevictable != 1048576 {
+		t.Errorf("expected Unevictable 1048576, got %d", m.Unevictable)
+	}
+	m.create("unevictable 1048576\ntotal_unevictable 2097152\n", true)
+	if m.Unevictable != 2097152 {
+		t.Errorf("expected hierarchical Unevictable 2097152, got %d", m.Unevictable)
+	}
+}
+
+func TestMemStatSlab(t *testing.T) {
+	m := MemStat{}
+	m.create("anon 4096\nslab_reclaimable 8192\nslab_unreclaimable 2048\nslab 10240\n", false)
+	if m.SlabReclaimable != 8192 || m.SlabUnreclaimable != 2048 {
+		t.Errorf("expected SlabReclaimable/SlabUnreclaimable 8192/2048, got %d/%d", m.SlabReclaimable, m.SlabUnreclaimable)
+	}
+}
+
+func TestMemStatDirtyWriteback(t *testing.T) {
+	m := MemStat{}
+	m.create("dirty 4096\nwriteback 8192\n", false)
+	if m.Dirty != 4096 || m.Writeback != 8192 {
+		t.Errorf("v1: expected Dirty/Writeback 4096/8192, got %d/%d", m.Dirty, m.Writeback)
+	}
+	m.create("file_dirty 12288\nfile_writeback 16384\n", false)
+	if m.Dirty != 12288 || m.Writeback != 16384 {
+		t.Errorf("v2: expected Dirty/Writeback 12288/16384, got %d/%d", m.Dirty, m.Writeback)
+	}
+}
+
+func TestMemStatWorkingset(t *testing.T) {
+	m := MemStat{}
+	m.create("workingset_refault 30\nworkingset_activate 10\n", false)
+	if m.WorkingsetRefault != 30 || m.WorkingsetActivate != 10 {
+		t.Errorf("expected WorkingsetRefault/WorkingsetActivate 30/10, got %d/%d", m.WorkingsetRefault, m.WorkingsetActivate)
+	}
+	m.create("workingset_refault_anon 5\nworkingset_refault_file 20\nworkingset_activate_anon 1\nworkingset_activate_file 4\n", false)
+	if m.WorkingsetRefault != 25 || m.WorkingsetActivate != 5 || m.WorkingsetRefaultFile != 20 || m.WorkingsetActivateAnon != 1 {
+		t.Errorf("expected totals 25/5 from the anon and file counters, got %+v", m)
+	}
+}
+
+func TestMemStatEvents(t *testing.T) {
+	m := MemStat{}
+	m.createEvents("low 0\nhigh 12\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\n")
+	m.createEventsLocal("low 0\nhigh 10\nmax 0\noom 0\noom_kill 0\n")
+	if m.HighEvents != 12 || m.MaxEvents != 3 || m.OOMKills != 1 || m.LocalHighEvents != 10 || m.LocalMaxEvents != 0 {
+		t.Errorf("unexpected memory events %+v", m)
+	}
+}
+
+func TestCgroupStat(t *testing.T) {
+	c := CgroupStat{}
+	c.create("nr_descendants 2\nnr_dying_descendants 5\n")
+	if c.NrDescendants != 2 || c.NrDyingDescendants != 5 {
+		t.Errorf("expected NrDescendants/NrDyingDescendants 2/5, got %d/%d", c.NrDescendants, c.NrDyingDescendants)
+	}
+}
+
+func TestCgroupControllers(t *testing.T) {
+	cs := &Cstats{}
+	statFiles[cgroupControllersFile](cs, "pids memory cpu\n")
+	statFiles[cgroupSubtreeControlFile](cs, "\n")
+	if !reflect.DeepEqual(cs.Cgroup.Controllers, []string{"cpu", "memory", "pids"}) || len(cs.Cgroup.SubtreeControl) != 0 {
+		t.Errorf("unexpected controllers %q, subtree control %q", cs.Cgroup.Controllers, cs.Cgroup.SubtreeControl)
+	}
+	if !cs.Cgroup.HasController("memory") || cs.Cgroup.HasController("io") {
+		t.Errorf("expected memory and not io to be enabled")
+	}
+}
+
+func TestCgroupEvents(t *testing.T) {
+	c := CgroupStat{}
+	c.createEvents("populated 1\nfrozen 0\n")
+	if !c.Populated || c.Frozen {
+		t.Errorf("expected populated and not frozen, got %v/%v", c.Populated, c.Frozen)
+	}
+
+	in, err := newInotify()
+	if err != nil {
+		t.Skip(err)
+	}
+	p := filepath.Join(t.TempDir(), cgroupEventsFile)
+	if err := ioutil.WriteFile(p, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan CgroupEvent, 1)
+	w := &eventWatcher{in: in, ch: ch, ids: make(map[string]string)}
+	w.watch(Cmap{"abc": &Cstats{files: map[string]string{cgroupEventsFile: p}}})
+	go w.run()
+
+	if err := ioutil.WriteFile(p, []byte("populated 0\nfrozen 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// truncating the file may produce an extra event before the write
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.ID != "abc" {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+			if ev.Frozen {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for cgroup event")
+		}
+	}
+}
+
+func TestInactive(t *testing.T) {
+	cs := &Cstats{files: map[string]string{cgroupProcsFile: ""}}
+	cs.Cgroup.createProcs("")
+	if cs.populated() {
+		t.Errorf("expected empty cgroup.procs to be unpopulated")
+	}
+	cs.Cgroup.createProcs("2869\n2901\n")
+	if !cs.populated() || cs.Cgroup.Procs != 2 {
+		t.Errorf("expected 2 procs, got %d", cs.Cgroup.Procs)
+	}
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		if stat.Inactive {
+			t.Errorf("container %s: expected to be active", id)
+		}
+	}
+}
+
+func TestPruned(t *testing.T) {
+	cs := addTestContainer(t, "exited", map[string]string{memUsageFile: "1\n", cgroupProcsFile: "\n"})
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan Pruned, 1)
+	NotifyPruned(ch)
+	defer NotifyPruned(nil)
+	path := cs.files[memUsageFile]
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["exited"]; ok {
+		t.Errorf("expected the container to be pruned")
+	}
+	select {
+	case ev := <-ch:
+		if ev.ID != "exited" || ev.Path != path || ev.Err != syscall.ENOENT || !ev.Inactive {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	default:
+		t.Errorf("expected a Pruned event")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		l := stat.Limits
+		if l.Memory != 536870912 {
+			t.Errorf("Limits.Memory: expected 536870912, got %d", l.Memory)
+		}
+		if l.CPUQuota != Unlimited || l.CPUPeriod != 100000 || l.CPUShares != 1024 {
+			t.Errorf("Limits.CPU: expected unlimited/100000/1024, got %d/%d/%d", l.CPUQuota, l.CPUPeriod, l.CPUShares)
+		}
+		if len(l.IO) != 1 || l.IO[0].Major != 8 || l.IO[0].ReadBps != 1048576 || l.IO[0].WriteBps != Unlimited {
+			t.Errorf("Limits.IO: expected 8:0 limited to 1048576 read bps, got %+v", l.IO)
+		}
+	}
+
+	l := Limits{}
+	l.createCPUMax("50000 100000\n")
+	l.createIOMax("8:16 rbps=2097152 wbps=max riops=max wiops=120\n")
+	if l.CPUQuota != 50000 || l.CPUPeriod != 100000 {
+		t.Errorf("cpu.max: expected 50000/100000, got %d/%d", l.CPUQuota, l.CPUPeriod)
+	}
+	if len(l.IO) != 1 || l.IO[0].ReadBps != 2097152 || l.IO[0].WriteBps != Unlimited || l.IO[0].WriteIOPS != 120 {
+		t.Errorf("io.max: unexpected %+v", l.IO)
+	}
+
+	def, devices := parseWeights("default 100\n8:16 250\n")
+	if def != 100 || len(devices) != 1 || devices[0].Minor != 16 || devices[0].Weight != 250 {
+		t.Errorf("io.weight: expected default 100 and 8:16 250, got %d %+v", def, devices)
+	}
+	if def, _ := parseWeights("500\n"); def != 500 {
+		t.Errorf("blkio.weight: expected 500, got %d", def)
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	// fixture limits are only read when BasePath is scanned
+	if err := statsHolder.updatePaths(BasePath, nil); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		if stat.Utilization.MemoryPct != 25 {
+			t.Errorf("Utilization.MemoryPct: expected 25, got %f", stat.Utilization.MemoryPct)
+		}
+		if stat.Utilization.PidsPct != 12 {
+			t.Errorf("Utilization.PidsPct: expected 12, got %f", stat.Utilization.PidsPct)
+		}
+	}
+
+	now := time.Now()
+	prev := &Cstats{}
+	prev.CPU = CPUStat{User: 100, System: 100, NrPeriods: 10, NrThrottled: 2, Timestamp: now}
+	prev.BlkIO.Bytes = BlkServiced{Timestamp: now, Devices: []BlkDevice{{Major: 8, Read: 0, Write: 0}}}
+	cur := &Cstats{}
+	cur.Limits = Limits{CPUQuota: 50000, CPUPeriod: 100000, IO: []IOLimit{{Major: 8, ReadBps: 1000, WriteBps: Unlimited}}}
+	// 1 second of CPU time over 2 seconds, with a quota of half a CPU
+	cur.CPU = CPUStat{User: 150, System: 150, NrPeriods: 30, NrThrottled: 7, Timestamp: now.Add(2 * time.Second)}
+	cur.BlkIO.Bytes = BlkServiced{Timestamp: now.Add(2 * time.Second), Devices: []BlkDevice{{Major: 8, Read: 1000, Write: 5000}}}
+	u := utilization(cur, prev)
+	if u.CPUPct != 100 {
+		t.Errorf("CPUPct: expected 100, got %f", u.CPUPct)
+	}
+	if u.ThrottledPct != 25 {
+		t.Errorf("ThrottledPct: expected 25, got %f", u.ThrottledPct)
+	}
+	if len(u.IO) != 1 || u.IO[0].ReadBpsPct != 50 || u.IO[0].WriteBpsPct != 0 {
+		t.Errorf("IO: expected 50%% read and no write limit, got %+v", u.IO)
+	}
+
+	// a recreated cgroup starts counting again
+	cur.CPU = CPUStat{User: 10, System: 10, NrPeriods: 1, Timestamp: now.Add(2 * time.Second)}
+	if u := utilization(cur, prev); u.CPUPct != 0 || u.ThrottledPct != 0 {
+		t.Errorf("expected no CPU utilization after a counter reset, got %f and %f", u.CPUPct, u.ThrottledPct)
+	}
+}
+
+func TestComputeDeltas(t *testing.T) {
+	now := time.Now()
+	prev := &Cstats{}
+	prev.CPU = CPUStat{Usage: 1000000, Units: CPUUnitMicroseconds, Timestamp: now}
+	prev.BlkIO.Bytes.Timestamp = now
+	prev.BlkIO.TotalReadBytes = 1000
+	prev.Memory = MemStat{Pgfault: 50, Timestamp: now}
+	cur := &Cstats{}
+	cur.CPU = CPUStat{Usage: 2000000, Units: CPUUnitMicroseconds, Timestamp: now.Add(2 * time.Second)}
+	cur.BlkIO.Bytes.Timestamp = now.Add(2 * time.Second)
+	cur.BlkIO.TotalReadBytes = 5000
+	// memory was not read again, and page faults went down
+	cur.Memory = MemStat{Pgfault: 10, Timestamp: now}
+
+	d := ComputeDeltas(cur, prev)
+	if d.CPUPct != 50 || d.ReadBps != 2000 || d.Counters["blkio_read_bytes_total"] != 4000 {
+		t.Errorf("unexpected deltas %+v", d)
+	}
+	if _, ok := d.Counters["memory_pgfault_total"]; ok {
+		t.Errorf("expected counters not read again to be left out")
+	}
+
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, cs := range stats {
+		if _, ok := cs.Deltas.Rates["cpu_usage_seconds_total"]; !ok {
+			t.Errorf("%s: expected the CPU rate since the previous collection, got %+v", id, cs.Deltas)
+		}
+	}
+}
+
+func TestBlkIOFallback(t *testing.T) {
+	cs := &Cstats{files: map[string]string{
+		blkIOBFQBytesFile: "",
+		blkIOCFQBytesFile: "",
+	}}
+	if !cs.hasPreferred(packageConfig(), blkIOBFQBytesFile) {
+		t.Errorf("expected CFQ statistics to take precedence over BFQ")
+	}
+	if cs.hasPreferred(packageConfig(), blkIOCFQBytesFile) {
+		t.Errorf("expected CFQ statistics to be read without throttling statistics")
+	}
+	cs.files[blkIOBytesFile] = ""
+	cs.files[blkIOCFQBytesRecursiveFile] = ""
+	if !cs.hasPreferred(packageConfig(), blkIOCFQBytesRecursiveFile) {
+		t.Errorf("expected recursive statistics to be ignored without HierarchicalBlkIO")
+	}
+	HierarchicalBlkIO = true
+	if cs.hasPreferred(packageConfig(), blkIOCFQBytesRecursiveFile) || !cs.hasPreferred(packageConfig(), blkIOBytesFile) {
+		t.Errorf("expected recursive statistics to take precedence with HierarchicalBlkIO")
+	}
+	HierarchicalBlkIO = false
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		if len(stat.BlkIO.Bytes.Devices) != 2 || len(stat.BlkIO.IOPS.Devices) != 2 {
+			t.Errorf("expected 2 devices from blkio.io_service_bytes and blkio.io_serviced, got %d and %d",
+				len(stat.BlkIO.Bytes.Devices), len(stat.BlkIO.IOPS.Devices))
+		}
+		if q := stat.BlkIO.Queued.Devices; len(q) != 1 || q[0].Read != 2 || q[0].Write != 1 {
+			t.Errorf("expected 8:0 with 2 reads and 1 write queued from blkio.io_queued, got %+v", q)
+		}
+	}
+
+	// the recursive files include a device used by a nested cgroup
+	HierarchicalBlkIO = true
+	defer func() { HierarchicalBlkIO = false }()
+	stats, err = ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		if len(stat.BlkIO.Bytes.Devices) != 3 || len(stat.BlkIO.IOPS.Devices) != 3 {
+			t.Errorf("expected 3 devices from the recursive files, got %d and %d",
+				len(stat.BlkIO.Bytes.Devices), len(stat.BlkIO.IOPS.Devices))
+		}
+	}
+}
+
+func TestIOStat(t *testing.T) {
+	var b BlkIOStat
+	b.createIOStat(packageConfig(), "8:16 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0\n"+
+		"253:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0 depth=1 avg_lat=120 win=500\n")
+	if len(b.Bytes.Devices) != 2 || b.Bytes.Devices[0] != (BlkDevice{Major: 8, Minor: 16, Read: 1459200, Write: 314773504}) {
+		t.Errorf("unexpected Bytes %+v", b.Bytes.Devices)
+	}
+	if len(b.IOPS.Devices) != 2 || b.IOPS.Devices[1] != (BlkDevice{Major: 253, Read: 1}) {
+		t.Errorf("unexpected IOPS %+v", b.IOPS.Devices)
+	}
+	if len(b.Latency) != 1 || b.Latency[0].AvgLat != 120 {
+		t.Errorf("unexpected Latency %+v", b.Latency)
+	}
+	b.sumDevices()
+	if b.TotalReadBytes != 1463296 || b.TotalWriteBytes != 314773504 || b.TotalReadOps != 193 || b.TotalWriteOps != 353 {
+		t.Errorf("unexpected totals %+v", b)
+	}
+}
+
+func TestIOLatency(t *testing.T) {
+	b := BlkIOStat{}
+	b.createIOStat(packageConfig(), "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353\n"+
+		"8:16 rbytes=90112 wbytes=0 rios=22 wios=0 depth=12 avg_lat=1845 win=500\n")
+	if len(b.Latency) != 1 {
+		t.Fatalf("expected io.latency statistics for 1 device, got %d", len(b.Latency))
+	}
+	if d := b.Latency[0]; d.Minor != 16 || d.Depth != 12 || d.AvgLat != 1845 || d.Win != 500 {
+		t.Errorf("unexpected io.latency statistics %+v", d)
+	}
+
+	l := Limits{}
+	l.createIOLatency("8:16 target=2000\n")
+	if len(l.IOLatency) != 1 || l.IOLatency[0].Minor != 16 || l.IOLatency[0].Target != 2000 {
+		t.Errorf("expected 8:16 target 2000, got %+v", l.IOLatency)
+	}
+}
+
+func TestThreaded(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		cgroupTypeFile:    "threaded\n",
+		cgroupThreadsFile: "4101\n4102\n4103\n",
+		cgroupEventsFile:  "populated 1\nfrozen 0\n",
+	}
+	cs := &Cstats{files: make(map[string]string)}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cs.files[name] = p
+	}
+	// domain controller files vanish when a cgroup becomes threaded
+	cs.files[memFile] = filepath.Join(dir, memFile)
+
+	if err := cs.read("threaded"); err != nil {
+		t.Fatalf("expected missing memory.stat to be ignored, got %s", err)
+	}
+	if cs.Cgroup.Type != "threaded" || cs.Cgroup.Threads != 3 {
+		t.Errorf("expected threaded cgroup with 3 threads, got %q with %d", cs.Cgroup.Type, cs.Cgroup.Threads)
+	}
+	if _, ok := cs.files[memFile]; ok {
+		t.Errorf("expected missing memory.stat to be forgotten")
+	}
+}
+
+// addTestContainer adds a container with the given files, created in a
+// temporary directory, to statsHolder.
+func addTestContainer(t *testing.T, id string, files map[string]string) *Cstats {
+	dir := t.TempDir()
+	cs := &Cstats{
+		files:        make(map[string]string),
+		limitFiles:   make(map[string]string),
+		controlFiles: make(map[string]string),
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := limitFiles[name]; ok {
+			cs.limitFiles[name] = p
+		} else if controlFiles[name] {
+			cs.controlFiles[name] = p
+		} else {
+			cs.files[name] = p
+		}
+	}
+	statsHolder.Lock()
+	statsHolder.containers[id] = cs
+	statsHolder.Unlock()
+	t.Cleanup(func() {
+		statsHolder.Lock()
+		delete(statsHolder.containers, id)
+		statsHolder.Unlock()
+	})
+	return cs
+}
+
+func TestSetLimits(t *testing.T) {
+	addTestContainer(t, "v2", map[string]string{
+		memMaxFile: "max\n",
+		cPUMaxFile: "max 100000\n",
+		iOMaxFile:  "",
+	})
+	if err := SetMemoryLimit("v2", 1<<30); err == nil {
+		t.Errorf("expected an error with AllowWrites unset")
+	}
+
+	AllowWrites = true
+	defer func() { AllowWrites = false }()
+	if err := SetMemoryLimit("v2", 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCPUQuota("v2", 50000, 100000); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIOMax("v2", IOLimit{Major: 8, ReadBps: 1048576, WriteBps: Unlimited, ReadIOPS: Unlimited, WriteIOPS: Unlimited}); err != nil {
+		t.Fatal(err)
+	}
+	statsHolder.Lock()
+	l := statsHolder.containers["v2"].Limits
+	statsHolder.Unlock()
+	if l.Memory != 1<<30 || l.CPUQuota != 50000 || len(l.IO) != 1 || l.IO[0].ReadBps != 1048576 {
+		t.Errorf("unexpected limits after writing %+v", l)
+	}
+	// zero fields are left out, keeping their limit; the file is not
+	// truncated, real cgroup files replace their value
+	if err := SetIOMax("v2", IOLimit{Major: 8, WriteBps: 4096}); err != nil {
+		t.Fatal(err)
+	}
+	statsHolder.Lock()
+	p := statsHolder.containers["v2"].limitFiles[iOMaxFile]
+	statsHolder.Unlock()
+	if b, err := ioutil.ReadFile(p); err != nil || !strings.HasPrefix(string(b), "8:0 wbps=4096") {
+		t.Errorf("expected only wbps to be written, got %q, err %v", b, err)
+	}
+
+	cs := addTestContainer(t, "v1", map[string]string{
+		memLimitFile:  "9223372036854771712\n",
+		cPUQuotaFile:  "-1\n",
+		cPUPeriodFile: "100000\n",
+	})
+	if err := SetMemoryLimit("v1", Unlimited); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCPUQuota("v1", 20000, 50000); err != nil {
+		t.Fatal(err)
+	}
+	// a missing file must not leave the limits half written
+	cs.limitFiles[blkIOReadBpsFile] = filepath.Join(filepath.Dir(cs.limitFiles[memLimitFile]), blkIOReadBpsFile)
+	cs.limitFiles[blkIOWriteBpsFile] = filepath.Join(filepath.Dir(cs.limitFiles[memLimitFile]), "gone")
+	cs.limitFiles[blkIOReadIOPSFile] = cs.limitFiles[blkIOWriteBpsFile]
+	cs.limitFiles[blkIOWriteIOPSFile] = cs.limitFiles[blkIOWriteBpsFile]
+	if err := ioutil.WriteFile(cs.limitFiles[blkIOReadBpsFile], nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetIOMax("v1", IOLimit{Major: 8, ReadBps: 1048576, WriteBps: Unlimited}); err == nil {
+		t.Errorf("expected an error with missing blkio files")
+	}
+	if b, err := ioutil.ReadFile(cs.limitFiles[blkIOReadBpsFile]); err != nil || len(b) != 0 {
+		t.Errorf("expected %s unchanged, got %q, err %v", blkIOReadBpsFile, b, err)
+	}
+	for name, expected := range map[string]string{memLimitFile: "-1", cPUQuotaFile: "20000", cPUPeriodFile: "50000"} {
+		b, err := ioutil.ReadFile(cs.limitFiles[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// files are not truncated, real cgroup files replace their value
+		if string(b[:len(expected)]) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, b)
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	AllowWrites = true
+	defer func() { AllowWrites = false }()
+
+	v2 := addTestContainer(t, "v2", map[string]string{cgroupFreezeFile: "0\n", cgroupKillFile: ""})
+	v1 := addTestContainer(t, "v1", map[string]string{freezerStateFile: "THAWED\n"})
+	for _, f := range []func(string) error{Freeze, Thaw} {
+		if err := f("v2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := f("v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Kill("v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Kill("v1"); err == nil {
+		t.Errorf("expected Kill to fail without cgroup.kill")
+	}
+	for p, expected := range map[string]string{
+		v2.controlFiles[cgroupFreezeFile]: "0\n",
+		v2.controlFiles[cgroupKillFile]:   "1",
+		v1.controlFiles[freezerStateFile]: "THAWED\n",
+	} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%s: expected %q, got %q", filepath.Base(p), expected, b)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	errChan := make(chan error, 1)
+	ch, stop := Watch(10*time.Millisecond, errChan)
+	select {
+	case stats := <-ch:
+		if len(stats) != 1 {
+			t.Errorf("expected 1 container, found %d", len(stats))
+		}
+	case err := <-errChan:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for statistics")
+	}
+	stop()
+	stop()
+	for range ch {
+	}
+
+	// a zero interval must not spin
+	ch, stop = Watch(0, errChan)
+	defer stop()
+	start := time.Now()
+	<-ch
+	<-ch
+	if d := time.Since(start); d < minWatchInterval {
+		t.Errorf("expected ticks at least %s apart, got 2 in %s", minWatchInterval, d)
+	}
+}
+
+func TestReadCachedStats(t *testing.T) {
+	MaxStaleness = time.Hour
+	defer func() { MaxStaleness = 0 }()
+
+	first, err := ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range first {
+		if !stat.Memory.Timestamp.Equal(second[id].Memory.Timestamp) {
+			t.Errorf("expected the cached sample to be shared")
+		}
+		// each caller gets its own copy
+		if stat == second[id] {
+			t.Errorf("expected copies of the cached sample")
+		}
+	}
+
+	MaxStaleness = 0
+	third, err := ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range first {
+		if !third[id].Memory.Timestamp.After(stat.Memory.Timestamp) {
+			t.Errorf("expected a fresh sample once the cache is stale")
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	HistoryRetention = time.Minute
+	defer func() { HistoryRetention = 0 }()
+
+	var id string
+	for i := 0; i < 3; i++ {
+		stats, err := ReadStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id = range stats {
+		}
+	}
+	s, err := Summarize(id, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Samples != 3 {
+		t.Errorf("expected 3 samples, got %d", s.Samples)
+	}
+	if s.RSS.Min != 90112 || s.RSS.Max != 90112 || s.RSS.P99 != 90112 {
+		t.Errorf("unexpected RSS summary %+v", s.RSS)
+	}
+	if _, err := Summarize("unknown", time.Minute); err == nil {
+		t.Errorf("expected an error for an unknown container")
+	}
+
+	st := summarize([]float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10})
+	if st.Min != 1 || st.Max != 10 || st.Mean != 5.5 || st.P50 != 5 || st.P95 != 10 {
+		t.Errorf("unexpected summary %+v", st)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	base := Baseline{
+		"web": {Samples: 10, CPUPct: SummaryStat{Mean: 20, P95: 40}, RSS: SummaryStat{Mean: 100, P95: 100}},
+		"db":  {Samples: 10, CPUPct: SummaryStat{Mean: 10, P95: 10}},
+	}
+	var buf bytes.Buffer
+	if err := SaveBaseline(&buf, base); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBaseline(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, base) {
+		t.Fatalf("expected %+v, got %+v", base, loaded)
+	}
+
+	cur := Baseline{
+		"web":   {Samples: 5, CPUPct: SummaryStat{Mean: 21, P95: 60}, RSS: SummaryStat{Mean: 100, P95: 100}, ReadBps: SummaryStat{Mean: 1}},
+		"cache": {Samples: 5, CPUPct: SummaryStat{Mean: 50, P95: 50}},
+	}
+	d := CompareBaseline(loaded, cur, 0.1)
+	expected := []Deviation{
+		{ID: "web", Metric: "cpu_pct", Stat: "p95", Value: 60, Baseline: 40, Change: 0.5},
+		{ID: "web", Metric: "read_bps", Stat: "mean", Value: 1, Baseline: 0, Change: math.Inf(1)},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected deviations %+v, got %+v", expected, d)
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	if err := NotifyAnomalies(make(chan Anomaly), time.Hour, 3); err == nil {
+		t.Errorf("expected an error with HistoryRetention unset")
+	}
+
+	ch := make(chan Anomaly, 3)
+	a := &anomalyDetector{ch: ch, window: time.Minute, zscore: 3}
+	now := time.Now()
+	var samples []sample
+	for i, v := range []float64{10, 12, 11, 9, 10, 11, 10} {
+		samples = append(samples, sample{time: now.Add(time.Duration(i) * time.Second), cpuPct: v})
+	}
+	a.check("abc", samples)
+	if len(ch) != 0 {
+		t.Errorf("expected no anomalies, got %+v", <-ch)
+	}
+	samples = append(samples, sample{time: now.Add(10 * time.Second), cpuPct: 95})
+	a.check("abc", samples)
+	select {
+	case an := <-ch:
+		if an.ID != "abc" || an.Metric != "cpu_pct" || an.Value != 95 || an.ZScore <= 3 {
+			t.Errorf("unexpected anomaly %+v", an)
+		}
+	default:
+		t.Errorf("expected a cpu_pct anomaly")
+	}
+
+	// writes to a container idle for the whole window
+	samples = samples[:0]
+	for i := 0; i < 6; i++ {
+		samples = append(samples, sample{time: now.Add(time.Duration(i) * time.Second), cpuPct: 50})
+	}
+	samples = append(samples, sample{time: now.Add(6 * time.Second), cpuPct: 50, writeBps: 4096})
+	a.check("abc", samples)
+	select {
+	case an := <-ch:
+		if an.Metric != "write_bps" || an.Value != 4096 || an.Stddev != 0 || !math.IsInf(an.ZScore, 1) {
+			t.Errorf("unexpected anomaly %+v", an)
+		}
+	default:
+		t.Errorf("expected a write_bps anomaly after a flat window")
+	}
+	if len(ch) != 0 {
+		t.Errorf("expected no anomaly for the unchanged cpu_pct, got %+v", <-ch)
+	}
+}
+
+func TestNextTick(t *testing.T) {
+	now := time.Date(2014, 6, 1, 12, 0, 7, 250000000, time.UTC)
+	if d := nextTick(packageConfig(), now, 10*time.Second); d != 10*time.Second {
+		t.Errorf("expected 10s, got %s", d)
+	}
+
+	AlignTicks = true
+	TickJitter = time.Second
+	defer func() {
+		AlignTicks = false
+		TickJitter = 0
+	}()
+	for i := 0; i < 100; i++ {
+		d := nextTick(packageConfig(), now, 10*time.Second)
+		if d < 2750*time.Millisecond || d >= 3750*time.Millisecond {
+			t.Fatalf("expected 2.75s plus up to 1s of jitter, got %s", d)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	MaxStaleness = time.Hour
+	defer func() { MaxStaleness = 0 }()
+	stats, err := ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range stats {
+		if err := SetMetadata(id, "tenant", "acme"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetMetadata(id, "env", "prod"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetMetadata(id, "env", ""); err != nil {
+			t.Fatal(err)
+		}
+		defer SetMetadata(id, "tenant", "")
+	}
+	if err := SetMetadata("unknown", "tenant", "acme"); err == nil {
+		t.Errorf("expected an error for an unknown container")
+	}
+
+	for _, read := range []func() (Cmap, error){ReadCachedStats, ReadStats} {
+		stats, err := read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, stat := range stats {
+			if len(stat.Metadata) != 1 || stat.Metadata["tenant"] != "acme" {
+				t.Errorf("container %s: expected tenant=acme, got %v", id, stat.Metadata)
+			}
+			stat.Metadata["tenant"] = "modified"
+		}
+	}
+}
+
+func TestHostLabels(t *testing.T) {
+	labels, err := ParseLabels(" host=node1, region=eu-west-1,,role=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, map[string]string{"host": "node1", "region": "eu-west-1", "role": ""}) {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if _, err := ParseLabels("host"); err == nil {
+		t.Errorf("expected an error for a label without a value")
+	}
+
+	addTestContainer(t, "labelled", map[string]string{memUsageFile: "1\n"})
+	if err := SetMetadata("labelled", "region", "us-east-1"); err != nil {
+		t.Fatal(err)
+	}
+	HostLabels = map[string]string{"host": "node1", "region": "eu-west-1"}
+	defer func() { HostLabels = nil }()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		region := "eu-west-1"
+		if id == "labelled" {
+			region = "us-east-1"
+		}
+		if stat.Metadata["host"] != "node1" || stat.Metadata["region"] != region {
+			t.Errorf("%s: expected host=node1 and region=%s, got %v", id, region, stat.Metadata)
+		}
+	}
+
+	HostLabels = nil
+	stats, err = ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["labelled"].Metadata["host"]; ok {
+		t.Errorf("expected host labels to be removed with HostLabels")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	stats := Cmap{
+		"a": &Cstats{Metadata: map[string]string{"project": "web"}},
+		"b": &Cstats{Metadata: map[string]string{"project": "web"}},
+		"c": &Cstats{Metadata: map[string]string{"project": "db"}},
+		"d": &Cstats{},
+	}
+	groups := GroupBy(stats, "project")
+	want := map[string]int{"web": 2, "db": 1, "": 1}
+	if len(groups) != len(want) {
+		t.Fatalf("expected groups %v, got %v", want, groups)
+	}
+	for v, n := range want {
+		if len(groups[v]) != n {
+			t.Errorf("group %q: expected %d containers, got %d", v, n, len(groups[v]))
+		}
+	}
+	if groups["db"]["c"] != stats["c"] {
+		t.Errorf("expected container c in group db")
+	}
+}
+
+type chanSink chan Cmap
+
+func (c chanSink) Send(stats Cmap) error {
+	c <- stats
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	sel, err := ParseSelector("tenant=acme, env!=dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseFilter(`label.tenant=="acme" && label.env!="dev"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for labels, expected := range map[[2]string]bool{
+		{"acme", "prod"}: true,
+		{"acme", ""}:     true,
+		{"acme", "dev"}:  false,
+		{"other", ""}:    false,
+	} {
+		metadata := map[string]string{"tenant": labels[0], "env": labels[1]}
+		if sel.Matches(metadata) != expected {
+			t.Errorf("tenant=%s env=%s: expected match %v", labels[0], labels[1], expected)
+		}
+		if f.Match("", &Cstats{Metadata: metadata}) != expected {
+			t.Errorf("tenant=%s env=%s: expected the equivalent filter to match %v", labels[0], labels[1], expected)
+		}
+	}
+	if all, err := ParseSelector(""); err != nil || !all.Matches(nil) {
+		t.Errorf("expected an empty selector to match every container, err %v", err)
+	}
+	if _, err := ParseSelector("tenant"); err == nil {
+		t.Errorf("expected an error for a term without a value")
+	}
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range stats {
+		SetMetadata(id, "tenant", "acme")
+		defer SetMetadata(id, "tenant", "")
+	}
+	acme, other := make(chanSink, 1), make(chanSink, 1)
+	removeAcme, err := AddSink(acme, "tenant=acme", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeAcme()
+	removeOther, err := AddSink(other, "tenant=other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeOther()
+
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]chanSink{"acme": acme, "other": other} {
+		select {
+		case stats := <-c:
+			if name == "acme" && len(stats) != 1 || name == "other" && len(stats) != 0 {
+				t.Errorf("sink %s: unexpected %d containers", name, len(stats))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sink %s: timed out waiting for statistics", name)
+		}
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	SelfCheck = true
+	defer func() { SelfCheck = false }()
+	if _, err := ReadStats(); err != nil {
+		t.Fatalf("fixtures failed self check: %s", err)
+	}
+
+	cs := &Cstats{}
+	cs.BlkIO.Bytes.create(packageConfig(), "8:0 Read 35\n8:0 Write 69\n")
+	cs.BlkIO.IOPS.create(packageConfig(), "8:0 Read 966656\n8:0 Write 3186688\n")
+	if err := cs.check(); err == nil {
+		t.Errorf("expected swapped Bytes and IOPS to fail self check")
+	}
+	if err := checkFile(memFile, memFile, "cache 1\nrss\n"); err == nil {
+		t.Errorf("expected malformed memory.stat to fail self check")
+	}
+	if err := checkFile(blkIOBytesFile, blkIOBytesFile, "8:0 Reads 1\n"); err == nil {
+		t.Errorf("expected unknown blkio operation to fail self check")
+	}
+}
+
+// nonZeroPaths returns the paths, up to two fields deep, of the non-zero
+// exported fields of v.
+func nonZeroPaths(v reflect.Value, prefix string, depth int) []string {
+	var paths []string
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) && depth < 2 {
+			paths = append(paths, nonZeroPaths(fv, prefix+f.Name+".", depth+1)...)
+		} else if !fv.IsZero() {
+			paths = append(paths, prefix+f.Name)
+		}
+	}
+	return paths
+}
+
+// TestFileFields checks that each stat and limit file populates the
+// fields it should, and only those, catching mix-ups between files of
+// the same format.
+func TestFileFields(t *testing.T) {
+	blkio := "8:0 Read 2\n8:0 Write 3\nTotal 5\n"
+	files := map[string]struct {
+		content string
+		field   string
+	}{
+		memFile:             {"cache 1\nrss 2\n", "Memory"},
+		memZswapCurrentFile: {"1\n", "Memory.ZswapCurrent"},
+		memZswapMaxFile:     {"max\n", "Memory.ZswapMax"},
+		memUsageFile:        {"1\n", "Memory.Usage"},
+		memCurrentFile:      {"1\n", "Memory.Usage"},
+		memEventsFile:       {"oom 1\noom_kill 1\n", "Memory.OOMKills"},
+		memOOMControlFile:   {"under_oom 0\noom_kill 1\n", "Memory.OOMKills"},
+		memEventsLocalFile:  {"high 0\nmax 1\n", "Memory.LocalMaxEvents"},
+		cPUFile:             {"user 1\nsystem 2\n", "CPU"},
+		cPUStatFile:         {"usage_usec 3\nuser_usec 1\nsystem_usec 2\n", "CPU"},
+		blkIOIOPSFile:       {blkio, "BlkIO.IOPS"},
+		blkIOBytesFile:      {blkio, "BlkIO.Bytes"},
+		blkIOCFQIOPSFile:    {blkio, "BlkIO.IOPS"},
+		blkIOCFQBytesFile:   {blkio, "BlkIO.Bytes"},
+		blkIOBFQIOPSFile:    {blkio, "BlkIO.IOPS"},
+		blkIOBFQBytesFile:   {blkio, "BlkIO.Bytes"},
+		blkIOCFQQueuedFile:  {blkio, "BlkIO.Queued"},
+		blkIOBFQQueuedFile:  {blkio, "BlkIO.Queued"},
+
+		blkIOIOPSRecursiveFile:     {blkio, "BlkIO.IOPS"},
+		blkIOBytesRecursiveFile:    {blkio, "BlkIO.Bytes"},
+		blkIOCFQIOPSRecursiveFile:  {blkio, "BlkIO.IOPS"},
+		blkIOCFQBytesRecursiveFile: {blkio, "BlkIO.Bytes"},
+		blkIOBFQIOPSRecursiveFile:  {blkio, "BlkIO.IOPS"},
+		blkIOBFQBytesRecursiveFile: {blkio, "BlkIO.Bytes"},
+
+		blkIOCFQQueuedRecursiveFile: {blkio, "BlkIO.Queued"},
+		blkIOBFQQueuedRecursiveFile: {blkio, "BlkIO.Queued"},
+
+		cgroupControllersFile:    {"memory cpu io\n", "Cgroup.Controllers"},
+		cgroupSubtreeControlFile: {"memory\n", "Cgroup.SubtreeControl"},
+
+		memMaxUsageFile: {"1\n", "Memory.MaxUsage"},
+		memPeakFile:     {"1\n", "Memory.MaxUsage"},
+		memFailcntFile:  {"1\n", "Memory.Failcnt"},
+
+		cPUUsageFile:       {"1\n", "CPU.UsageNanoseconds"},
+		cPUUsagePerCPUFile: {"1 2 \n", "CPU.PerCPU"},
+
+		iOStatFile:        {"8:0 rbytes=1 depth=1 avg_lat=2 win=3\n", "BlkIO"},
+		cgroupStatFile:    {"nr_descendants 1\n", "Cgroup"},
+		cgroupEventsFile:  {"populated 1\n", "Cgroup"},
+		cgroupProcsFile:   {"1\n", "Cgroup"},
+		cgroupThreadsFile: {"1\n", "Cgroup.Threads"},
+		cgroupTypeFile:    {"threaded\n", "Cgroup.Type"},
+		freezerStateFile:  {"FROZEN\n", "Cgroup.Frozen"},
+		pidsCurrentFile:   {"1\n", "Pids"},
+
+		memLimitFile:          {"1\n", "Limits.Memory"},
+		memMaxFile:            {"1\n", "Limits.Memory"},
+		memSoftLimitFile:      {"1\n", "Limits.MemorySoft"},
+		memLowFile:            {"1\n", "Limits.MemorySoft"},
+		memHighFile:           {"1\n", "Limits.MemoryHigh"},
+		memSwLimitFile:        {"1\n", "Limits.MemSwap"},
+		memSwapMaxFile:        {"1\n", "Limits.Swap"},
+		cPUQuotaFile:          {"1\n", "Limits.CPUQuota"},
+		cPUPeriodFile:         {"1\n", "Limits.CPUPeriod"},
+		cPUMaxFile:            {"max 100000\n", "Limits"},
+		cPUSharesFile:         {"1\n", "Limits.CPUShares"},
+		cPUWeightFile:         {"1\n", "Limits.CPUWeight"},
+		cPUNiceFile:           {"-5\n", "Limits.CPUNice"},
+		cPUIdleFile:           {"1\n", "Limits.CPUIdle"},
+		pidsMaxFile:           {"1\n", "Limits.Pids"},
+		iOMaxFile:             {"8:0 rbps=1\n", "Limits.IO"},
+		blkIOReadBpsFile:      {"8:0 1\n", "Limits.IO"},
+		blkIOWriteBpsFile:     {"8:0 1\n", "Limits.IO"},
+		blkIOReadIOPSFile:     {"8:0 1\n", "Limits.IO"},
+		blkIOWriteIOPSFile:    {"8:0 1\n", "Limits.IO"},
+		blkIOWeightFile:       {"1\n", "Limits.IOWeight"},
+		blkIOWeightDeviceFile: {"8:0 1\n", "Limits.IOWeightDevices"},
+		iOWeightFile:          {"default 1\n", "Limits.IOWeight"},
+		iOBFQWeightFile:       {"1\n", "Limits.BFQWeight"},
+		iOLatencyFile:         {"8:0 target=1\n", "Limits.IOLatency"},
+	}
+
+	parsers := make(map[string]func(cs *Cstats, content string))
+	for name, parse := range statFiles {
+		parsers[name] = parse
+	}
+	for name, parse := range limitFiles {
+		parsers[name] = parse
+	}
+	for name, parse := range parsers {
+		f, ok := files[name]
+		if !ok {
+			t.Errorf("%s: no test content, add it to TestFileFields", name)
+			continue
+		}
+		cs := &Cstats{}
+		parse(cs, f.content)
+		paths := nonZeroPaths(reflect.ValueOf(cs).Elem(), "", 0)
+		if len(paths) == 0 {
+			t.Errorf("%s: expected %s to be populated", name, f.field)
+		}
+		for _, p := range paths {
+			if p != f.field && !strings.HasPrefix(p, f.field+".") {
+				t.Errorf("%s: populated %s, expected only %s", name, p, f.field)
+			}
+		}
+	}
+}
+
+func TestBlkServiced(t *testing.T) {
+	tests := []struct {
+		file    string
+		devices []BlkDevice
+	}{
+		{"empty", []BlkDevice{}},
+		{"multi_device", []BlkDevice{
+			{Major: 8, Minor: 16, Read: 4096, Sync: 4096},
+			{Major: 8, Minor: 0, Read: 1134592, Write: 8192, Sync: 8192, Async: 1134592},
+			{Major: 253, Minor: 0, Read: 1134592, Write: 8192, Sync: 8192, Async: 1134592},
+		}},
+		{"interleaved", []BlkDevice{
+			{Major: 8, Minor: 0, Read: 10, Write: 30, Sync: 50, Async: 70},
+			{Major: 8, Minor: 16, Read: 20, Write: 40, Sync: 60, Async: 80},
+		}},
+	}
+	for _, tt := range tests {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "blkio", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s BlkServiced
+		s.create(packageConfig(), string(b))
+		if !reflect.DeepEqual(s.Devices, tt.devices) {
+			t.Errorf("%s: expected devices %+v, got %+v", tt.file, tt.devices, s.Devices)
+		}
+	}
+}
+
+func TestBlkIODevices(t *testing.T) {
+	sys := t.TempDir()
+	block := filepath.Join(sys, "dev", "block")
+	if err := os.MkdirAll(block, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, name := range map[string]string{"7:0": "loop0", "259:0": "nvme0n1", "253:0": "dm-0"} {
+		if err := os.Symlink("../../devices/virtual/block/"+name, filepath.Join(block, key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	SysPath = sys
+	BlkIODevices = []string{"nvme*", "8:*"}
+	defer func() { SysPath, BlkIODevices = "/sys", nil }()
+
+	var s BlkServiced
+	s.create(packageConfig(), "7:0 Read 1\n8:16 Read 2\n259:0 Read 3\n253:0 Read 4\n")
+	if len(s.Devices) != 2 || s.Devices[0].Key() != "8:16" || s.Devices[1].Key() != "259:0" {
+		t.Errorf("expected devices 8:16 and 259:0, got %+v", s.Devices)
+	}
+	var b BlkIOStat
+	b.createIOStat(packageConfig(), "7:0 rbytes=1 wbytes=0 rios=1 wios=0\n259:0 rbytes=2 wbytes=0 rios=1 wios=0\n")
+	if len(b.Bytes.Devices) != 1 || b.Bytes.Devices[0].Key() != "259:0" {
+		t.Errorf("expected device 259:0, got %+v", b.Bytes.Devices)
+	}
+}
+
+func TestResolveLoopDevices(t *testing.T) {
+	sys := t.TempDir()
+	loop := filepath.Join(sys, "devices", "virtual", "block", "loop3")
+	if err := os.MkdirAll(filepath.Join(loop, "loop"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(loop, "loop", "backing_file"), []byte("/var/lib/images/app.img\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(sys, "dev", "block"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(loop, filepath.Join(sys, "dev", "block", "7:3")); err != nil {
+		t.Fatal(err)
+	}
+	SysPath = sys
+	defer func() { SysPath = "/sys" }()
+
+	var b BlkIOStat
+	b.createIOStat(packageConfig(), "7:3 rbytes=4096 wbytes=0 rios=1 wios=0\n8:0 rbytes=4096 wbytes=0 rios=1 wios=0\n")
+	b.resolveLoopDevices(sys)
+	for _, devices := range [][]BlkDevice{b.Bytes.Devices, b.IOPS.Devices} {
+		if devices[0].BackingFile != "/var/lib/images/app.img" || devices[1].BackingFile != "" {
+			t.Errorf("unexpected backing files %+v", devices)
+		}
+	}
+}
+
+func TestByDevice(t *testing.T) {
+	now := time.Now()
+	prev := BlkServiced{Timestamp: now, Devices: []BlkDevice{{Major: 8, Read: 100}, {Major: 8, Minor: 16, Read: 50}}}
+	cur := BlkServiced{Timestamp: now.Add(time.Second), Devices: []BlkDevice{{Major: 8, Minor: 32, Read: 10}, {Major: 8, Read: 300}}}
+
+	m := cur.ByDevice()
+	if len(m) != 2 || m["8:0"].Read != 300 || m["8:32"].Read != 10 {
+		t.Fatalf("unexpected device map %+v", m)
+	}
+
+	rates := newDeviceRates(&cur, &prev)
+	if r := rates.rate("8:0"); r.Read != 200 {
+		t.Errorf("expected 8:0 read rate 200, got %f", r.Read)
+	}
+	// 8:32 appeared and 8:16 disappeared since the previous sample
+	for _, key := range []string{"8:16", "8:32"} {
+		if r := rates.rate(key); r != (rwRate{}) {
+			t.Errorf("expected no rate for %s, got %+v", key, r)
+		}
+	}
+}
+
+func TestSampleIntervals(t *testing.T) {
+	cs := addTestContainer(t, "sampled", map[string]string{
+		memUsageFile:    "100\n",
+		pidsCurrentFile: "1\n",
+		cgroupProcsFile: "1\n",
+	})
+	SubsystemIntervals = map[string]time.Duration{"memory": time.Hour}
+	defer func() { SubsystemIntervals = nil }()
+	if err := SetSampleInterval("sampled", "pids", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSampleInterval("missing", "pids", time.Hour); err == nil {
+		t.Errorf("expected error for unknown container")
+	}
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := map[string]string{memUsageFile: "200\n", pidsCurrentFile: "2\n", cgroupProcsFile: "1\n2\n"}
+	for name, content := range updated {
+		if err := ioutil.WriteFile(cs.files[name], []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := stats["sampled"]
+	if stat.Memory.Usage != 100 || stat.Pids.Current != 1 {
+		t.Errorf("expected memory and pids not to be re-read, got usage %d pids %d", stat.Memory.Usage, stat.Pids.Current)
+	}
+	if stat.Cgroup.Procs != 2 {
+		t.Errorf("expected cgroup to be re-read, got procs %d", stat.Cgroup.Procs)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	cs := addTestContainer(t, "tenant", map[string]string{memUsageFile: "100\n"})
+	statsHolder.Lock()
+	cs.path = "tenants.slice/a.slice/tenant.scope"
+	statsHolder.Unlock()
+	err := AddScope(Scope{
+		Name:               "a",
+		Path:               "/tenants.slice/a.slice/",
+		SubsystemIntervals: map[string]time.Duration{"memory": time.Hour},
+		HistoryRetention:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveScope("a")
+	if err := AddScope(Scope{Name: "a", Path: "other"}); err == nil {
+		t.Errorf("expected an error for a duplicate scope")
+	}
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cs.files[memUsageFile], []byte("200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := stats["tenant"]
+	if stat.Metadata[ScopeLabel] != "a" || stat.Memory.Usage != 100 {
+		t.Errorf("expected the scope's memory interval to apply, got label %q usage %d", stat.Metadata[ScopeLabel], stat.Memory.Usage)
+	}
+	if s, err := Summarize("tenant", time.Minute); err != nil || s.Samples != 2 {
+		t.Errorf("expected the scope's history retention to apply, got %+v, %v", s, err)
+	}
+	for id, stat := range stats {
+		if id != "tenant" && stat.Metadata[ScopeLabel] != "" {
+			t.Errorf("%s: expected no scope, got %q", id, stat.Metadata[ScopeLabel])
+		}
+	}
+
+	RemoveScope("a")
+	stats, err = ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["tenant"].Metadata[ScopeLabel]; ok {
+		t.Errorf("expected the scope label to be removed with the scope")
+	}
+
+	h := &holder{info: CgroupInfo{Version: 1}}
+	if p := h.hierarchyPath("/sys/fs/cgroup", "/sys/fs/cgroup/memory/kubepods/burstable/pod1"); p != "kubepods/burstable/pod1" {
+		t.Errorf("unexpected cgroup v1 path %q", p)
+	}
+	h.info.Version = 2
+	if p := h.hierarchyPath("/sys/fs/cgroup", "/sys/fs/cgroup/kubepods.slice/pod1"); p != "kubepods.slice/pod1" {
+		t.Errorf("unexpected cgroup v2 path %q", p)
+	}
+}
+
+func TestCollectionBudget(t *testing.T) {
+	hi := addTestContainer(t, "hi", map[string]string{memUsageFile: "100\n"})
+	lo := addTestContainer(t, "lo", map[string]string{memUsageFile: "100\n"})
+	if err := SetPriority("hi", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPriority("missing", 1); err == nil {
+		t.Errorf("expected error for unknown container")
+	}
+	if order := statsHolder.collectionOrder(); order[0] != "hi" {
+		t.Errorf("expected the prioritized container first, got %v", order)
+	}
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	for _, cs := range []*Cstats{hi, lo} {
+		if err := ioutil.WriteFile(cs.files[memUsageFile], []byte("200\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	CollectionBudget = time.Nanosecond
+	defer func() { CollectionBudget = 0 }()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats["hi"]; s.Skipped || s.Memory.Usage != 200 {
+		t.Errorf("expected the prioritized container to be collected, got skipped %v usage %d", s.Skipped, s.Memory.Usage)
+	}
+	if s := stats["lo"]; !s.Skipped || s.Memory.Usage != 100 || s.Generation != stats["hi"].Generation {
+		t.Errorf("expected the previous statistics of a skipped container, got skipped %v usage %d", s.Skipped, s.Memory.Usage)
+	}
+	r := LastCollection()
+	if !r.Overrun || r.Collected != 1 || len(r.Skipped) != len(stats)-1 || r.Generation != stats["hi"].Generation {
+		t.Errorf("unexpected collection report %+v", r)
+	}
+	if !stats["hi"].Overrun || !stats["lo"].Overrun {
+		t.Errorf("expected the snapshot to be marked as overrun")
+	}
+
+	CollectionBudget = 0
+	CollectionDeadline = time.Nanosecond
+	defer func() { CollectionDeadline = 0 }()
+	stats, err = ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats["hi"].Skipped || !stats["lo"].Skipped {
+		t.Errorf("expected every container to be skipped past the deadline")
+	}
+	if r := LastCollection(); !r.Overrun || r.Collected != 0 {
+		t.Errorf("unexpected collection report %+v", r)
+	}
+
+	CollectionDeadline = 0
+	if stats, err = ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	if stats["hi"].Overrun || stats["lo"].Overrun {
+		t.Errorf("expected a complete snapshot not to be marked as overrun")
+	}
+	if r := LastCollection(); r.Overrun || len(r.Skipped) != 0 || r.Collected != len(stats) {
+		t.Errorf("unexpected collection report %+v", r)
+	}
+}
+
+func TestSecureReads(t *testing.T) {
+	base := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(outside, []byte("secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(base, memUsageFile)
+	if err := ioutil.WriteFile(inside, []byte("100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(base, memCurrentFile)
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &holder{}
+	if err := h.openSecureRoot(base, true); err != nil {
+		t.Skip(err)
+	}
+	defer h.openSecureRoot("", false)
+	if b, err := readFile(inside); err != nil || string(b) != "100\n" {
+		t.Errorf("expected to read %s, got %q, %v", inside, b, err)
+	}
+	if _, err := readFile(link); err == nil {
+		t.Errorf("expected reading a symlink to fail")
+	}
+	if _, err := readFile(base + "/../" + filepath.Base(filepath.Dir(outside)) + "/secret"); err == nil {
+		t.Errorf("expected reading outside of the base path to fail")
+	}
+	// files outside of the base path, such as in /proc, are read as usual
+	if b, err := readFile(outside); err != nil || string(b) != "secret\n" {
+		t.Errorf("expected to read %s, got %q, %v", outside, b, err)
+	}
+	h.openSecureRoot("", false)
+
+	// Collectors enforce their own SecureReads
+	if err := ioutil.WriteFile(filepath.Join(base, cgroupControllersFile), []byte("memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{BasePath: base, SecureReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFile(link); err == nil {
+		t.Errorf("expected reading a symlink below a Collector's base path to fail")
+	}
+	c.Close()
+	if _, err := readFile(link); err != nil {
+		t.Errorf("expected the secure root to be released by Close, got %v", err)
+	}
+}
+
+func TestProbePermissions(t *testing.T) {
+	cs := addTestContainer(t, "perms", map[string]string{memUsageFile: "1\n", pidsMaxFile: "10\n"})
+	if err := os.Chmod(cs.files[memUsageFile], 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ProbePermissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var memory, pids *SubsystemPermissions
+	for i, s := range r.Subsystems {
+		switch s.Subsystem {
+		case "memory":
+			memory = &r.Subsystems[i]
+		case "pids":
+			pids = &r.Subsystems[i]
+		}
+	}
+	if memory == nil || pids == nil {
+		t.Fatalf("expected memory and pids permissions, got %+v", r.Subsystems)
+	}
+	if !pids.Readable() {
+		t.Errorf("expected pids files to be readable, got %+v", pids.Files)
+	}
+	// root reads files regardless of their mode
+	if memory.Readable() != (r.UID == 0) || r.Readable() != (r.UID == 0) {
+		t.Errorf("unexpected memory permissions for UID %d: %+v", r.UID, memory.Files)
+	}
+
+	denied := &os.PathError{Op: "open", Path: cs.files[memUsageFile], Err: os.ErrPermission}
+	if unreadable(packageConfig(), denied) {
+		t.Errorf("expected permission errors to be reported without SkipUnreadable")
+	}
+	SkipUnreadable = true
+	defer func() { SkipUnreadable = false }()
+	if !unreadable(packageConfig(), denied) || unreadable(packageConfig(), os.ErrNotExist) {
+		t.Errorf("expected only permission errors to be skipped with SkipUnreadable")
+	}
+	if r.UID != 0 {
+		for _, f := range memory.Files {
+			if f.Name == memUsageFile && (f.Denied != 1 || f.DeniedPath != cs.files[memUsageFile] || f.RequiresRoot) {
+				t.Errorf("unexpected permission %+v", f)
+			}
+		}
+	}
+}
+
+func TestReadRetries(t *testing.T) {
+	orig := readFileOnce
+	defer func() { readFileOnce = orig }()
+	failures := 2
+	readFileOnce = func(path string) ([]byte, error) {
+		if filepath.Base(path) == memUsageFile && failures > 0 {
+			failures--
+			return nil, &os.PathError{Op: "read", Path: path, Err: syscall.EAGAIN}
+		}
+		return orig(path)
+	}
+	addTestContainer(t, "retried", map[string]string{memUsageFile: "100\n"})
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["retried"].Memory.Usage != 100 {
+		t.Errorf("expected the read to succeed once retried, got usage %d", stats["retried"].Memory.Usage)
+	}
+	if r := LastCollection(); r.Retries != 2 {
+		t.Errorf("expected 2 retries, got %d", r.Retries)
+	}
+
+	failures = maxReadRetries + 1
+	if _, err := ReadStats(); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("expected EAGAIN once retries are exhausted, got %v", err)
+	}
+}
+
+func TestAdaptiveSampling(t *testing.T) {
+	load := 0.0
+	orig := hostLoad
+	hostLoad = func() (float64, error) { return load, nil }
+	AdaptiveSampling = true
+	defer func() {
+		AdaptiveSampling = false
+		hostLoad = orig
+	}()
+
+	var a adaptive
+	interval := time.Second
+	steps := []struct {
+		took   time.Duration
+		load   float64
+		expect time.Duration
+	}{
+		{time.Millisecond, 0, time.Second},
+		{time.Second, 0, 2 * time.Second},
+		{time.Millisecond, 3, 4 * time.Second},
+		{time.Second, 3, 8 * time.Second},
+		// capped at AdaptiveMaxFactor
+		{time.Second, 3, 8 * time.Second},
+		// between half and the full threshold holds steady
+		{200 * time.Millisecond, 0, 8 * time.Second},
+		{time.Millisecond, 0, 4 * time.Second},
+		{time.Millisecond, 0, 2 * time.Second},
+		{time.Millisecond, 0, time.Second},
+		{time.Millisecond, 0, time.Second},
+	}
+	for i, s := range steps {
+		load = s.load
+		if d := a.update(packageConfig(), interval, s.took); d != s.expect {
+			t.Errorf("step %d: expected interval %s, got %s", i, s.expect, d)
+		}
+	}
+}
+
+func TestMaxContainers(t *testing.T) {
+	MaxContainers = 2
+	defer func() {
+		MaxContainers = 0
+		ContainerOverflow = OverflowDropNewest
+	}()
+	newHolder := func() *holder {
+		return &holder{containers: Cmap{
+			"busy": {Utilization: Utilization{CPUPct: 50}},
+			"idle": {Utilization: Utilization{CPUPct: 1}},
+		}}
+	}
+
+	tests := []struct {
+		policy  OverflowPolicy
+		admit   bool
+		dropped string
+		tracked int
+	}{
+		{OverflowDropNewest, false, "new", 2},
+		{OverflowDropLeastActive, true, "idle", 2},
+		{OverflowWarn, true, "", 3},
+	}
+	for _, tt := range tests {
+		ContainerOverflow = tt.policy
+		h := newHolder()
+		ch := make(chan Overflow, 1)
+		h.overflow = ch
+		admit := h.admit("new")
+		if admit != tt.admit {
+			t.Errorf("policy %d: expected admit %t, got %t", tt.policy, tt.admit, admit)
+		}
+		if admit {
+			h.containers["new"] = &Cstats{}
+		}
+		ev := <-ch
+		if ev.ID != "new" || ev.Dropped != tt.dropped || ev.Tracked != tt.tracked {
+			t.Errorf("policy %d: unexpected event %+v", tt.policy, ev)
+		}
+		if tt.dropped != "" {
+			if _, ok := h.containers[tt.dropped]; ok && tt.dropped != "new" {
+				t.Errorf("policy %d: expected %s to be dropped", tt.policy, tt.dropped)
+			}
+			// dropped containers found again are not reported again
+			h.endScan()
+			if h.admit(tt.dropped) {
+				t.Errorf("policy %d: expected %s to stay dropped", tt.policy, tt.dropped)
+			}
+			select {
+			case ev := <-ch:
+				t.Errorf("policy %d: unexpected repeat event %+v", tt.policy, ev)
+			default:
+			}
+		}
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	h := &holder{
+		snapshot: Cmap{"a": {}, "b": {}, "gone": {Inactive: true}},
+		history: map[string][]sample{
+			"a":    make([]sample, 100),
+			"b":    make([]sample, 10),
+			"gone": make([]sample, 100),
+		},
+	}
+	r := h.retained()
+	if r.History == 0 || r.Snapshot == 0 {
+		t.Fatalf("expected non-zero retained memory, got %+v", r)
+	}
+
+	size := int(unsafe.Sizeof(sample{}))
+	MemoryBudget = r.Snapshot + 40*size
+	defer func() { MemoryBudget = 0 }()
+	h.compact()
+	if _, ok := h.history["gone"]; ok {
+		t.Errorf("expected history of inactive container to be dropped")
+	}
+	if len(h.history["a"]) != 20 || len(h.history["b"]) != 10 {
+		t.Errorf("expected 20 and 10 samples kept, got %d and %d", len(h.history["a"]), len(h.history["b"]))
+	}
+	if total := h.retained().Total(); total > MemoryBudget {
+		t.Errorf("retained %d exceeds budget %d", total, MemoryBudget)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := 0; i < 3; i++ {
+		cs := &Cstats{Metadata: map[string]string{"app": "web"}}
+		cs.CPU.Timestamp = now.Add(time.Duration(i) * time.Second)
+		cs.CPU.User = uint64(i) * userHZ
+		cs.Limits.CPUQuota, cs.Limits.CPUPeriod = 100000, 100000
+		if err := enc.Encode(cs.CPU.Timestamp, Cmap{"c": cs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []Snapshot
+	err := Replay(NewDecoder(&buf), func(s Snapshot) error {
+		got = append(got, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(got))
+	}
+	if !got[2].Time.Equal(now.Add(2*time.Second)) || got[2].Stats["c"].Metadata["app"] != "web" {
+		t.Errorf("snapshot not decoded as encoded: %+v", got[2])
+	}
+	if pct := got[0].Stats["c"].Utilization.CPUPct; pct != 0 {
+		t.Errorf("expected no CPU utilization for the first snapshot, got %f", pct)
+	}
+	if pct := got[2].Stats["c"].Utilization.CPUPct; pct != 100 {
+		t.Errorf("expected replayed CPU utilization 100, got %f", pct)
+	}
+}
+
+func TestCollectHooks(t *testing.T) {
+	addTestContainer(t, "vetoed", map[string]string{pidsCurrentFile: "1\n"})
+	var before, after int
+	var collected Cmap
+	BeforeCollect = func() { before++ }
+	OnContainer = func(id string, cs *Cstats) bool {
+		cs.setMetadata("seen", "yes")
+		return id != "vetoed"
+	}
+	AfterCollect = func(stats Cmap, took time.Duration, err error) {
+		after++
+		collected = stats
+		if err != nil || took <= 0 {
+			t.Errorf("unexpected AfterCollect took %s err %v", took, err)
+		}
+	}
+	defer func() {
+		BeforeCollect, OnContainer, AfterCollect = nil, nil, nil
+	}()
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 1 || after != 1 {
+		t.Errorf("expected hooks called once, got before %d after %d", before, after)
+	}
+	if _, ok := stats["vetoed"]; ok {
+		t.Errorf("expected vetoed container to be left out")
+	}
+	if len(stats) == 0 || len(collected) != len(stats) {
+		t.Errorf("expected AfterCollect to get the %d containers collected, got %d", len(stats), len(collected))
+	}
+	for id, cs := range stats {
+		if cs.Metadata["seen"] != "yes" {
+			t.Errorf("%s: expected OnContainer changes to be kept", id)
+		}
+		SetMetadata(id, "seen", "")
+	}
+}
+
+func TestDerived(t *testing.T) {
+	remove := AddDerived(func(id string, cur, prev *Cstats) map[string]float64 {
+		var first float64
+		if prev == nil {
+			first = 1
+		}
+		return map[string]float64{"procs": float64(cur.Cgroup.Procs), "first": first, "bad": math.NaN()}
+	})
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, cs := range stats {
+		if _, ok := cs.Derived["bad"]; ok {
+			t.Errorf("%s: expected NaN to be dropped", id)
+		}
+		if cs.Derived["procs"] != float64(cs.Cgroup.Procs) || cs.Derived["first"] != 0 {
+			t.Errorf("%s: unexpected derived metrics %v", id, cs.Derived)
+		}
+	}
+
+	remove()
+	stats, err = ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, cs := range stats {
+		if cs.Derived != nil {
+			t.Errorf("%s: expected no derived metrics once removed, got %v", id, cs.Derived)
+		}
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	first, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, cs := range second {
+		prev, ok := first[id]
+		if !ok {
+			continue
+		}
+		if cs.Generation != prev.Generation+1 || cs.Revision != prev.Revision+1 {
+			t.Errorf("%s: expected generation and revision to advance by one, got %d/%d then %d/%d",
+				id, prev.Generation, prev.Revision, cs.Generation, cs.Revision)
+		}
+		if cs.Revision > cs.Generation {
+			t.Errorf("%s: revision %d ahead of generation %d", id, cs.Revision, cs.Generation)
+		}
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	a := &accountant{maxGap: time.Minute, usage: make(map[string]*Usage), last: make(map[string]accountSample)}
+	now := time.Now()
+	cs := func(cpuUsec, rss, read uint64) *Cstats {
+		c := &Cstats{}
+		c.CPU = CPUStat{Usage: cpuUsec, Units: CPUUnitMicroseconds}
+		c.Memory.RSS = rss
+		c.BlkIO.TotalReadBytes = read
+		return c
+	}
+	a.add("c", cs(1e6, 1<<30, 100), now)
+	a.add("c", cs(3e6, 3<<30, 300), now.Add(30*time.Second))
+	// missed collections, RSS is not integrated over the gap
+	a.add("c", cs(4e6, 1<<30, 400), now.Add(10*time.Minute))
+	// the container restarted, resetting its counters
+	a.add("c", cs(1e6, 1<<30, 50), now.Add(10*time.Minute+30*time.Second))
+
+	u := a.usage["c"]
+	if u.CPUSeconds != 4 || u.ReadBytes != 350 {
+		t.Errorf("expected 4 CPU seconds and 350 bytes read, got %+v", u)
+	}
+	expected := float64(2<<30)*(30*time.Second).Hours() + float64(1<<30)*(30*time.Second).Hours()
+	if math.Abs(u.RSSByteHours-expected) > 1 {
+		t.Errorf("expected %f RSS byte hours, got %f", expected, u.RSSByteHours)
+	}
+	if !u.Start.Equal(now) || !u.End.Equal(now.Add(10*time.Minute+30*time.Second)) {
+		t.Errorf("unexpected period %s to %s", u.Start, u.End)
+	}
+
+	StartAccounting(0)
+	defer StopAccounting()
+	for i := 0; i < 2; i++ {
+		if _, err := ReadStats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage := ReadAccounting(true)
+	if len(usage) == 0 {
+		t.Fatal("expected usage to be accounted")
+	}
+	for id, u := range ReadAccounting(false) {
+		if u.CPUSeconds != 0 || !u.Start.Equal(usage[id].End) {
+			t.Errorf("%s: expected an empty period from the last collection, got %+v", id, u)
+		}
+	}
+}
+
+func TestUsageRecords(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	records := UsageRecords(map[string]Usage{
+		"b": {Start: start, End: end, CPUSeconds: 1.5, RSSByteHours: 1 << 29, ReadBytes: 10, WriteBytes: 5},
+		"a": {Start: start, End: end},
+	})
+	if len(records) != 2 || records[0].Container != "a" || records[1].GBHours != 0.5 || records[1].IOBytes != 15 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	var buf bytes.Buffer
+	if err := NewJSONUsageWriter(&buf).Write(records[1:]); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"container":"b","period_start":"2024-01-01T00:00:00Z","period_end":"2024-01-01T01:00:00Z","cpu_seconds":1.5,"gb_hours":0.5,"io_bytes":15}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected JSON %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	w := NewCSVUsageWriter(&buf)
+	for _, r := range records {
+		if err := w.Write([]UsageRecord{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected = "container,period_start,period_end,cpu_seconds,gb_hours,io_bytes\n" +
+		"a,2024-01-01T00:00:00Z,2024-01-01T01:00:00Z,0,0,0\n" +
+		"b,2024-01-01T00:00:00Z,2024-01-01T01:00:00Z,1.5,0.5,15\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV %q, got %q", expected, buf.String())
+	}
+}
+
+func TestCPUStat(t *testing.T) {
+	var v1 CPUStat
+	v1.create("user 150\nsystem 50\n")
+	// the cgroup v1 cpu.stat only holds throttling statistics
+	v1.createCPUStat("nr_periods 10\nnr_throttled 2\nthrottled_time 1000\n")
+	if v1.Units != CPUUnitTicks || v1.UserTime() != 1500*time.Millisecond || v1.Total() != 2*time.Second {
+		t.Errorf("unexpected v1 CPU stats %+v", v1)
+	}
+	if v1.Extra != nil {
+		t.Errorf("expected no extra keys, got %v", v1.Extra)
+	}
+	// keys are matched by name, whatever their order, and any others kept
+	v1.create("system 60\nuser 160\nsteal 5\n")
+	if v1.User != 160 || v1.System != 60 || len(v1.Extra) != 1 || v1.Extra["steal"] != 5 {
+		t.Errorf("unexpected v1 CPU stats with extra keys %+v", v1)
+	}
+
+	var v2 CPUStat
+	v2.createCPUStat("usage_usec 2500000\nuser_usec 2000000\nsystem_usec 400000\nnr_periods 0\n")
+	if v2.Units != CPUUnitMicroseconds || v2.SystemTime() != 400*time.Millisecond || v2.Total() != 2500*time.Millisecond {
+		t.Errorf("unexpected v2 CPU stats %+v", v2)
+	}
+
+	v1.createCPUStat("nr_periods 10\nnr_throttled 2\nthrottled_time 1000\nnr_bursts 3\nburst_time 5000000\n")
+	if v1.NrBursts != 3 || v1.BurstTime != 5*time.Millisecond || v1.ThrottledTime != time.Microsecond || v1.Units != CPUUnitTicks {
+		t.Errorf("unexpected v1 burst stats %+v", v1)
+	}
+	v2.createCPUStat("usage_usec 2500000\nnr_bursts 4\nburst_usec 2000\n")
+	if v2.NrBursts != 4 || v2.BurstTime != 2*time.Millisecond {
+		t.Errorf("unexpected v2 burst stats %+v", v2)
+	}
+}
+
+func TestTaskStates(t *testing.T) {
+	ProcPath = "testdata/proc"
+	TaskStates = true
+	defer func() {
+		ProcPath = "/proc"
+		TaskStates = false
+	}()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		got := stat.Tasks
+		got.Timestamp = time.Time{}
+		if want := (TaskStat{Running: 1, Sleeping: 1, Uninterruptible: 1, Zombie: 1}); got != want {
+			t.Errorf("%s: expected tasks %+v, got %+v", id, want, got)
+		}
+	}
+}
+
+func TestSchedStats(t *testing.T) {
+	ProcPath = "testdata/proc"
+	SchedStats = true
+	defer func() {
+		ProcPath = "/proc"
+		SchedStats = false
+	}()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		got := stat.Tasks
+		if got.Running != 0 {
+			t.Errorf("%s: expected task states not to be counted without TaskStates", id)
+		}
+		if got.RunTime != 4*time.Millisecond || got.RunDelay != 2*time.Millisecond || got.Timeslices != 40 {
+			t.Errorf("%s: expected the schedstat of 4 tasks, got %+v", id, got)
+		}
+	}
+}
+
+func TestNetStats(t *testing.T) {
+	ProcPath = "testdata/proc"
+	NetStats = true
+	defer func() {
+		ProcPath = "/proc"
+		NetStats = false
+	}()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		n := stat.Net
+		if len(n.Interfaces) != 2 || n.Interfaces[1].Name != "eth0" || n.Interfaces[0].RxBytes != 2048 {
+			t.Errorf("%s: expected lo and eth0, got %+v", id, n.Interfaces)
+		}
+		// loopback is left out of the sums
+		if n.RxBytes != 1048576 || n.RxPackets != 1000 || n.RxErrors != 1 || n.RxDropped != 2 ||
+			n.TxBytes != 524288 || n.TxPackets != 500 || n.TxDropped != 3 {
+			t.Errorf("%s: unexpected network counters %+v", id, n)
+		}
+	}
+}
+
+func TestPSSUsage(t *testing.T) {
+	ProcPath = "testdata/proc"
+	PSSUsage = true
+	defer func() {
+		ProcPath = "/proc"
+		PSSUsage = false
+	}()
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		// 8MiB and 4MiB from processes 2869 and 2901
+		if stat.Memory.PSS != 12<<20 || stat.Memory.SwapPSS != 1<<20 {
+			t.Errorf("%s: expected PSS 12MiB and SwapPSS 1MiB, got %d and %d", id, stat.Memory.PSS, stat.Memory.SwapPSS)
+		}
+	}
+}
+
+func TestFSUsage(t *testing.T) {
+	cs := addTestContainer(t, "fs", map[string]string{cgroupProcsFile: "42\n"})
+	proc := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(proc, "42", "root"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ProcPath = proc
+	FSUsage = true
+	defer func() {
+		ProcPath = "/proc"
+		FSUsage = false
+		FSPathTemplate = ""
+	}()
+	if err := cs.readFS(packageConfig(), "fs"); err != nil {
+		t.Fatal(err)
+	}
+	if cs.FS.Path != filepath.Join(proc, "42", "root") || cs.FS.Capacity == 0 || cs.FS.Usage > cs.FS.Capacity {
+		t.Errorf("unexpected root filesystem usage %+v", cs.FS)
+	}
+
+	layers := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(layers, "fs", "diff"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 64*1024)
+	p := filepath.Join(layers, "fs", "diff", "data")
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(p, p+".link"); err != nil {
+		t.Fatal(err)
+	}
+	FSPathTemplate = filepath.Join(layers, "{id}", "diff")
+	if err := cs.readFS(packageConfig(), "fs"); err != nil {
+		t.Fatal(err)
+	}
+	// the hard link is only counted once
+	if cs.FS.Usage < uint64(len(data)) || cs.FS.Usage >= 2*uint64(len(data)) {
+		t.Errorf("expected usage of about %d bytes, got %d", len(data), cs.FS.Usage)
+	}
+
+	// containers whose path is gone aren't an error
+	FSPathTemplate = filepath.Join(layers, "{id}", "missing")
+	if _, err := ReadStats(); err != nil {
+		t.Errorf("expected missing path to be ignored, got %s", err)
+	}
+}
+
+func TestFSInodes(t *testing.T) {
+	cs := addTestContainer(t, "inodes", map[string]string{})
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	FSPathTemplate = dir
+	defer func() { FSPathTemplate = "" }()
+	if err := cs.readFS(packageConfig(), "inodes"); err != nil {
+		t.Fatal(err)
+	}
+	// the directory and its three files
+	if cs.FS.InodesUsed != 4 {
+		t.Errorf("expected 4 inodes used, got %d", cs.FS.InodesUsed)
+	}
+	if cs.FS.Inodes != 0 && cs.FS.InodesFree > cs.FS.Inodes {
+		t.Errorf("free inodes %d exceed total %d", cs.FS.InodesFree, cs.FS.Inodes)
+	}
+}
+
+func TestTmpfsUsage(t *testing.T) {
+	cs := addTestContainer(t, "tmpfs", map[string]string{cgroupProcsFile: "42\n"})
+	proc := t.TempDir()
+	for _, dir := range []string{"dev/shm", "run/my dir"} {
+		if err := os.MkdirAll(filepath.Join(proc, "42", "root", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mounts := "overlay / overlay rw,relatime 0 0\n" +
+		"tmpfs /dev/shm tmpfs rw,nosuid,nodev,size=65536k 0 0\n" +
+		"tmpfs /run/my\\040dir tmpfs rw 0 0\n" +
+		"tmpfs /gone tmpfs rw 0 0\n"
+	if err := ioutil.WriteFile(filepath.Join(proc, "42", "mounts"), []byte(mounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ProcPath = proc
+	defer func() { ProcPath = "/proc" }()
+
+	if err := cs.readTmpfs(proc); err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Tmpfs) != 2 || cs.Tmpfs[0].Path != "/dev/shm" || cs.Tmpfs[1].Path != "/run/my dir" {
+		t.Fatalf("unexpected tmpfs mounts %+v", cs.Tmpfs)
+	}
+	if cs.Tmpfs[0].Capacity == 0 {
+		t.Errorf("expected /dev/shm capacity, got %+v", cs.Tmpfs[0])
+	}
+}
+
+func TestAccelerators(t *testing.T) {
+	addTestContainer(t, "gpu", map[string]string{
+		cgroupProcsFile: "42\n43\n",
+		devicesListFile: "c 195:0 rwm\nc 195:255 rwm\n",
+	})
+	var gotPIDs []int
+	var gotDevices []string
+	Accelerators = func(id string, pids []int, devices []string) []Accelerator {
+		if id != "gpu" {
+			return nil
+		}
+		gotPIDs, gotDevices = pids, devices
+		return []Accelerator{{ID: "GPU-0", Utilization: 35, MemoryUsage: 1 << 30, Extra: map[string]float64{"power_watts": 120}}}
+	}
+	defer func() { Accelerators = nil }()
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotPIDs, []int{42, 43}) {
+		t.Errorf("expected pids [42 43], got %v", gotPIDs)
+	}
+	if !reflect.DeepEqual(gotDevices, []string{"c 195:0 rwm", "c 195:255 rwm"}) {
+		t.Errorf("unexpected devices %q", gotDevices)
+	}
+	acc := stats["gpu"].Accelerators
+	if len(acc) != 1 || acc[0].ID != "GPU-0" || acc[0].Extra["power_watts"] != 120 {
+		t.Fatalf("unexpected accelerators %+v", acc)
+	}
+	acc[0].Extra["power_watts"] = 0
+	MaxStaleness = time.Hour
+	defer func() { MaxStaleness = 0 }()
+	stats, err = ReadCachedStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["gpu"].Accelerators[0].Extra["power_watts"] != 120 {
+		t.Errorf("modifying returned statistics changed the snapshot")
+	}
+}
+
+func TestState(t *testing.T) {
+	StartAccounting(0)
+	defer StopAccounting()
+	for i := 0; i < 2; i++ {
+		if _, err := ReadStats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	statsHolder.Lock()
+	revisions := make(map[string]uint64)
+	for id, cs := range statsHolder.containers {
+		revisions[id] = cs.Revision
+		cs.prev = nil
+		cs.Revision = 0
+	}
+	statsHolder.accounting = nil
+	statsHolder.Unlock()
+
+	if err := LoadState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	statsHolder.Lock()
+	for id, cs := range statsHolder.containers {
+		if cs.prev == nil || cs.Revision != revisions[id] {
+			t.Errorf("%s: expected the saved statistics to be restored, got revision %d", id, cs.Revision)
+		}
+	}
+	statsHolder.Unlock()
+	if len(ReadAccounting(false)) == 0 {
+		t.Error("expected accounting to be restored")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs *Cstats
+	for _, cs = range stats {
+		break
+	}
+	metrics := make(map[string]bool)
+	for _, f := range Schema() {
+		if metrics[f.Metric] {
+			t.Errorf("duplicate metric %s", f.Metric)
+		}
+		metrics[f.Metric] = true
+		if (f.Type == Counter) != strings.HasSuffix(f.Metric, "_total") {
+			t.Errorf("%s: counter names must end in _total", f.Metric)
+		}
+		v := reflect.ValueOf(cs).Elem()
+		for _, name := range strings.Split(f.Name, ".") {
+			v = v.FieldByName(name)
+			if !v.IsValid() {
+				t.Fatalf("%s: no field %s in Cstats", f.Metric, f.Name)
+			}
+		}
+		if f.Unit == UnitSeconds || f.Unit == UnitPercent {
+			continue
+		}
+		if got := f.Value(cs); got != float64(v.Uint()) {
+			t.Errorf("%s: expected %d, got %f", f.Metric, v.Uint(), got)
+		}
+	}
+}
+
+func TestExportID(t *testing.T) {
+	id := "3f4e8c2a9b1d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	if got := TruncateIDs(12)(id); got != id[:12] {
+		t.Errorf("expected %s, got %s", id[:12], got)
+	}
+	hash := HashIDs("secret", 16)
+	if got := hash(id); len(got) != 16 || got != hash(id) || strings.HasPrefix(id, got) {
+		t.Errorf("expected a stable 16 character hash, got %s", got)
+	}
+	if HashIDs("other", 16)(id) == hash(id) {
+		t.Errorf("expected hashes to depend on the key")
+	}
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExportID = hash
+	defer func() { ExportID = nil }()
+	exported := Export(stats)
+	if len(exported) != len(stats) {
+		t.Fatalf("expected %d containers, got %d", len(stats), len(exported))
+	}
+	for id, cs := range stats {
+		e, ok := exported[hash(id)]
+		if !ok || !reflect.DeepEqual(e.CPU, cs.CPU) {
+			t.Errorf("%s: expected statistics keyed by %s", id, hash(id))
+			continue
+		}
+		if len(e.Sources) == 0 || len(e.Sources) != len(cs.Sources) {
+			t.Errorf("%s: expected sources %v, got %v", id, cs.Sources, e.Sources)
+		}
+		for s, dir := range e.Sources {
+			if strings.Contains(dir, id) || !strings.Contains(dir, hash(id)) {
+				t.Errorf("%s: expected the ID to be replaced in %s source %s", id, s, dir)
+			}
+		}
+	}
+	records := UsageRecords(map[string]Usage{id: {}})
+	if records[0].Container != hash(id) {
+		t.Errorf("expected the usage record of %s to be keyed by %s, got %s", id, hash(id), records[0].Container)
+	}
+}
+
+func TestPause(t *testing.T) {
+	addTestContainer(t, "paused", map[string]string{memUsageFile: "100\n"})
+	if err := Pause("paused"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pause("missing"); err == nil {
+		t.Errorf("expected error for unknown container")
+	}
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["paused"]; ok {
+		t.Errorf("expected the paused container to be left out")
+	}
+	var found bool
+	for _, info := range ListContainers() {
+		if info.ID == "paused" {
+			found = info.Paused
+		}
+	}
+	if !found {
+		t.Errorf("expected the container to be listed as paused")
+	}
+
+	if err := Resume("paused"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, ok := stats["paused"]; !ok || cs.Memory.Usage != 100 {
+		t.Errorf("expected the resumed container to be collected")
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c, err := New(Config{BasePath: "testdata/cgroup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	none, err := New(Config{BasePath: "testdata/cgroup", ContainerDirRegexp: `.*podman-([0-9a-z]{64})\.scope.*`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer none.Close()
+
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) == 0 {
+		t.Errorf("expected the collector to find containers")
+	}
+	if stats, err := none.ReadStats(); err != nil || len(stats) != 0 {
+		t.Errorf("expected no containers for a collector with another regexp, got %d, err %v", len(stats), err)
+	}
+	if stats, err := ReadStats(); err != nil || len(stats) == 0 {
+		t.Errorf("expected the package level containers to be unaffected, got %d, err %v", len(stats), err)
+	}
+
+	labeled, err := New(Config{BasePath: "testdata/cgroup", HostLabels: map[string]string{"host": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer labeled.Close()
+	stats, err = labeled.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	for id = range stats {
+		if stats[id].Metadata["host"] != "a" {
+			t.Errorf("expected the host labels of the collector on %s", id)
+		}
+	}
+	if err := labeled.SetMetadata(id, "team", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := labeled.ReadCachedStats(); err != nil || stats[id].Metadata["team"] != "x" {
+		t.Errorf("expected metadata set on the collector, got %v, err %v", stats[id], err)
+	}
+	stats, err = c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs := stats[id]; cs.Metadata["host"] != "" || cs.Metadata["team"] != "" {
+		t.Errorf("expected another collector to be unaffected, got %v", cs.Metadata)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c, err := New(Config{BasePath: "testdata/cgroup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := c.ReadStats(); err == nil {
+		t.Errorf("expected an error reading a closed collector")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := InitContext(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Init(nil)
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for i := 0; i < 100; i++ {
+		if _, err = ReadStats(); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err == nil {
+		t.Errorf("expected ReadStats to fail once the context is done")
+	}
+}
+
+func TestShutdownNoWatches(t *testing.T) {
+	// cgroup v1 has no cgroup.events files to watch
+	if err := Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Init(nil)
+	if err := NotifyCgroupEvents(make(chan CgroupEvent)); err != nil {
+		t.Fatal(err)
+	}
+	statsHolder.Lock()
+	w := statsHolder.events
+	statsHolder.Unlock()
+	Shutdown()
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Errorf("expected the event watcher to stop on Shutdown")
+	}
+}
+
+func TestFallbackIDs(t *testing.T) {
+	base := t.TempDir()
+	for p, content := range map[string]string{
+		"cgroup.controllers":                         "cpu memory\n",
+		"cgroup.procs":                               "1\n",
+		"memory.stat":                                "anon 4096\n",
+		"system.slice/cgroup.procs":                  "",
+		"system.slice/crio-a:b.scope/cgroup.procs":   "42\n",
+		"system.slice/crio-a:b.scope/memory.stat":    "anon 4096\n",
+		"system.slice/crio-a:b.scope/memory.current": "8192\n",
+		"system.slice/exited.scope/cgroup.procs":     "",
+		"system.slice/exited.scope/memory.stat":      "anon 0\n",
+	} {
+		p = filepath.Join(base, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := New(Config{BasePath: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.ReadStats()
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 0 {
+		t.Errorf("expected no containers without FallbackIDs, got %d", len(stats))
+	}
+
+	c, err = New(Config{BasePath: base, FallbackIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stats, err = c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected only the container with processes, got %d", len(stats))
+	}
+	cs, ok := stats["crio-a_b.scope"]
+	if !ok || !cs.Unmatched || cs.Memory.Usage != 8192 {
+		t.Errorf("expected unmatched container crio-a_b.scope using 8192 bytes, got %+v", stats)
+	}
+}
+
+func TestWatchDirs(t *testing.T) {
+	if in, err := newInotify(); err != nil {
+		t.Skip(err)
+	} else {
+		in.close()
+	}
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "system.slice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(base, "cgroup.controllers"), []byte("memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(Config{BasePath: base, ScanInterval: time.Hour, WatchDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// cgroupfs creates the files of a cgroup along with its directory,
+	// which a rename into place mimics
+	id := strings.Repeat("a", 64)
+	tmp := filepath.Join(t.TempDir(), "scope")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "memory.current"), []byte("4096\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(base, "system.slice", "docker-"+id+".scope")
+	if err := os.Rename(tmp, dir); err != nil {
+		t.Skip(err)
+	}
+	waitFor := func(found bool) {
+		t.Helper()
+		for i := 0; i < 200; i++ {
+			stats, err := c.ReadStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := stats[id]; ok == found {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected container found %v", found)
+	}
+	waitFor(true)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(false)
+}
+
+func TestSources(t *testing.T) {
+	for _, tt := range []struct{ old, new, want string }{
+		{"", "memory/a/memory.stat", "memory/a/memory.stat"},
+		// a nested cgroup never shadows the container's own
+		{"memory/a/memory.stat", "memory/a/nested/memory.stat", "memory/a/memory.stat"},
+		{"memory/a/nested/memory.stat", "memory/a/memory.stat", "memory/a/memory.stat"},
+		// otherwise the first in lexical order, whatever the walk order
+		{"pids/a/cgroup.procs", "memory/a/cgroup.procs", "memory/a/cgroup.procs"},
+		{"memory/a/cgroup.procs", "pids/a/cgroup.procs", "memory/a/cgroup.procs"},
+	} {
+		if got := preferPath(filepath.FromSlash(tt.old), filepath.FromSlash(tt.new)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("preferPath(%q, %q) = %q, want %q", tt.old, tt.new, got, tt.want)
+		}
+	}
+
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		scope := "/system.slice/docker-" + id + ".scope"
+		if stat.Sources["memory"] != "memory"+scope || stat.Sources["cpu"] != "cpu,cpuacct"+scope {
+			t.Errorf("%s: unexpected sources %v", id, stat.Sources)
+		}
+	}
+}
+
+func TestEffectiveLimits(t *testing.T) {
+	id := strings.Repeat("b", 64)
+	base := t.TempDir()
+	for p, content := range map[string]string{
+		"cgroup.controllers":                                   "cpu memory\n",
+		"limited.slice/memory.max":                             "1048576\n",
+		"limited.slice/cpu.max":                                "50000 100000\n",
+		"limited.slice/docker-" + id + ".scope/memory.max":     "max\n",
+		"limited.slice/docker-" + id + ".scope/cpu.max":        "max 100000\n",
+		"limited.slice/docker-" + id + ".scope/memory.current": "4096\n",
+	} {
+		p = filepath.Join(base, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := New(Config{BasePath: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := stats[id].Limits
+	if l.Memory != Unlimited || l.EffectiveMemory != 1<<20 {
+		t.Errorf("expected memory limit max, effectively 1MiB, got %d and %d", l.Memory, l.EffectiveMemory)
+	}
+	if l.CPUQuota != Unlimited || l.EffectiveCPUQuota != 50000 || l.EffectiveCPUPeriod != 100000 {
+		t.Errorf("expected CPU quota max, effectively 50000/100000, got %d and %d/%d", l.CPUQuota, l.EffectiveCPUQuota, l.EffectiveCPUPeriod)
+	}
+}
+
+func TestContainerError(t *testing.T) {
+	id := strings.Repeat("e", 64)
+	base := t.TempDir()
+	dir := filepath.Join(base, "system.slice", "docker-"+id+".scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// reading a directory fails with EISDIR, not ENOENT
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, memMaxFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("4096\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	c, err := New(Config{BasePath: base, Errors: errChan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var ce *ContainerError
+	select {
+	case err := <-errChan:
+		if !errors.As(err, &ce) || ce.ID != id {
+			t.Errorf("expected an error for container %s, got %v", id, err)
+		}
+	default:
+		t.Errorf("expected an error for container %s", id)
+	}
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, ok := stats[id]; !ok || cs.Memory.Usage != 4096 {
+		t.Errorf("expected the container to be collected, got %+v", stats)
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	id := strings.Repeat("0f", 32)
+	for _, tt := range []struct {
+		m       Matcher
+		path    string
+		id      string
+		matched bool
+	}{
+		{DockerMatcher, "/sys/fs/cgroup/system.slice/docker-" + id + ".scope/memory.stat", id, true},
+		{DockerMatcher, "/sys/fs/cgroup/memory/docker/" + id, id, true},
+		{DockerMatcher, "/sys/fs/cgroup/system.slice/docker.service", "", false},
+		{ContainerdMatcher, "/sys/fs/cgroup/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope", id, true},
+		{CRIOMatcher, "/sys/fs/cgroup/kubepods.slice/crio-" + id + ".scope/cpu.stat", id, true},
+		{CRIOMatcher, "/sys/fs/cgroup/kubepods.slice/crio-conmon-" + id + ".scope", "", false},
+		{PodmanMatcher, "/sys/fs/cgroup/machine.slice/libpod-" + id + ".scope/container", id, true},
+		{PodmanMatcher, "/sys/fs/cgroup/machine.slice/libpod-conmon-" + id + ".scope", "", false},
+		{LXCMatcher, "/sys/fs/cgroup/lxc.payload.web/memory.stat", "web", true},
+		{LXCMatcher, "/sys/fs/cgroup/memory/lxc/web", "web", true},
+		{LXCMatcher, "/sys/fs/cgroup/lxc.monitor.web", "", false},
+		{SystemdMatcher, "/sys/fs/cgroup/system.slice/nginx.service/memory.stat", "nginx.service", true},
+		{SystemdMatcher, "/sys/fs/cgroup/system.slice", "", false},
+	} {
+		got, runtime, ok := tt.m.Match(tt.path)
+		if ok != tt.matched || got != tt.id || ok && BuiltinMatchers[runtime] != tt.m {
+			t.Errorf("%s: expected %q, %v, got %q, %q, %v", tt.path, tt.id, tt.matched, got, runtime, ok)
+		}
+	}
+
+	c, err := New(Config{BasePath: "testdata/cgroup", Matchers: []Matcher{CRIOMatcher, DockerMatcher}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected a container, got %d", len(stats))
+	}
+	for id, stat := range stats {
+		if stat.Runtime != "docker" {
+			t.Errorf("%s: expected runtime docker, got %q", id, stat.Runtime)
+		}
+	}
+}
+
+func TestMemStatKeys(t *testing.T) {
+	var m MemStat
+	m.create("anon 100\nfile 200\nsock 5\n", false)
+	if m.RSS != 100 || m.Cache != 200 || len(m.Extra) != 1 || m.Extra["sock"] != 5 {
+		t.Errorf("cgroup v2: unexpected %+v", m)
+	}
+
+	v1 := "rss 1\ntotal_rss 2\nswap 3\ntotal_swap 4\nhierarchical_memory_limit 9223372036854771712\n"
+	m.create(v1, false)
+	if m.RSS != 1 || m.Swap != 3 || m.Extra["total_rss"] != 2 || m.Extra["hierarchical_memory_limit"] == 0 {
+		t.Errorf("cgroup v1: unexpected %+v", m)
+	}
+	m.create(v1, true)
+	if !m.Hierarchical || m.RSS != 2 || m.Swap != 4 || m.Extra["rss"] != 1 || m.Extra["swap"] != 3 {
+		t.Errorf("cgroup v1 hierarchical: unexpected %+v", m)
+	}
+}
+
+func TestParentStats(t *testing.T) {
+	id := strings.Repeat("c", 64)
+	base := t.TempDir()
+	for p, content := range map[string]string{
+		"cgroup.controllers":                                               "memory\n",
+		"kubepods.slice/memory.current":                                    "1000\n",
+		"kubepods.slice/pod1.slice/memory.current":                         "150\n",
+		"kubepods.slice/pod1.slice/memory.max":                             "4096\n",
+		"kubepods.slice/pod1.slice/docker-" + id + ".scope/memory.current": "100\n",
+	} {
+		p = filepath.Join(base, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := New(Config{BasePath: base, ParentStats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stats, err := c.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected the container and its parent only, got %d entries", len(stats))
+	}
+	if cs := stats[id]; cs.Parent || cs.ParentID != "pod1.slice" {
+		t.Errorf("expected container with parent pod1.slice, got %+v", cs)
+	}
+	pod, ok := stats["pod1.slice"]
+	if !ok || !pod.Parent || pod.Memory.Usage != 150 || pod.Limits.Memory != 4096 {
+		t.Errorf("expected parent pod1.slice using 150 bytes of 4096, got %+v", pod)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	docker := &Cstats{Runtime: "docker", Metadata: map[string]string{"app": "web"}}
+	docker.Memory.RSS = 2 << 30
+	crio := &Cstats{Runtime: "crio"}
+	crio.Memory.RSS = 512 << 20
+	stats := Cmap{"a": docker, "b": crio}
+
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		{"runtime==docker && mem.rss>1Gi", "a"},
+		{"runtime != docker", "b"},
+		{"memory_rss_bytes <= 512Mi", "b"},
+		{`label.app == "web" || id == b`, "ab"},
+		{"!(Memory.RSS > 1G)", "b"},
+		{"mem.rss > 10Gi", ""},
+	} {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %s", tt.expr, err)
+			continue
+		}
+		var ids []string
+		for id := range f.Apply(stats) {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if got := strings.Join(ids, ""); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.expr, tt.want, got)
+		}
+	}
+
+	for _, expr := range []string{"", "runtime", "runtime > docker", "mem.bogus > 1", "mem.rss > 1Xi", "(mem.rss > 1", "mem.rss > 1 &&"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	older, newer := Cmap{"old": nil}, Cmap{"new": nil}
+	done := make(chan struct{})
+	for _, tt := range []struct {
+		policy  BackpressurePolicy
+		receive bool
+		want    Cmap
+		dropped uint64
+	}{
+		{BackpressureDropNewest, false, older, 1},
+		{BackpressureDropOldest, false, newer, 1},
+		{BackpressureBlock, false, older, 1},
+		{BackpressureBlock, true, newer, 0},
+	} {
+		ch := make(chan Cmap, 1)
+		ch <- older
+		timeout := 10 * time.Millisecond
+		if tt.receive {
+			timeout = time.Minute
+			go func() { <-ch }()
+		}
+		before := atomic.LoadUint64(&statsHolder.watchDropped)
+		sendSnapshot(ch, newer, tt.policy, timeout, &statsHolder.watchDropped, done)
+		if got := <-ch; reflect.ValueOf(got).Pointer() != reflect.ValueOf(tt.want).Pointer() {
+			t.Errorf("policy %d: unexpected snapshot %v", tt.policy, got)
+		}
+		if d := atomic.LoadUint64(&statsHolder.watchDropped) - before; d != tt.dropped {
+			t.Errorf("policy %d: expected %d dropped, got %d", tt.policy, tt.dropped, d)
+		}
+	}
+	if LastCollection().WatchDropped < 3 {
+		t.Errorf("expected drops to be reported")
+	}
+}
+
+func TestPerCPU(t *testing.T) {
+	stats, err := ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, stat := range stats {
+		c := stat.CPU
+		if !reflect.DeepEqual(c.PerCPU, []uint64{200000000, 120000000, 0, 0}) {
+			t.Errorf("%s: unexpected per CPU usage %v", id, c.PerCPU)
+		}
+		if c.UsageNanoseconds != 320000000 || c.Total() != 320*time.Millisecond {
+			t.Errorf("%s: expected 320ms total, got %d and %s", id, c.UsageNanoseconds, c.Total())
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	MaxStaleness = time.Hour
+	defer func() { MaxStaleness = 0 }()
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := ReadView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := ReadView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := v1.IDs()
+	if len(ids) == 0 || !reflect.DeepEqual(ids, v2.IDs()) {
+		t.Fatalf("expected views of the same containers, got %v and %v", ids, v2.IDs())
+	}
+	id := ids[0]
+	cs1, _ := v1.Get(id)
+	cs2, _ := v2.Get(id)
+	if cs1 != cs2 {
+		t.Errorf("expected views to share the statistics")
+	}
+
+	if err := SetMetadata(id, "view", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMetadata(id, "view", "")
+	if _, ok := cs1.Metadata["view"]; ok {
+		t.Errorf("expected held view to be left unchanged")
+	}
+	v3, err := ReadView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs3, _ := v3.Get(id)
+	if cs3 == cs1 || cs3.Metadata["view"] != "yes" {
+		t.Errorf("expected new view to have the metadata, got %v", cs3.Metadata)
+	}
+
+	c, _ := v1.Clone(id)
+	c.setMetadata("clone", "yes")
+	if _, ok := cs1.Metadata["clone"]; ok {
+		t.Errorf("expected clone to be a copy")
+	}
+	if got := v1.Copy(); len(got) != len(v1.Map()) || got[id] == cs1 {
+		t.Errorf("expected copy of %d containers, got %d", len(v1.Map()), len(got))
+	}
+}
+
+func TestViewCopyOnWrite(t *testing.T) {
+	MaxStaleness = time.Hour
+	defer func() { MaxStaleness = 0 }()
+	addTestContainer(t, "viewa", map[string]string{pidsCurrentFile: "1\n"})
+	addTestContainer(t, "viewb", map[string]string{pidsCurrentFile: "1\n"})
+	if _, err := ReadStats(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := ReadView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"viewa", "viewb"} {
+		if err := SetMetadata(id, "view", "yes"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetMetadata(id, "view", "again"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.Range(func(id string, cs *Cstats) bool {
+		if _, ok := cs.Metadata["view"]; ok {
+			t.Errorf("%s: expected held view to be left unchanged", id)
+		}
+		return true
+	})
+
+	// a View taken after the first copies must be copied from again
+	v, err = ReadView()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"viewa", "viewb"} {
+		if err := SetMetadata(id, "view", ""); err != nil {
+			t.Fatal(err)
+		}
+		if cs, _ := v.Get(id); cs.Metadata["view"] != "again" {
+			t.Errorf("%s: expected held view to be left unchanged, got %v", id, cs.Metadata)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// sample is the part of each collection retained in the history
+type sample struct {
+	time     time.Time
+	cpuPct   float64
+	rss      float64
+	readBps  float64
+	writeBps float64
+}
+
+// Summary of a container's statistics over a window of its history
+type Summary struct {
+	// number of samples in the window
+	Samples int
+	// Utilization.CPUPct
+	CPUPct SummaryStat
+	// Memory.RSS in bytes
+	RSS SummaryStat
+	// block device bytes read and written per second, summed over devices
+	ReadBps  SummaryStat
+	WriteBps SummaryStat
+}
+
+// Distribution of a value over a window
+type SummaryStat struct {
+	Min  float64
+	Max  float64
+	Mean float64
+	P50  float64
+	P95  float64
+	P99  float64
+}
+
+// record adds cur, collected at now, to the history of container id. The
+// holder must be locked.
+func (h *holder) record(cfg *Config, id string, cur, prev *Cstats, now time.Time) {
+	retention := cur.historyRetention(cfg.HistoryRetention)
+	if retention <= 0 {
+		return
+	}
+	if h.history == nil {
+		h.history = make(map[string][]sample)
+	}
+	s := sample{
+		time:   now,
+		cpuPct: cur.Utilization.CPUPct,
+		rss:    float64(cur.Memory.RSS),
+	}
+	samples := h.history[id]
+	if prev != nil && cur.BlkIO.Bytes.Timestamp.Equal(prev.BlkIO.Bytes.Timestamp) && len(samples) > 0 {
+		// not read this time, see SubsystemIntervals
+		s.readBps = samples[len(samples)-1].readBps
+		s.writeBps = samples[len(samples)-1].writeBps
+	} else if prev != nil {
+		rates := newDeviceRates(&cur.BlkIO.Bytes, &prev.BlkIO.Bytes)
+		for key := range rates.cur {
+			r := rates.rate(key)
+			s.readBps += r.Read
+			s.writeBps += r.Write
+		}
+	}
+
+	cutoff := s.time.Add(-retention)
+	i := 0
+	for i < len(samples) && samples[i].time.Before(cutoff) {
+		i++
+	}
+	h.history[id] = append(samples[i:], s)
+	if h.anomalies != nil {
+		h.anomalies.check(id, h.history[id])
+	}
+}
+
+// Summarize returns the minimum, maximum, mean and percentiles of container
+// id's CPU, RSS and I/O over the last window of its history. It requires
+// HistoryRetention to be at least window.
+func Summarize(id string, window time.Duration) (Summary, error) {
+	return statsHolder.summary(id, window)
+}
+
+func (h *holder) summary(id string, window time.Duration) (Summary, error) {
+	h.Lock()
+	defer h.Unlock()
+	samples, ok := h.history[id]
+	if !ok {
+		return Summary{}, fmt.Errorf("no history for container %s", id)
+	}
+	return summarizeSamples(samples, time.Now().Add(-window)), nil
+}
+
+// summarizeSamples summarizes the samples taken from cutoff on.
+func summarizeSamples(samples []sample, cutoff time.Time) Summary {
+	var cpu, rss, read, write []float64
+	for _, s := range samples {
+		if s.time.Before(cutoff) {
+			continue
+		}
+		cpu = append(cpu, s.cpuPct)
+		rss = append(rss, s.rss)
+		read = append(read, s.readBps)
+		write = append(write, s.writeBps)
+	}
+	return Summary{
+		Samples:  len(cpu),
+		CPUPct:   summarize(cpu),
+		RSS:      summarize(rss),
+		ReadBps:  summarize(read),
+		WriteBps: summarize(write),
+	}
+}
+
+func summarize(values []float64) SummaryStat {
+	if len(values) == 0 {
+		return SummaryStat{}
+	}
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return SummaryStat{
+		Min:  values[0],
+		Max:  values[len(values)-1],
+		Mean: sum / float64(len(values)),
+		P50:  percentile(values, 50),
+		P95:  percentile(values, 95),
+		P99:  percentile(values, 99),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build linux
+
+package gocstat
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	inModify    = syscall.IN_MODIFY
+	inIgnored   = syscall.IN_IGNORED
+	inCreate    = syscall.IN_CREATE
+	inDelete    = syscall.IN_DELETE
+	inMovedTo   = syscall.IN_MOVED_TO
+	inMovedFrom = syscall.IN_MOVED_FROM
+	inIsDir     = syscall.IN_ISDIR
+	inOnlyDir   = syscall.IN_ONLYDIR
+)
+
+// inotify is a minimal wrapper around the inotify(7) API.
+type inotify struct {
+	fd int
+	// fd, non-blocking, so that closing it wakes read
+	f *os.File
+	sync.Mutex
+	// watch descriptor -> path
+	paths map[int]string
+	// path -> watch descriptor
+	wds map[string]int
+	// see close
+	closed bool
+}
+
+type inotifyEvent struct {
+	path string
+	name string
+	mask uint32
+}
+
+func newInotify() (*inotify, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &inotify{
+		fd:    fd,
+		f:     os.NewFile(uintptr(fd), "inotify"),
+		paths: make(map[int]string),
+		wds:   make(map[string]int),
+	}, nil
+}
+
+// add watches path for events in mask. Paths already watched are ignored.
+func (in *inotify) add(path string, mask uint32) error {
+	in.Lock()
+	defer in.Unlock()
+	if in.closed {
+		return os.ErrClosed
+	}
+	if _, ok := in.wds[path]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(in.fd, path, mask)
+	if err != nil {
+		return err
+	}
+	in.paths[wd] = path
+	in.wds[path] = wd
+	return nil
+}
+
+// read blocks until at least one event is available, or fails once the
+// instance is closed.
+func (in *inotify) read() ([]inotifyEvent, error) {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	n, err := in.f.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+
+	in.Lock()
+	defer in.Unlock()
+	var events []inotifyEvent
+	for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		off += syscall.SizeofInotifyEvent
+		ev := inotifyEvent{path: in.paths[int(raw.Wd)], mask: raw.Mask}
+		if raw.Len > 0 {
+			ev.name = strings.TrimRight(string(buf[off:off+int(raw.Len)]), "\x00")
+			off += int(raw.Len)
+		}
+		// the kernel removes the watch once the file is gone
+		if raw.Mask&inIgnored != 0 {
+			delete(in.wds, ev.path)
+			delete(in.paths, int(raw.Wd))
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// close closes the inotify instance, removing every watch and waking a
+// pending read, which fails.
+func (in *inotify) close() {
+	in.Lock()
+	defer in.Unlock()
+	if in.closed {
+		return
+	}
+	in.closed = true
+	in.f.Close()
+}
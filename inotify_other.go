@@ -0,0 +1,56 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build !linux
+
+package gocstat
+
+import (
+	"fmt"
+)
+
+const (
+	inModify    = 0
+	inIgnored   = 0
+	inCreate    = 0
+	inDelete    = 0
+	inMovedTo   = 0
+	inMovedFrom = 0
+	inIsDir     = 0
+	inOnlyDir   = 0
+)
+
+type inotify struct{}
+
+type inotifyEvent struct {
+	path string
+	name string
+	mask uint32
+}
+
+func newInotify() (*inotify, error) {
+	return nil, fmt.Errorf("inotify is only supported on linux")
+}
+
+func (in *inotify) add(path string, mask uint32) error {
+	return fmt.Errorf("inotify is only supported on linux")
+}
+
+func (in *inotify) read() ([]inotifyEvent, error) {
+	return nil, fmt.Errorf("inotify is only supported on linux")
+}
+
+func (in *inotify) close() {}
@@ -0,0 +1,72 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package enrich holds the code shared by the packages labelling containers
+// from an orchestrator, such as docker and ecs.
+package enrich
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Run calls enrich immediately and then every interval until the returned
+// stop function is called. errChan is optional and used for reporting
+// enrich errors, it is never closed by Run.
+func Run(interval time.Duration, enrich func() error, errChan chan<- error) func() {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := enrich(); err != nil && errChan != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Get decodes the JSON document at path of the API at url into v, using
+// client, or http.DefaultClient if nil. service names the API in errors.
+func Get(client *http.Client, service, url, path string, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s for %s", service, resp.Status, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
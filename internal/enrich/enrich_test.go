@@ -0,0 +1,61 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package enrich
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	errChan := make(chan error, 1)
+	calls := make(chan struct{}, 10)
+	stop := Run(time.Millisecond, func() error {
+		calls <- struct{}{}
+		return errors.New("unreachable")
+	}, errChan)
+	<-calls
+	<-calls
+	stop()
+	stop()
+	if err := <-errChan; err == nil || err.Error() != "unreachable" {
+		t.Errorf("expected the enrich error to be reported, got %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/ok" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"Name":"a"}`))
+	}))
+	defer srv.Close()
+
+	var v struct{ Name string }
+	if err := Get(nil, "test", srv.URL, "/v1/ok", &v); err != nil || v.Name != "a" {
+		t.Errorf("expected Name a, got %q, err %v", v.Name, err)
+	}
+	err := Get(srv.Client(), "test", srv.URL, "/v1/missing", &v)
+	if err == nil || err.Error() != "test returned 404 Not Found for /v1/missing" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
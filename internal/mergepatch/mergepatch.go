@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package mergepatch computes and applies JSON merge patches (RFC 7386)
+// between JSON documents decoded as map[string]interface{}.
+//
+// Documents should be decoded with json.Decoder.UseNumber so that large
+// integers survive the round trip.
+package mergepatch
+
+import (
+	"reflect"
+)
+
+// Diff returns the merge patch which turns from into to, or nil if they are
+// equal. Objects are compared recursively, any other changed value,
+// including arrays, is replaced whole. Keys missing from to are set to nil.
+func Diff(from, to map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for k, v := range to {
+		old, ok := from[k]
+		if !ok {
+			patch[k] = v
+			continue
+		}
+		oldObj, ok1 := old.(map[string]interface{})
+		obj, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			if d := Diff(oldObj, obj); d != nil {
+				patch[k] = d
+			}
+			continue
+		}
+		if !reflect.DeepEqual(old, v) {
+			patch[k] = v
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return patch
+}
+
+// Apply modifies doc in place by applying patch.
+func Apply(doc, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			doc[k] = v
+			continue
+		}
+		d, ok := doc[k].(map[string]interface{})
+		if !ok {
+			d = make(map[string]interface{})
+			doc[k] = d
+		}
+		Apply(d, p)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	dec := json.NewDecoder(bytes.NewBufferString(s))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDiffApply(t *testing.T) {
+	from := decode(t, `{"a":{"Memory":{"RSS":1,"Cache":18446744073709551615},"Devices":[1,2]},"b":{"CPU":1}}`)
+	to := decode(t, `{"a":{"Memory":{"RSS":2,"Cache":18446744073709551615},"Devices":[1,2]},"c":{"CPU":3}}`)
+
+	patch := Diff(from, to)
+	expected := decode(t, `{"a":{"Memory":{"RSS":2}},"b":null,"c":{"CPU":3}}`)
+	if !reflect.DeepEqual(patch, expected) {
+		t.Errorf("Diff: expected %v, got %v", expected, patch)
+	}
+
+	Apply(from, patch)
+	if !reflect.DeepEqual(from, to) {
+		t.Errorf("Apply: expected %v, got %v", to, from)
+	}
+	if Diff(from, to) != nil {
+		t.Errorf("expected no difference between equal documents")
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cgroup v1
+	memLimitFile          = "memory.limit_in_bytes"
+	memSoftLimitFile      = "memory.soft_limit_in_bytes"
+	memSwLimitFile        = "memory.memsw.limit_in_bytes"
+	cPUQuotaFile          = "cpu.cfs_quota_us"
+	cPUPeriodFile         = "cpu.cfs_period_us"
+	cPUSharesFile         = "cpu.shares"
+	blkIOReadBpsFile      = "blkio.throttle.read_bps_device"
+	blkIOWriteBpsFile     = "blkio.throttle.write_bps_device"
+	blkIOReadIOPSFile     = "blkio.throttle.read_iops_device"
+	blkIOWriteIOPSFile    = "blkio.throttle.write_iops_device"
+	blkIOWeightFile       = "blkio.weight"
+	blkIOWeightDeviceFile = "blkio.weight_device"
+	// cgroup v2
+	memMaxFile      = "memory.max"
+	memHighFile     = "memory.high"
+	memLowFile      = "memory.low"
+	memSwapMaxFile  = "memory.swap.max"
+	cPUMaxFile      = "cpu.max"
+	cPUWeightFile   = "cpu.weight"
+	cPUNiceFile     = "cpu.weight.nice"
+	cPUIdleFile     = "cpu.idle"
+	iOMaxFile       = "io.max"
+	iOWeightFile    = "io.weight"
+	iOBFQWeightFile = "io.bfq.weight"
+	iOLatencyFile   = "io.latency"
+	// both
+	pidsMaxFile = "pids.max"
+)
+
+// limitFiles maps the base name of each file holding a limit to its parser.
+// Limits rarely change so they are read when BasePath is scanned rather
+// than on every ReadStats.
+var limitFiles = map[string]func(cs *Cstats, content string){
+	memLimitFile:       func(cs *Cstats, content string) { cs.Limits.Memory = parseMemLimit(content) },
+	memMaxFile:         func(cs *Cstats, content string) { cs.Limits.Memory = parseLimit(content) },
+	memSoftLimitFile:   func(cs *Cstats, content string) { cs.Limits.MemorySoft = parseMemLimit(content) },
+	memLowFile:         func(cs *Cstats, content string) { cs.Limits.MemorySoft = parseLimit(content) },
+	memHighFile:        func(cs *Cstats, content string) { cs.Limits.MemoryHigh = parseLimit(content) },
+	memSwLimitFile:     func(cs *Cstats, content string) { cs.Limits.MemSwap = parseMemLimit(content) },
+	memSwapMaxFile:     func(cs *Cstats, content string) { cs.Limits.Swap = parseLimit(content) },
+	cPUQuotaFile:       func(cs *Cstats, content string) { cs.Limits.CPUQuota = parseQuota(content) },
+	cPUPeriodFile:      func(cs *Cstats, content string) { cs.Limits.CPUPeriod = parseUint(content) },
+	cPUMaxFile:         func(cs *Cstats, content string) { cs.Limits.createCPUMax(content) },
+	cPUSharesFile:      func(cs *Cstats, content string) { cs.Limits.CPUShares = parseUint(content) },
+	cPUWeightFile:      func(cs *Cstats, content string) { cs.Limits.CPUWeight = parseUint(content) },
+	cPUNiceFile:        func(cs *Cstats, content string) { cs.Limits.CPUNice, _ = strconv.Atoi(strings.TrimSpace(content)) },
+	cPUIdleFile:        func(cs *Cstats, content string) { cs.Limits.CPUIdle = parseUint(content) == 1 },
+	pidsMaxFile:        func(cs *Cstats, content string) { cs.Limits.Pids = parseLimit(content) },
+	iOMaxFile:          func(cs *Cstats, content string) { cs.Limits.createIOMax(content) },
+	blkIOReadBpsFile:   func(cs *Cstats, content string) { cs.Limits.createThrottle(content, readBps) },
+	blkIOWriteBpsFile:  func(cs *Cstats, content string) { cs.Limits.createThrottle(content, writeBps) },
+	blkIOReadIOPSFile:  func(cs *Cstats, content string) { cs.Limits.createThrottle(content, readIOPS) },
+	blkIOWriteIOPSFile: func(cs *Cstats, content string) { cs.Limits.createThrottle(content, writeIOPS) },
+	blkIOWeightFile:    func(cs *Cstats, content string) { cs.Limits.IOWeight = parseUint(content) },
+	blkIOWeightDeviceFile: func(cs *Cstats, content string) {
+		_, cs.Limits.IOWeightDevices = parseWeights(content)
+	},
+	iOWeightFile: func(cs *Cstats, content string) {
+		cs.Limits.IOWeight, cs.Limits.IOWeightDevices = parseWeights(content)
+	},
+	iOLatencyFile: func(cs *Cstats, content string) { cs.Limits.createIOLatency(content) },
+	iOBFQWeightFile: func(cs *Cstats, content string) {
+		cs.Limits.BFQWeight, cs.Limits.BFQWeightDevices = parseWeights(content)
+	},
+}
+
+// Resource limits configured for a container. Limits which are not set
+// are reported as Unlimited, limits not supported by the kernel or cgroup
+// version in use are left at zero.
+type Limits struct {
+	// memory limit in bytes (memory.limit_in_bytes, memory.max)
+	Memory uint64
+	// memory soft limit in bytes (memory.soft_limit_in_bytes, memory.low)
+	MemorySoft uint64
+	// memory throttling limit in bytes, cgroup v2 only (memory.high)
+	MemoryHigh uint64
+	// memory plus swap limit in bytes, cgroup v1 only
+	MemSwap uint64
+	// swap limit in bytes, cgroup v2 only
+	Swap uint64
+
+	// CPU time in microseconds allowed per CPUPeriod
+	CPUQuota uint64
+	// CPU quota period in microseconds
+	CPUPeriod uint64
+	// relative CPU weight, cgroup v1 only (cpu.shares)
+	CPUShares uint64
+	// relative CPU weight, cgroup v2 only (cpu.weight)
+	CPUWeight uint64
+	// CPUWeight as a nice value from -20 to 19, cgroup v2 only
+	// (cpu.weight.nice)
+	CPUNice int
+	// the cgroup's tasks are scheduled as SCHED_IDLE, only running when
+	// nothing else wants the CPU, cgroup v2 only (cpu.idle). Typical of
+	// best-effort background containers
+	CPUIdle bool
+
+	// maximum number of tasks
+	Pids uint64
+
+	// per device I/O throttling
+	IO []IOLimit
+
+	// relative I/O weight (blkio.weight, io.weight)
+	IOWeight uint64
+	// per device overrides of IOWeight (blkio.weight_device, io.weight)
+	IOWeightDevices []DeviceWeight
+	// relative I/O weight used by the BFQ scheduler, cgroup v2 only
+	BFQWeight uint64
+	// per device overrides of BFQWeight
+	BFQWeightDevices []DeviceWeight
+
+	// per device io.latency targets, cgroup v2 only
+	IOLatency []IOLatencyTarget
+
+	// the tightest of Memory and of the memory limits of the ancestor
+	// cgroups, such as a systemd slice, which constrain the container even
+	// when its own limit is Unlimited
+	EffectiveMemory uint64
+	// likewise, the CPUQuota and CPUPeriod of the container or ancestor
+	// allowing the fewest CPUs
+	EffectiveCPUQuota  uint64
+	EffectiveCPUPeriod uint64
+
+	Timestamp time.Time
+}
+
+// Block device I/O throttling limits
+type IOLimit struct {
+	// block device major number
+	Major uint64
+	// block device minor number
+	Minor uint64
+	// bytes per second
+	ReadBps  uint64
+	WriteBps uint64
+	// operations per second
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// Relative I/O weight for a single block device
+type DeviceWeight struct {
+	// block device major number
+	Major uint64
+	// block device minor number
+	Minor  uint64
+	Weight uint64
+}
+
+// io.latency target for a single block device
+type IOLatencyTarget struct {
+	// block device major number
+	Major uint64
+	// block device minor number
+	Minor uint64
+	// target latency in microseconds
+	Target uint64
+}
+
+type ioLimitField int
+
+const (
+	readBps ioLimitField = iota
+	writeBps
+	readIOPS
+	writeIOPS
+)
+
+// readLimits reads and parses every limit file found for the container.
+// Files which have disappeared are skipped; the container will be
+// pruned by the next ReadStats.
+func (c *Cstats) readLimits() error {
+	c.Limits = Limits{}
+	cfg := c.config()
+	for name, path := range c.limitFiles {
+		b, err := readFile(path)
+		if err != nil {
+			if os.IsNotExist(err) || unreadable(cfg, err) {
+				continue
+			}
+			return err
+		}
+		limitFiles[name](c, string(b))
+	}
+	c.readEffectiveLimits()
+	c.Limits.Timestamp = time.Now()
+	return nil
+}
+
+// readEffectiveLimits sets the Effective limits from c's own and those of
+// its ancestors, which are read up to the first without the limit file,
+// the root cgroup on cgroup v2 and the parent of the hierarchy root on v1.
+func (c *Cstats) readEffectiveLimits() {
+	l := &c.Limits
+	l.EffectiveMemory = l.Memory
+	for name, parse := range map[string]func(string) uint64{memMaxFile: parseLimit, memLimitFile: parseMemLimit} {
+		p, ok := c.limitFiles[name]
+		if !ok {
+			continue
+		}
+		for _, dir := range ancestors(filepath.Dir(p)) {
+			b, err := readFile(filepath.Join(dir, name))
+			if err != nil {
+				break
+			}
+			if v := parse(string(b)); v < l.EffectiveMemory {
+				l.EffectiveMemory = v
+			}
+		}
+	}
+
+	l.EffectiveCPUQuota, l.EffectiveCPUPeriod = l.CPUQuota, l.CPUPeriod
+	var dir string
+	if p, ok := c.limitFiles[cPUMaxFile]; ok {
+		dir = filepath.Dir(p)
+	} else if p, ok := c.limitFiles[cPUQuotaFile]; ok {
+		dir = filepath.Dir(p)
+	} else {
+		return
+	}
+	for _, dir := range ancestors(dir) {
+		quota, period, ok := readCPULimit(dir)
+		if !ok {
+			break
+		}
+		if cpuLimitBelow(quota, period, l.EffectiveCPUQuota, l.EffectiveCPUPeriod) {
+			l.EffectiveCPUQuota, l.EffectiveCPUPeriod = quota, period
+		}
+	}
+}
+
+// ancestors returns the parent directories of dir, nearest first.
+func ancestors(dir string) []string {
+	var dirs []string
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dirs
+		}
+		dirs = append(dirs, parent)
+		dir = parent
+	}
+}
+
+// readCPULimit reads the CPU quota and period of the cgroup in dir, from
+// cpu.max or cpu.cfs_quota_us and cpu.cfs_period_us.
+func readCPULimit(dir string) (quota, period uint64, ok bool) {
+	if b, err := readFile(filepath.Join(dir, cPUMaxFile)); err == nil {
+		var l Limits
+		l.createCPUMax(string(b))
+		return l.CPUQuota, l.CPUPeriod, true
+	}
+	b, err := readFile(filepath.Join(dir, cPUQuotaFile))
+	if err != nil {
+		return 0, 0, false
+	}
+	quota = parseQuota(string(b))
+	if b, err = readFile(filepath.Join(dir, cPUPeriodFile)); err == nil {
+		period = parseUint(string(b))
+	}
+	return quota, period, true
+}
+
+// cpuLimitBelow reports whether quota per period allows fewer CPUs than
+// quota2 per period2. Unlimited quotas, and those without a period, allow
+// any number.
+func cpuLimitBelow(quota, period, quota2, period2 uint64) bool {
+	if quota == Unlimited || quota == 0 || period == 0 {
+		return false
+	}
+	if quota2 == Unlimited || quota2 == 0 || period2 == 0 {
+		return true
+	}
+	return float64(quota)/float64(period) < float64(quota2)/float64(period2)
+}
+
+// parseMemLimit parses a cgroup v1 memory limit. Unset limits are reported
+// as the largest page aligned value the kernel can hold, which depends on
+// the page size, so anything that large is treated as Unlimited.
+func parseMemLimit(content string) uint64 {
+	v := parseUint(content)
+	if v >= 1<<62 {
+		return Unlimited
+	}
+	return v
+}
+
+// parseQuota parses cpu.cfs_quota_us, where -1 means no limit.
+func parseQuota(content string) uint64 {
+	v, err := strconv.ParseInt(strings.TrimSpace(content), 10, 64)
+	if err != nil || v < 0 {
+		return Unlimited
+	}
+	return uint64(v)
+}
+
+// parseDevice parses a "major:minor" block device string.
+func parseDevice(s string) (major, minor uint64, ok bool) {
+	device := strings.Split(s, ":")
+	if len(device) != 2 {
+		return 0, 0, false
+	}
+	var err error
+	if major, err = strconv.ParseUint(device[0], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if minor, err = strconv.ParseUint(device[1], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// device returns the IOLimit for major:minor, adding it if necessary.
+func (l *Limits) device(major, minor uint64) *IOLimit {
+	for i := range l.IO {
+		if l.IO[i].Major == major && l.IO[i].Minor == minor {
+			return &l.IO[i]
+		}
+	}
+	l.IO = append(l.IO, IOLimit{
+		Major:     major,
+		Minor:     minor,
+		ReadBps:   Unlimited,
+		WriteBps:  Unlimited,
+		ReadIOPS:  Unlimited,
+		WriteIOPS: Unlimited,
+	})
+	return &l.IO[len(l.IO)-1]
+}
+
+func (d *IOLimit) set(field ioLimitField, v uint64) {
+	switch field {
+	case readBps:
+		d.ReadBps = v
+	case writeBps:
+		d.WriteBps = v
+	case readIOPS:
+		d.ReadIOPS = v
+	case writeIOPS:
+		d.WriteIOPS = v
+	}
+}
+
+// createCPUMax parses cpu.max, formatted as "$MAX $PERIOD".
+func (l *Limits) createCPUMax(content string) {
+	fields := strings.Fields(content)
+	if len(fields) < 1 {
+		return
+	}
+	l.CPUQuota = parseLimit(fields[0])
+	if len(fields) > 1 {
+		l.CPUPeriod = parseUint(fields[1])
+	}
+}
+
+// createThrottle parses a cgroup v1 blkio.throttle.*_device file, made up
+// of "major:minor value" lines.
+func (l *Limits) createThrottle(content string, field ioLimitField) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok {
+			continue
+		}
+		l.device(major, minor).set(field, parseUint(fields[1]))
+	}
+}
+
+// createIOMax parses io.max, made up of
+// "major:minor rbps=N wbps=N riops=N wiops=N" lines.
+func (l *Limits) createIOMax(content string) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok {
+			continue
+		}
+		d := l.device(major, minor)
+		for k, v := range parseEqualValues(fields[1:]) {
+			switch k {
+			case "rbps":
+				d.set(readBps, v)
+			case "wbps":
+				d.set(writeBps, v)
+			case "riops":
+				d.set(readIOPS, v)
+			case "wiops":
+				d.set(writeIOPS, v)
+			}
+		}
+	}
+}
+
+// createIOLatency parses io.latency, made up of "major:minor target=N" lines.
+func (l *Limits) createIOLatency(content string) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok {
+			continue
+		}
+		kv := parseEqualValues(fields[1:])
+		if target, ok := kv["target"]; ok {
+			l.IOLatency = append(l.IOLatency, IOLatencyTarget{Major: major, Minor: minor, Target: target})
+		}
+	}
+}
+
+// parseWeights parses an I/O weight file. Lines are either a weight on its
+// own or prefixed with "default", giving the default weight, or prefixed with
+// "major:minor", giving a per device weight.
+func parseWeights(content string) (def uint64, devices []DeviceWeight) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			def = parseUint(fields[0])
+		case 2:
+			if fields[0] == "default" {
+				def = parseUint(fields[1])
+				continue
+			}
+			major, minor, ok := parseDevice(fields[0])
+			if !ok {
+				continue
+			}
+			devices = append(devices, DeviceWeight{Major: major, Minor: minor, Weight: parseUint(fields[1])})
+		}
+	}
+	return def, devices
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// systemd-journald native protocol socket
+var journalSocket = "/run/systemd/journal/socket"
+
+type journal struct {
+	conn *net.UnixConn
+}
+
+// NewJournal returns a Sink logging to the systemd journal, with each
+// value in a GOCSTAT_ prefixed field, e.g. GOCSTAT_CPU_PCT.
+func NewJournal() (*Sink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{w: &journal{conn: conn}}, nil
+}
+
+func (j *journal) write(e entry) error {
+	_, err := j.conn.Write(encodeJournal(e))
+	return err
+}
+
+func (j *journal) Close() error {
+	return j.conn.Close()
+}
+
+// encodeJournal encodes e in the journal's native format, one KEY=value
+// line per field. Values containing a newline are length prefixed.
+func encodeJournal(e entry) []byte {
+	var b bytes.Buffer
+	field := func(k, v string) {
+		if strings.IndexByte(v, '\n') < 0 {
+			b.WriteString(k + "=" + v + "\n")
+			return
+		}
+		b.WriteString(k + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(v)))
+		b.WriteString(v + "\n")
+	}
+	field("MESSAGE", e.message)
+	field("PRIORITY", strconv.Itoa(int(e.level)))
+	field("SYSLOG_IDENTIFIER", "gocstat")
+	for _, f := range e.fields {
+		field("GOCSTAT_"+f[0], f[1])
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package logsink provides gocstat sinks which log per container summaries,
+// or only containers exceeding thresholds, to the systemd journal or to
+// syslog, for alerting driven by logs.
+package logsink
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/porjo/gocstat"
+)
+
+// level of an entry, using the syslog severities
+type level int
+
+const (
+	levelWarning level = 4
+	levelInfo    level = 6
+)
+
+type entry struct {
+	level   level
+	message string
+	// field names are upper case, as required by the journal
+	fields [][2]string
+}
+
+type writer interface {
+	write(e entry) error
+	Close() error
+}
+
+// Sink logs the containers it is sent. Register it with gocstat.AddSink.
+type Sink struct {
+	// Minimum time between summaries of a container, zero logs one for
+	// every collection. Containers in breach are always logged
+	Interval time.Duration
+	// Utilization percentages above which a container is in breach, zero
+	// disables the check
+	CPUPct    float64
+	MemoryPct float64
+	PidsPct   float64
+	// Log only containers in breach
+	BreachesOnly bool
+
+	w writer
+	// container ID -> time of its last summary
+	last map[string]time.Time
+}
+
+// Send logs stats, implementing gocstat.Sink.
+func (s *Sink) Send(stats gocstat.Cmap) error {
+	if s.last == nil {
+		s.last = make(map[string]time.Time)
+	}
+	for id := range s.last {
+		if _, ok := stats[id]; !ok {
+			delete(s.last, id)
+		}
+	}
+
+	ids := make([]string, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	for _, id := range ids {
+		cs := stats[id]
+		breaches := s.breaches(cs)
+		if len(breaches) == 0 {
+			if s.BreachesOnly || (s.Interval > 0 && now.Sub(s.last[id]) < s.Interval) {
+				continue
+			}
+		}
+		if err := s.w.write(summary(id, cs, breaches)); err != nil {
+			return err
+		}
+		s.last[id] = now
+	}
+	return nil
+}
+
+// Close closes the connection to the log.
+func (s *Sink) Close() error {
+	return s.w.Close()
+}
+
+func (s *Sink) breaches(cs *gocstat.Cstats) []string {
+	var b []string
+	u := cs.Utilization
+	if s.CPUPct > 0 && u.CPUPct > s.CPUPct {
+		b = append(b, "cpu_pct")
+	}
+	if s.MemoryPct > 0 && u.MemoryPct > s.MemoryPct {
+		b = append(b, "memory_pct")
+	}
+	if s.PidsPct > 0 && u.PidsPct > s.PidsPct {
+		b = append(b, "pids_pct")
+	}
+	return b
+}
+
+func summary(id string, cs *gocstat.Cstats, breaches []string) entry {
+	u := cs.Utilization
+	e := entry{
+		level: levelInfo,
+		message: fmt.Sprintf("container %s cpu %.1f%% memory %d bytes (%.1f%%) pids %d",
+			id, u.CPUPct, cs.Memory.Usage, u.MemoryPct, cs.Pids.Current),
+		fields: [][2]string{
+			{"ID", id},
+			{"CPU_PCT", strconv.FormatFloat(u.CPUPct, 'f', 1, 64)},
+			{"MEMORY_USAGE", strconv.FormatUint(cs.Memory.Usage, 10)},
+			{"MEMORY_RSS", strconv.FormatUint(cs.Memory.RSS, 10)},
+			{"MEMORY_PCT", strconv.FormatFloat(u.MemoryPct, 'f', 1, 64)},
+			{"PIDS_CURRENT", strconv.FormatUint(cs.Pids.Current, 10)},
+			{"PIDS_PCT", strconv.FormatFloat(u.PidsPct, 'f', 1, 64)},
+			{"INACTIVE", strconv.FormatBool(cs.Inactive)},
+		},
+	}
+	if len(breaches) > 0 {
+		e.level = levelWarning
+		e.message += " exceeds " + strings.Join(breaches, ", ")
+		e.fields = append(e.fields, [2]string{"BREACH", strings.Join(breaches, ",")})
+	}
+	derived := make([]string, 0, len(cs.Derived))
+	for k := range cs.Derived {
+		derived = append(derived, k)
+	}
+	sort.Strings(derived)
+	for _, k := range derived {
+		e.fields = append(e.fields, [2]string{"DERIVED_" + fieldName(k), strconv.FormatFloat(cs.Derived[k], 'g', -1, 64)})
+	}
+	keys := make([]string, 0, len(cs.Metadata))
+	for k := range cs.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.fields = append(e.fields, [2]string{"LABEL_" + fieldName(k), cs.Metadata[k]})
+	}
+	return e
+}
+
+// fieldName converts s to a valid journal field name: upper case letters,
+// digits and underscores.
+func fieldName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
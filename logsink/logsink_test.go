@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package logsink
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/porjo/gocstat"
+)
+
+type fakeWriter struct {
+	entries []entry
+}
+
+func (f *fakeWriter) write(e entry) error {
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func (f *fakeWriter) Close() error { return nil }
+
+func testStats() gocstat.Cmap {
+	hot := &gocstat.Cstats{Metadata: map[string]string{"app.name": "web"}}
+	hot.Utilization.CPUPct = 95
+	return gocstat.Cmap{"hot": hot, "cold": {}}
+}
+
+func TestSend(t *testing.T) {
+	f := &fakeWriter{}
+	s := &Sink{Interval: time.Hour, CPUPct: 90, w: f}
+	for i := 0; i < 2; i++ {
+		if err := s.Send(testStats()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cold is summarized once per Interval, hot every time it is in breach
+	if len(f.entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(f.entries))
+	}
+	e := f.entries[1]
+	if e.level != levelWarning || !strings.HasSuffix(e.message, "exceeds cpu_pct") {
+		t.Errorf("expected breach warning, got %+v", e)
+	}
+	if !hasField(e, "LABEL_APP_NAME", "web") || !hasField(e, "BREACH", "cpu_pct") {
+		t.Errorf("missing label or breach field in %+v", e.fields)
+	}
+
+	f.entries = nil
+	s.BreachesOnly = true
+	if err := s.Send(testStats()); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.entries) != 1 || !hasField(f.entries[0], "ID", "hot") {
+		t.Errorf("expected only hot to be logged, got %+v", f.entries)
+	}
+}
+
+func hasField(e entry, k, v string) bool {
+	for _, f := range e.fields {
+		if f[0] == k && f[1] == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJournal(t *testing.T) {
+	journalSocket = filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	stats := testStats()
+	stats["hot"].Metadata["note"] = "two\nlines"
+	if err := s.Send(stats); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	for _, want := range []string{"MESSAGE=container cold ", "PRIORITY=6\n", "GOCSTAT_ID=cold\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in %q", want, msg)
+		}
+	}
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = string(buf[:n])
+	if !strings.Contains(msg, "GOCSTAT_LABEL_NOTE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n") {
+		t.Errorf("expected length prefixed multi-line value in %q", msg)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"log/syslog"
+	"strconv"
+	"strings"
+)
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslog returns a Sink logging to syslog with tag, appending each value
+// to the message as key=value, e.g. cpu_pct=12.5. network and raddr are as
+// for syslog.Dial, empty to use the local syslog server.
+func NewSyslog(network, raddr, tag string) (*Sink, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{w: &syslogWriter{w: w}}, nil
+}
+
+func (s *syslogWriter) write(e entry) error {
+	msg := formatSyslog(e)
+	if e.level == levelWarning {
+		return s.w.Warning(msg)
+	}
+	return s.w.Info(msg)
+}
+
+func (s *syslogWriter) Close() error {
+	return s.w.Close()
+}
+
+// formatSyslog returns e's message followed by its fields as key=value.
+func formatSyslog(e entry) string {
+	var b strings.Builder
+	b.WriteString(e.message)
+	for _, f := range e.fields {
+		b.WriteString(" " + strings.ToLower(f[0]) + "=" + quote(f[1]))
+	}
+	return b.String()
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
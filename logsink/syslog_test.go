@@ -0,0 +1,30 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"testing"
+)
+
+func TestFormatSyslog(t *testing.T) {
+	e := entry{message: "msg", fields: [][2]string{{"CPU_PCT", "1.0"}, {"LABEL_X", "a b"}}}
+	if got, want := formatSyslog(e), `msg cpu_pct=1.0 label_x="a b"`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"regexp"
+)
+
+// Matcher finds the containers of a runtime among cgroup directories, see
+// Matchers
+type Matcher interface {
+	// Match returns the ID of the container path belongs to, path being
+	// its cgroup directory or anything below, and the name of the runtime
+	// which created it. ok is false if path is not a container's
+	Match(path string) (id, runtime string, ok bool)
+}
+
+type regexpMatcher struct {
+	runtime string
+	re      *regexp.Regexp
+}
+
+// RegexpMatcher returns a Matcher of the paths matching expr, like
+// ContainerDirRegexp, whose first non-empty group is the container ID.
+func RegexpMatcher(runtime, expr string) (Matcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return regexpMatcher{runtime: runtime, re: re}, nil
+}
+
+func (m regexpMatcher) Match(path string) (string, string, bool) {
+	matches := m.re.FindStringSubmatch(path)
+	if len(matches) < 2 {
+		return "", "", false
+	}
+	for _, id := range matches[1:] {
+		if id != "" {
+			return id, m.runtime, true
+		}
+	}
+	return "", "", false
+}
+
+func mustRegexpMatcher(runtime, expr string) Matcher {
+	m, err := RegexpMatcher(runtime, expr)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Built-in Matchers, for the cgroup layouts of the systemd and cgroupfs
+// cgroup drivers where they differ
+var (
+	// Docker and Moby
+	DockerMatcher = mustRegexpMatcher("docker", `.*(?:docker-([0-9a-z]{64})\.scope|/docker/([0-9a-z]{64}))(?:/|$)`)
+	// containerd, through its CRI plugin as used by Kubernetes
+	ContainerdMatcher = mustRegexpMatcher("containerd", `.*cri-containerd-([0-9a-f]{64})\.scope(?:/|$)`)
+	// CRI-O, excluding its conmon monitor processes
+	CRIOMatcher = mustRegexpMatcher("crio", `.*crio-([0-9a-f]{64})\.scope(?:/|$)`)
+	// Podman, excluding its conmon monitor processes
+	PodmanMatcher = mustRegexpMatcher("podman", `.*libpod-([0-9a-f]{64})(?:\.scope)?(?:/|$)`)
+	// LXC and LXD, by container name, from both the lxc/<name> and the
+	// lxc.payload.<name> layouts
+	LXCMatcher = mustRegexpMatcher("lxc", `.*/lxc(?:/|\.payload\.)([^/]+)(?:/|$)`)
+	// systemd services, by unit name, such as "nginx.service"
+	SystemdMatcher = mustRegexpMatcher("systemd", `.*/([^/]+\.service)(?:/|$)`)
+
+	// runtime name -> its built-in Matcher
+	BuiltinMatchers = map[string]Matcher{
+		"docker":     DockerMatcher,
+		"containerd": ContainerdMatcher,
+		"crio":       CRIOMatcher,
+		"podman":     PodmanMatcher,
+		"lxc":        LXCMatcher,
+		"systemd":    SystemdMatcher,
+	}
+)
+
+// match returns the ID and runtime of the container path belongs to, from
+// the first of h.matchers matching it.
+func (h *holder) match(path string) (id, runtime string, ok bool) {
+	for _, m := range h.matchers {
+		if id, runtime, ok := m.Match(path); ok {
+			return id, runtime, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"time"
+)
+
+const (
+	memFile             = "memory.stat"
+	memZswapCurrentFile = "memory.zswap.current"
+	memZswapMaxFile     = "memory.zswap.max"
+	memUsageFile        = "memory.usage_in_bytes"
+	memCurrentFile      = "memory.current"
+	// OOM kill counts, cgroup v2 and v1
+	memEventsFile     = "memory.events"
+	memOOMControlFile = "memory.oom_control"
+	// memory.events not including descendant cgroups, cgroup v2
+	memEventsLocalFile = "memory.events.local"
+	// usage watermark, cgroup v1 and v2 (kernel 5.19 and later)
+	memMaxUsageFile = "memory.max_usage_in_bytes"
+	memPeakFile     = "memory.peak"
+	// times the limit was hit, cgroup v1
+	memFailcntFile = "memory.failcnt"
+)
+
+// Memory statistics. Fields not reported by the kernel are left at zero.
+type MemStat struct {
+	// memory usage in bytes (memory.usage_in_bytes, memory.current)
+	Usage uint64
+	// highest Usage recorded since the cgroup was created, or the
+	// watermark was reset (memory.max_usage_in_bytes, memory.peak)
+	MaxUsage uint64
+	// times Usage reached Limits.Memory and allocations had to reclaim
+	// memory or fail, cgroup v1 only (memory.failcnt). See MaxEvents on v2
+	Failcnt uint64
+	// anonymous and page cache memory (memory.stat rss and cache on cgroup
+	// v1, anon and file on v2)
+	RSS   uint64
+	Cache uint64
+	// memory swapped out, cgroup v1 with swap accounting only (memory.stat
+	// swap). See memory.swap.current on v2
+	Swap uint64
+
+	// page faults
+	Pgfault uint64
+	// major page faults, which required a read from disk
+	Pgmajfault uint64
+
+	// anonymous and file backed memory on the active and inactive LRU lists.
+	// Inactive pages are the first candidates for reclaim
+	ActiveAnon   uint64
+	InactiveAnon uint64
+	ActiveFile   uint64
+	InactiveFile uint64
+	// memory on the unevictable LRU list, which reclaim cannot free:
+	// mlocked pages, such as buffers pinned by databases or DPDK, and
+	// ramfs and locked shared memory
+	Unevictable uint64
+
+	// kernel slab memory allocated for the container, such as dentries and
+	// inodes, which can be reclaimed under pressure and which cannot.
+	// cgroup v2 only
+	SlabReclaimable   uint64
+	SlabUnreclaimable uint64
+
+	// proportional set size of the container's processes, which divides
+	// each shared page between the processes mapping it, and the same for
+	// their swapped out pages. Collected when PSSUsage is set
+	PSS     uint64
+	SwapPSS uint64
+
+	// file backed memory waiting to be written to disk
+	Dirty uint64
+	// file backed memory being written to disk
+	Writeback uint64
+
+	// file backed memory mapped into processes
+	MappedFile uint64
+	// shared memory, including tmpfs and /dev/shm. Accounted as Cache on
+	// cgroup v1 although it cannot be dropped without swapping
+	Shmem uint64
+
+	// pages evicted from memory and faulted back in soon after, and those
+	// among them activated straight away as part of the working set. A
+	// high refault rate is the most direct sign of a container short of
+	// memory
+	WorkingsetRefault  uint64
+	WorkingsetActivate uint64
+	// the above split into anonymous and file backed pages, kernel 5.9
+	// and later
+	WorkingsetRefaultAnon  uint64
+	WorkingsetRefaultFile  uint64
+	WorkingsetActivateAnon uint64
+	WorkingsetActivateFile uint64
+
+	// compressed size of pages held in zswap (memory.stat "zswap")
+	Zswap uint64
+	// uncompressed size of pages held in zswap (memory.stat "zswapped")
+	Zswapped uint64
+	// pages swapped in from zswap
+	Zswpin uint64
+	// pages swapped out to zswap
+	Zswpout uint64
+	// pages written back from zswap to the backing swap device
+	Zswpwb uint64
+	// zswap usage in bytes, cgroup v2 only
+	ZswapCurrent uint64
+	// zswap limit in bytes, cgroup v2 only. Unlimited if not set
+	ZswapMax uint64
+
+	// processes killed by the OOM killer for exceeding the memory limit
+	// (memory.events, memory.oom_control "oom_kill")
+	OOMKills uint64
+	// times usage went over memory.high and the container was throttled,
+	// and times it reached memory.max and reclaim was forced, including
+	// descendant cgroups. cgroup v2 only (memory.events)
+	HighEvents uint64
+	MaxEvents  uint64
+	// the same for the container's cgroup alone, on kernels 5.2 and later
+	// (memory.events.local)
+	LocalHighEvents uint64
+	LocalMaxEvents  uint64
+
+	// counters were read from the total_* fields, see HierarchicalMemory
+	Hierarchical bool
+	// keys of memory.stat not read into a field above, including the
+	// variant of each field, plain or total_*, which HierarchicalMemory
+	// did not select
+	Extra     map[string]uint64
+	Timestamp time.Time
+}
+
+func (m *MemStat) createEvents(content string) {
+	kv := parseKeyValues(content)
+	m.OOMKills = kv["oom_kill"]
+	m.HighEvents = kv["high"]
+	m.MaxEvents = kv["max"]
+}
+
+func (m *MemStat) createEventsLocal(content string) {
+	kv := parseKeyValues(content)
+	m.LocalHighEvents = kv["high"]
+	m.LocalMaxEvents = kv["max"]
+}
+
+// create parses memory.stat, preferring the total_ counters of cgroup v1
+// if hierarchical is set, see HierarchicalMemory.
+func (m *MemStat) create(content string, hierarchical bool) {
+	kv := parseKeyValues(content)
+	m.Hierarchical = false
+	used := make(map[string]bool)
+	// get returns the first of keys present, allowing for fields
+	// which are named differently in cgroup v1 and v2
+	get := func(keys ...string) uint64 {
+		for _, key := range keys {
+			if hierarchical {
+				if v, ok := kv["total_"+key]; ok {
+					m.Hierarchical = true
+					used["total_"+key] = true
+					return v
+				}
+			}
+			if v, ok := kv[key]; ok {
+				used[key] = true
+				return v
+			}
+		}
+		return 0
+	}
+	m.Cache = get("cache", "file")
+	m.RSS = get("rss", "anon")
+	m.Swap = get("swap")
+	m.Pgfault = get("pgfault")
+	m.Pgmajfault = get("pgmajfault")
+	m.ActiveAnon = get("active_anon")
+	m.InactiveAnon = get("inactive_anon")
+	m.ActiveFile = get("active_file")
+	m.InactiveFile = get("inactive_file")
+	m.Unevictable = get("unevictable")
+	m.SlabReclaimable = get("slab_reclaimable")
+	m.SlabUnreclaimable = get("slab_unreclaimable")
+	m.Dirty = get("dirty", "file_dirty")
+	m.Writeback = get("writeback", "file_writeback")
+	m.MappedFile = get("mapped_file", "file_mapped")
+	m.Shmem = get("shmem")
+	m.WorkingsetRefaultAnon = get("workingset_refault_anon")
+	m.WorkingsetRefaultFile = get("workingset_refault_file")
+	m.WorkingsetActivateAnon = get("workingset_activate_anon")
+	m.WorkingsetActivateFile = get("workingset_activate_file")
+	// older kernels report only the totals
+	m.WorkingsetRefault = get("workingset_refault")
+	if m.WorkingsetRefault == 0 {
+		m.WorkingsetRefault = m.WorkingsetRefaultAnon + m.WorkingsetRefaultFile
+	}
+	m.WorkingsetActivate = get("workingset_activate")
+	if m.WorkingsetActivate == 0 {
+		m.WorkingsetActivate = m.WorkingsetActivateAnon + m.WorkingsetActivateFile
+	}
+	m.Zswap = get("zswap")
+	m.Zswapped = get("zswapped")
+	m.Zswpin = get("zswpin")
+	m.Zswpout = get("zswpout")
+	m.Zswpwb = get("zswpwb")
+	for key := range used {
+		delete(kv, key)
+	}
+	m.Extra = nil
+	if len(kv) > 0 {
+		m.Extra = kv
+	}
+	m.Timestamp = time.Now()
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetMetadata attaches the label key=value to container id. Labels are
+// returned in Cstats.Metadata and kept for as long as the container is
+// tracked. An empty value removes key.
+func SetMetadata(id, key, value string) error {
+	return statsHolder.setMetadata(id, key, value)
+}
+
+func (h *holder) setMetadata(id, key, value string) error {
+	h.Lock()
+	defer h.Unlock()
+	cs, ok := h.containers[id]
+	if !ok {
+		return fmt.Errorf("container %s not found", id)
+	}
+	cs.setMetadata(key, value)
+	// keep ReadCachedStats consistent
+	h.modifySnapshot(id, func(snap *Cstats) { snap.setMetadata(key, value) })
+	return nil
+}
+
+func (c *Cstats) setMetadata(key, value string) {
+	if value == "" {
+		delete(c.Metadata, key)
+		return
+	}
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[key] = value
+}
+
+// addHostLabels adds the host labels, see HostLabels, which c has no label
+// for.
+func (c *Cstats) addHostLabels(labels map[string]string) {
+	for k, v := range labels {
+		if _, ok := c.Metadata[k]; !ok {
+			c.setMetadata(k, v)
+		}
+	}
+}
+
+// ParseLabels parses a comma separated list of key=value labels, such as
+// for HostLabels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid label '%s', expected key=value", term)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+// copyMetadata returns a copy of m.
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	n := make(map[string]string, len(m))
+	for k, v := range m {
+		n[k] = v
+	}
+	return n
+}
+
+// GroupBy splits stats by the value of the Metadata label key, for
+// aggregating containers by project, service or similar. Containers
+// without the label are grouped under the empty string.
+func GroupBy(stats Cmap, key string) map[string]Cmap {
+	groups := make(map[string]Cmap)
+	for id, cs := range stats {
+		v := cs.Metadata[key]
+		if groups[v] == nil {
+			groups[v] = make(Cmap)
+		}
+		groups[v][id] = cs
+	}
+	return groups
+}
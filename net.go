@@ -0,0 +1,109 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Network counters of a container's network namespace, see NetStats
+type NetStat struct {
+	// every interface in the namespace, in the order listed by the kernel
+	Interfaces []NetInterface
+	// sums over Interfaces, except loopback
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+	Timestamp time.Time
+}
+
+// Counters of a network interface, from /proc/<pid>/net/dev
+type NetInterface struct {
+	Name      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// readNet reads the network counters of the namespace of c's first member
+// process, from procfs mounted at procPath.
+func (c *Cstats) readNet(procPath string) error {
+	pids, err := c.memberPIDs()
+	if err != nil || len(pids) == 0 {
+		return err
+	}
+	b, err := readFile(filepath.Join(procPath, pids[0], "net", "dev"))
+	if err != nil {
+		return ignoreMissing(err)
+	}
+	c.Net.create(string(b))
+	return nil
+}
+
+// create parses /proc/net/dev: two header lines, then an interface per
+// line followed by 8 receive and 8 transmit counters.
+func (n *NetStat) create(content string) {
+	*n = NetStat{Timestamp: time.Now()}
+	lines := strings.Split(content, "\n")
+	if len(lines) < 2 {
+		return
+	}
+	for _, line := range lines[2:] {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 16 {
+			continue
+		}
+		v := make([]uint64, 16)
+		for j := range v {
+			v[j], _ = strconv.ParseUint(fields[j], 10, 64)
+		}
+		iface := NetInterface{
+			Name:    strings.TrimSpace(line[:i]),
+			RxBytes: v[0], RxPackets: v[1], RxErrors: v[2], RxDropped: v[3],
+			TxBytes: v[8], TxPackets: v[9], TxErrors: v[10], TxDropped: v[11],
+		}
+		n.Interfaces = append(n.Interfaces, iface)
+		if iface.Name == "lo" {
+			continue
+		}
+		n.RxBytes += iface.RxBytes
+		n.RxPackets += iface.RxPackets
+		n.RxErrors += iface.RxErrors
+		n.RxDropped += iface.RxDropped
+		n.TxBytes += iface.TxBytes
+		n.TxPackets += iface.TxPackets
+		n.TxErrors += iface.TxErrors
+		n.TxDropped += iface.TxDropped
+	}
+}
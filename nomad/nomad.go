@@ -0,0 +1,91 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package nomad discovers tasks run by HashiCorp Nomad's exec, raw_exec
+// and java drivers and labels them with their allocation ID and task
+// name. Tasks run by the docker driver are found by the default
+// gocstat.ContainerDirRegexp.
+//
+// Set gocstat.ContainerDirRegexp to DirRegexp before gocstat.Init, then
+// call Enrich periodically, or use Run:
+//
+//	gocstat.ContainerDirRegexp = nomad.DirRegexp
+//	if err := gocstat.Init(errChan); err != nil {
+//		log.Fatal(err)
+//	}
+//	stop := nomad.Run(time.Minute, errChan)
+//	defer stop()
+package nomad
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/porjo/gocstat"
+	"github.com/porjo/gocstat/internal/enrich"
+)
+
+// DirRegexp matches the cgroup directories Nomad creates for tasks,
+// capturing <alloc ID>.<task> as the container ID. It covers cgroup v1,
+// <controller>/nomad/<alloc ID>.<task> and cpuset/nomad/{shared,reserved}/...,
+// and cgroup v2, nomad.slice/<alloc ID>.<task>.scope and
+// nomad.slice/{share,reserve}.slice/...
+const DirRegexp = `.*/nomad(?:\.slice)?/(?:[^/]+/)?(` + allocIDPattern + `\.[^/]+?)(?:\.scope)?(?:/.*)?$`
+
+const allocIDPattern = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+// Metadata keys set by Enrich
+const (
+	AllocIDKey = "nomad.alloc_id"
+	TaskKey    = "nomad.task"
+)
+
+var idRegexp = regexp.MustCompile(`^(` + allocIDPattern + `)\.(.+)$`)
+
+// ParseID splits a container ID captured by DirRegexp into its allocation
+// ID and task name. ok is false if id doesn't have this form.
+func ParseID(id string) (allocID, task string, ok bool) {
+	m := idRegexp.FindStringSubmatch(id)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// Enrich sets the allocation ID and task labels of every tracked container
+// whose ID was captured by DirRegexp, see gocstat.SetMetadata. It doesn't
+// collect statistics, and the error is always nil.
+func Enrich() error {
+	for _, c := range gocstat.ListContainers() {
+		allocID, task, ok := ParseID(c.ID)
+		if !ok {
+			continue
+		}
+		if err := gocstat.SetMetadata(c.ID, AllocIDKey, allocID); err != nil {
+			// removed since ListContainers
+			continue
+		}
+		gocstat.SetMetadata(c.ID, TaskKey, task)
+	}
+	return nil
+}
+
+// Run calls Enrich immediately and then every interval until the returned
+// stop function is called. errChan is optional and used for reporting
+// Enrich errors, it is never closed by Run.
+func Run(interval time.Duration, errChan chan<- error) func() {
+	return enrich.Run(interval, Enrich, errChan)
+}
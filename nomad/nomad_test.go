@@ -0,0 +1,83 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package nomad
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/porjo/gocstat"
+)
+
+const alloc = "5f1e8a7c-3b2d-4c1e-9f0a-1b2c3d4e5f60"
+
+func TestDirRegexp(t *testing.T) {
+	re := regexp.MustCompile(DirRegexp)
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{"/sys/fs/cgroup/memory/nomad/" + alloc + ".web/memory.usage_in_bytes", alloc + ".web"},
+		{"/sys/fs/cgroup/cpuset/nomad/shared/" + alloc + ".web", alloc + ".web"},
+		{"/sys/fs/cgroup/nomad.slice/" + alloc + ".web.scope/cpu.stat", alloc + ".web"},
+		{"/sys/fs/cgroup/nomad.slice/share.slice/" + alloc + ".api.v2.scope", alloc + ".api.v2"},
+		{"/sys/fs/cgroup/nomad.slice/share.slice", ""},
+		{"/sys/fs/cgroup/system.slice/nomad.service/memory.current", ""},
+	}
+	for _, test := range tests {
+		var id string
+		if m := re.FindStringSubmatch(test.path); m != nil {
+			id = m[1]
+		}
+		if id != test.id {
+			t.Errorf("%s: expected ID %q, got %q", test.path, test.id, id)
+		}
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "nomad.slice", "share.slice", alloc+".web.scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gocstat.BasePath = base
+	gocstat.ContainerDirRegexp = DirRegexp
+	if err := gocstat.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enrich(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := gocstat.ReadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, ok := stats[alloc+".web"]
+	if !ok {
+		t.Fatalf("task not found in %v", stats)
+	}
+	if cs.Metadata[AllocIDKey] != alloc || cs.Metadata[TaskKey] != "web" {
+		t.Errorf("expected %s=%s and %s=web, got %v", AllocIDKey, alloc, TaskKey, cs.Metadata)
+	}
+}
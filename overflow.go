@@ -0,0 +1,135 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"sort"
+)
+
+// What to do when a container is found with MaxContainers already tracked
+type OverflowPolicy int
+
+const (
+	// don't track the new container
+	OverflowDropNewest OverflowPolicy = iota
+	// stop tracking the least active container, preferring inactive ones
+	// and then the lowest Utilization.CPUPct, to make room for the new one
+	OverflowDropLeastActive
+	// track the new container anyway, only reporting the overflow
+	OverflowWarn
+)
+
+// Reported on the channel passed to NotifyOverflow
+type Overflow struct {
+	// container found
+	ID string
+	// container no longer tracked because of it, ID itself under
+	// OverflowDropNewest and empty under OverflowWarn
+	Dropped string
+	// number of containers tracked
+	Tracked int
+}
+
+// NotifyOverflow sends an Overflow on ch each time a container is found
+// with MaxContainers already tracked. Containers dropped stay untracked,
+// and are not reported again, for as long as they exist.
+//
+// Sending on ch does not block: events are dropped if ch is not ready to
+// receive. Pass a nil ch to stop notifications.
+func NotifyOverflow(ch chan<- Overflow) {
+	statsHolder.notifyOverflow(ch)
+}
+
+func (h *holder) notifyOverflow(ch chan<- Overflow) {
+	h.Lock()
+	defer h.Unlock()
+	h.overflow = ch
+}
+
+// admit reports whether newly found container id should be tracked,
+// applying ContainerOverflow once MaxContainers are. The holder must be
+// locked.
+func (h *holder) admit(id string) bool {
+	if h.scanRejected == nil {
+		h.scanRejected = make(map[string]bool)
+	}
+	cfg := h.config()
+	if cfg.MaxContainers <= 0 || len(h.containers) < cfg.MaxContainers {
+		return true
+	}
+	if h.rejected[id] || h.scanRejected[id] {
+		h.scanRejected[id] = true
+		return false
+	}
+	ev := Overflow{ID: id, Dropped: id}
+	switch cfg.ContainerOverflow {
+	case OverflowWarn:
+		ev.Dropped = ""
+	case OverflowDropLeastActive:
+		if victim := h.leastActive(); victim != "" {
+			delete(h.containers, victim)
+			h.dropSnapshot(victim)
+			delete(h.history, victim)
+			ev.Dropped = victim
+		}
+	}
+	if ev.Dropped != "" {
+		h.scanRejected[ev.Dropped] = true
+	}
+	ev.Tracked = len(h.containers)
+	if ev.Dropped != id {
+		ev.Tracked++
+	}
+	if h.overflow != nil {
+		select {
+		case h.overflow <- ev:
+		default:
+		}
+	}
+	return ev.Dropped != id
+}
+
+// endScan forgets dropped containers which were not found again by the
+// scan just completed. The holder must be locked.
+func (h *holder) endScan() {
+	h.rejected = h.scanRejected
+	h.scanRejected = nil
+}
+
+// leastActive returns the ID of the tracked container with the least
+// activity as of the last collection.
+func (h *holder) leastActive() string {
+	ids := make([]string, 0, len(h.containers))
+	for id := range h.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var least string
+	for _, id := range ids {
+		if least == "" || lessActive(h.containers[id], h.containers[least]) {
+			least = id
+		}
+	}
+	return least
+}
+
+func lessActive(a, b *Cstats) bool {
+	if a.Inactive != b.Inactive {
+		return a.Inactive
+	}
+	return a.Utilization.CPUPct < b.Utilization.CPUPct
+}
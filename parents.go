@@ -0,0 +1,106 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"io/ioutil"
+	"path/filepath"
+)
+
+// updateParents tracks the parent cgroups of containers, when ParentStats
+// is set, and forgets those left without containers. The holder must be
+// locked.
+func (h *holder) updateParents() {
+	parentStats := h.config().ParentStats
+	found := make(map[string]bool)
+	for _, cs := range h.containers {
+		if cs.Parent {
+			continue
+		}
+		cs.ParentID = ""
+		if !parentStats {
+			continue
+		}
+		for _, dir := range cs.dirs() {
+			parent := filepath.Dir(dir)
+			if rel := h.hierarchyPath(h.basePath, parent); rel == "" || rel == "." {
+				continue
+			}
+			if _, _, ok := h.match(parent); ok {
+				continue
+			}
+			id := sanitizeID(filepath.Base(parent))
+			p, ok := h.containers[id]
+			if !ok {
+				if !h.admit(id) {
+					continue
+				}
+				p = &Cstats{
+					Parent:       true,
+					files:        make(map[string]string),
+					limitFiles:   make(map[string]string),
+					controlFiles: make(map[string]string),
+					path:         h.hierarchyPath(h.basePath, parent),
+					cfg:          h.cfg,
+				}
+				h.containers[id] = p
+			} else if !p.Parent {
+				// a container's ID
+				continue
+			}
+			p.addFiles(parent)
+			cs.ParentID = id
+			found[id] = true
+		}
+	}
+	for id, cs := range h.containers {
+		if cs.Parent && !found[id] {
+			delete(h.containers, id)
+			h.dropSnapshot(id)
+			delete(h.history, id)
+		}
+	}
+}
+
+// dirs returns the directories of c's files, one per hierarchy on cgroup
+// v1.
+func (c *Cstats) dirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, files := range []map[string]string{c.files, c.limitFiles, c.controlFiles} {
+		for _, p := range files {
+			if dir := filepath.Dir(p); !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// addFiles adds the files of directory dir, without descending into it.
+func (c *Cstats) addFiles(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			c.addFile(e.Name(), filepath.Join(dir, e.Name()))
+		}
+	}
+}
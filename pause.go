@@ -0,0 +1,81 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ContainerInfo describes a tracked container, see ListContainers
+type ContainerInfo struct {
+	ID string
+	// cgroup directory relative to the hierarchy root
+	Path string
+	// name of the Scope the container belongs to, if any
+	Scope string
+	// see SetPriority
+	Priority int
+	// see Pause
+	Paused bool
+}
+
+// ListContainers returns the containers currently tracked, paused or not,
+// ordered by ID.
+func ListContainers() []ContainerInfo {
+	return statsHolder.listContainers()
+}
+
+func (h *holder) listContainers() []ContainerInfo {
+	h.Lock()
+	defer h.Unlock()
+	list := make([]ContainerInfo, 0, len(h.containers))
+	for id, cs := range h.containers {
+		info := ContainerInfo{ID: id, Path: cs.path, Priority: cs.priority, Paused: cs.paused}
+		if cs.scope != nil {
+			info.Scope = cs.scope.Name
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Pause excludes container id from collection until Resume is called. Its
+// files are not read and it is left out of the statistics, sinks and
+// history, though it is still tracked.
+func Pause(id string) error {
+	return statsHolder.setPaused(id, true)
+}
+
+// Resume includes container id, paused by Pause, in collection again. The
+// rates and Utilization of its first collection after resuming are
+// averaged over the time it was paused.
+func Resume(id string) error {
+	return statsHolder.setPaused(id, false)
+}
+
+func (h *holder) setPaused(id string, paused bool) error {
+	h.Lock()
+	defer h.Unlock()
+	cs, ok := h.containers[id]
+	if !ok {
+		return fmt.Errorf("container %s not found", id)
+	}
+	cs.paused = paused
+	return nil
+}
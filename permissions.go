@@ -0,0 +1,174 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Permissions of the current user to read the files needed by gocstat,
+// see ProbePermissions
+type PermissionReport struct {
+	// effective user ID
+	UID int
+	// by subsystem, as used by SubsystemIntervals, sorted by name
+	Subsystems []SubsystemPermissions
+}
+
+// Permissions to read the files of a subsystem
+type SubsystemPermissions struct {
+	Subsystem string
+	// by file base name, sorted by name
+	Files []FilePermission
+}
+
+// Permission to read a file of every container
+type FilePermission struct {
+	// base name, such as "memory.stat"
+	Name string
+	// containers whose file could be read, and could not
+	Readable int
+	Denied   int
+	// a denied path, and the error reading it
+	DeniedPath string
+	Err        error
+	// the denied file is owned by root and not readable by others, so
+	// reading it requires running as root
+	RequiresRoot bool
+}
+
+// Readable reports whether every file of s could be read.
+func (s SubsystemPermissions) Readable() bool {
+	for _, f := range s.Files {
+		if f.Denied > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Readable reports whether every file could be read.
+func (r PermissionReport) Readable() bool {
+	for _, s := range r.Subsystems {
+		if !s.Readable() {
+			return false
+		}
+	}
+	return true
+}
+
+// ProbePermissions checks whether the current user can read the stat and
+// limit files of every container found by Init, and the files below
+// ProcPath needed by FSUsage and TmpfsUsage, which require access to
+// the containers' processes. Agents can use it to fail fast or to disable
+// what they cannot read when run unprivileged. Files are opened, not
+// read, once per container.
+func ProbePermissions() (PermissionReport, error) {
+	return statsHolder.probePermissions()
+}
+
+func (h *holder) probePermissions() (PermissionReport, error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.containers == nil {
+		return PermissionReport{}, fmt.Errorf("not initialized")
+	}
+	cfg := h.config()
+	files := make(map[string]map[string]*FilePermission)
+	probe := func(subsystem, name, path string) {
+		if files[subsystem] == nil {
+			files[subsystem] = make(map[string]*FilePermission)
+		}
+		p, ok := files[subsystem][name]
+		if !ok {
+			p = &FilePermission{Name: name}
+			files[subsystem][name] = p
+		}
+		f, err := openFile(path, os.O_RDONLY)
+		if err == nil {
+			f.Close()
+			p.Readable++
+			return
+		}
+		p.Denied++
+		if p.DeniedPath == "" {
+			p.DeniedPath, p.Err, p.RequiresRoot = path, err, rootOnly(path, err)
+		}
+	}
+	for _, cs := range h.containers {
+		for name, path := range cs.files {
+			probe(subsystem(name), name, path)
+		}
+		for name, path := range cs.limitFiles {
+			probe(subsystem(name), name, path)
+		}
+		if !cfg.FSUsage && !cfg.TmpfsUsage && !cfg.NetStats && !cfg.PSSUsage {
+			continue
+		}
+		pids, err := cs.memberPIDs()
+		if err != nil || len(pids) == 0 {
+			continue
+		}
+		if cfg.FSUsage || cfg.TmpfsUsage {
+			probe("fs", "root", filepath.Join(cfg.ProcPath, pids[0], "root"))
+		}
+		if cfg.TmpfsUsage {
+			probe("fs", "mounts", filepath.Join(cfg.ProcPath, pids[0], "mounts"))
+		}
+		if cfg.NetStats {
+			probe("net", "dev", filepath.Join(cfg.ProcPath, pids[0], "net", "dev"))
+		}
+		if cfg.PSSUsage {
+			probe("pss", "smaps_rollup", filepath.Join(cfg.ProcPath, pids[0], "smaps_rollup"))
+		}
+	}
+
+	r := PermissionReport{UID: os.Geteuid()}
+	for name, byFile := range files {
+		s := SubsystemPermissions{Subsystem: name}
+		for _, p := range byFile {
+			s.Files = append(s.Files, *p)
+		}
+		sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Name < s.Files[j].Name })
+		r.Subsystems = append(r.Subsystems, s)
+	}
+	sort.Slice(r.Subsystems, func(i, j int) bool { return r.Subsystems[i].Subsystem < r.Subsystems[j].Subsystem })
+	return r, nil
+}
+
+// unreadable reports whether err is a permission error to be ignored, see
+// SkipUnreadable.
+func unreadable(cfg *Config, err error) bool {
+	return cfg.SkipUnreadable && os.IsPermission(err)
+}
+
+// rootOnly reports whether err, from opening path, is because path is
+// owned by root and not readable by others.
+func rootOnly(path string, err error) bool {
+	if !os.IsPermission(err) {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	uid, ok := fileOwner(info)
+	return ok && uid == 0 && info.Mode().Perm()&0004 == 0
+}
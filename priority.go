@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// CollectionReport describes how a collection went, see LastCollection
+type CollectionReport struct {
+	// number of the collection, see Cstats.Generation
+	Generation uint64
+	Start      time.Time
+	Took       time.Duration
+	// CollectionBudget or CollectionDeadline was exceeded and containers
+	// were skipped
+	Overrun bool
+	// number of containers collected
+	Collected int
+	// IDs of the containers skipped, in collection order
+	Skipped []string
+	// number of reads retried after a transient error, see readFile. Reads
+	// made outside of collection, such as by Watch, may be included
+	Retries uint64
+	// number of snapshots dropped by Watch since the process started, for
+	// slow receivers, see WatchBackpressure
+	WatchDropped uint64
+}
+
+// LastCollection reports on the last collection which completed without
+// error, so partial snapshots can be told apart and monitored.
+func LastCollection() CollectionReport {
+	return statsHolder.lastCollection()
+}
+
+func (h *holder) lastCollection() CollectionReport {
+	h.Lock()
+	defer h.Unlock()
+	r := h.report
+	r.WatchDropped = atomic.LoadUint64(&h.watchDropped)
+	r.Skipped = append([]string(nil), r.Skipped...)
+	return r
+}
+
+// overrun reports whether cs is to be skipped, elapsed into a collection,
+// for cfg.CollectionBudget or cfg.CollectionDeadline.
+func overrun(cfg *Config, cs *Cstats, elapsed time.Duration) bool {
+	if cfg.CollectionDeadline > 0 && elapsed > cfg.CollectionDeadline {
+		return true
+	}
+	return cfg.CollectionBudget > 0 && cs.priority <= 0 && elapsed > cfg.CollectionBudget
+}
+
+// SetPriority sets the collection priority of container id, zero by
+// default. Containers are collected by descending priority, and those with
+// a positive priority are never skipped for CollectionBudget, though they
+// are for CollectionDeadline.
+func SetPriority(id string, priority int) error {
+	return statsHolder.setPriority(id, priority)
+}
+
+func (h *holder) setPriority(id string, priority int) error {
+	h.Lock()
+	defer h.Unlock()
+	cs, ok := h.containers[id]
+	if !ok {
+		return fmt.Errorf("container %s not found", id)
+	}
+	cs.priority = priority
+	return nil
+}
+
+// collectionOrder returns the IDs of the tracked containers in the order
+// they are to be collected: by descending priority, then by descending CPU
+// utilization as of the previous collection so the top consumers come
+// first. The holder must be locked.
+func (h *holder) collectionOrder() []string {
+	ids := make([]string, 0, len(h.containers))
+	for id := range h.containers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := h.containers[ids[i]], h.containers[ids[j]]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.Utilization.CPUPct != b.Utilization.CPUPct {
+			return a.Utilization.CPUPct > b.Utilization.CPUPct
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// skipped returns the statistics reported for c when it is skipped by
+// collection generation: those of its previous collection, if any, with
+// Skipped set.
+func (c *Cstats) skipped(generation uint64) *Cstats {
+	s := &Cstats{Metadata: copyMetadata(c.Metadata)}
+	if c.prev != nil {
+		s = c.prev.clone()
+	}
+	s.Skipped = true
+	s.Generation = generation
+	return s
+}
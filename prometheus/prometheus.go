@@ -0,0 +1,100 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+// Package prometheus serves gocstat statistics on a /metrics endpoint in
+// the Prometheus text exposition format, without depending on the
+// Prometheus client library. Every field of gocstat.Schema is exported as
+// a metric named after Field.Metric with a "container_" prefix, such as
+// container_cpu_user_seconds_total, labeled by container ID.
+//
+// Handler is not a prometheus.Collector and cannot be registered on an
+// existing client_golang registry: it needs an endpoint of its own.
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/porjo/gocstat"
+)
+
+// ContentType is that of the text exposition format written by Handler.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler reads the statistics of every container each time it is
+// scraped. gocstat.Init must be called first.
+type Handler struct {
+	// Returns the statistics to export, gocstat.ReadCachedStats if nil,
+	// so that concurrent scrapes share a collection, see MaxStaleness
+	ReadStats func() (gocstat.Cmap, error)
+
+	fields []gocstat.Field
+}
+
+// New returns a Handler of every field of gocstat.Schema.
+func New() *Handler {
+	return &Handler{fields: gocstat.Schema()}
+}
+
+// ServeHTTP writes the metrics of every container, or responds with an
+// error if the statistics could not be read, so that the scrape fails.
+// Container IDs are exported as configured by gocstat.ExportID.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	read := h.ReadStats
+	if read == nil {
+		read = gocstat.ReadCachedStats
+	}
+	stats, err := read()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	h.write(w, gocstat.Export(stats))
+}
+
+func (h *Handler) write(w io.Writer, stats gocstat.Cmap) error {
+	ids := make([]string, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	b := bufio.NewWriter(w)
+	for _, f := range h.fields {
+		name := "container_" + f.Metric
+		b.WriteString("# HELP " + name + " " + escape(f.Help, false) + "\n")
+		b.WriteString("# TYPE " + name + " " + string(f.Type) + "\n")
+		for _, id := range ids {
+			b.WriteString(name + `{id="` + escape(id, true) + `"} `)
+			b.WriteString(strconv.FormatFloat(f.Value(stats[id]), 'g', -1, 64) + "\n")
+		}
+	}
+	return b.Flush()
+}
+
+// escape escapes s as a HELP text, or as a label value if label is set.
+func escape(s string, label bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if label {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+package prometheus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/porjo/gocstat"
+)
+
+func TestHandler(t *testing.T) {
+	id := "49790a8b0788924efcd0aa1719b247edc2b9934420e1a8c19ac82b5bbfbb5753"
+	h := New()
+	h.ReadStats = func() (gocstat.Cmap, error) {
+		cs := &gocstat.Cstats{}
+		cs.Memory.RSS = 4096
+		cs.CPU.Units = gocstat.CPUUnitTicks
+		cs.CPU.User = 250
+		return gocstat.Cmap{id: cs}, nil
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE container_memory_rss_bytes gauge\n",
+		`container_memory_rss_bytes{id="` + id + `"} 4096` + "\n",
+		"# TYPE container_cpu_user_seconds_total counter\n",
+		`container_cpu_user_seconds_total{id="` + id + `"} 2.5` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in\n%s", want, body)
+		}
+	}
+
+	h.ReadStats = func() (gocstat.Cmap, error) { return nil, errors.New("failed") }
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the scrape to fail, got %d", w.Code)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := escape("a\"b\\c\nd", true); got != `a\"b\\c\nd` {
+		t.Errorf("unexpected label value %s", got)
+	}
+	if got := escape("a\"b", false); got != `a"b` {
+		t.Errorf("unexpected help text %s", got)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Reported on the channel passed to NotifyPruned
+type Pruned struct {
+	// container no longer tracked
+	ID string
+	// file whose read failed, and the error it failed with, such as
+	// syscall.ENOENT
+	Path string
+	Err  error
+	// the container had no member processes as of its previous collection,
+	// as expected of one which exited. Otherwise its files disappearing
+	// may mean the controller was unmounted
+	Inactive  bool
+	Timestamp time.Time
+}
+
+// NotifyPruned sends a Pruned on ch each time a container stops being
+// tracked because its files disappeared while it was read, rather than
+// letting it vanish from the statistics silently.
+//
+// Sending on ch does not block: events are dropped if ch is not ready to
+// receive. Pass a nil ch to stop notifications.
+func NotifyPruned(ch chan<- Pruned) {
+	statsHolder.notifyPruned(ch)
+}
+
+func (h *holder) notifyPruned(ch chan<- Pruned) {
+	h.Lock()
+	defer h.Unlock()
+	h.pruned = ch
+}
+
+// prune stops tracking container id, whose read failed with err. The
+// holder must be locked.
+func (h *holder) prune(id string, err error) {
+	cs := h.containers[id]
+	delete(h.containers, id)
+	delete(h.history, id)
+	if h.pruned == nil {
+		return
+	}
+	ev := Pruned{ID: id, Err: err, Inactive: cs.Inactive, Timestamp: time.Now()}
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		ev.Path, ev.Err = pe.Path, pe.Err
+	}
+	select {
+	case h.pruned <- ev:
+	default:
+	}
+}
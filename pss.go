@@ -0,0 +1,72 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readPSS sums the proportional set size of c's member processes, from
+// /proc/<pid>/smaps_rollup in procfs mounted at procPath. Processes
+// exiting meanwhile are skipped.
+func (c *Cstats) readPSS(procPath string) error {
+	pids, err := c.memberPIDs()
+	if err != nil {
+		return err
+	}
+	var pss, swapPSS uint64
+	for _, pid := range pids {
+		b, err := readFile(filepath.Join(procPath, pid, "smaps_rollup"))
+		if err != nil {
+			if err = ignoreMissing(err); err != nil {
+				return err
+			}
+			continue
+		}
+		p, s := parseSmapsRollup(string(b))
+		pss += p
+		swapPSS += s
+	}
+	c.Memory.PSS = pss
+	c.Memory.SwapPSS = swapPSS
+	return nil
+}
+
+// parseSmapsRollup returns the Pss and SwapPss of smaps_rollup, in bytes.
+// Its first line is the address range of the mappings, followed by a
+// "Key:   value kB" line per field.
+func parseSmapsRollup(content string) (pss, swapPSS uint64) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[2] != "kB" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "Pss:":
+			pss = v * 1024
+		case "SwapPss:":
+			swapPSS = v * 1024
+		}
+	}
+	return pss, swapPSS
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Snapshot is the statistics of one collection, as recorded by an Encoder
+type Snapshot struct {
+	Time  time.Time
+	Stats Cmap
+}
+
+// Encoder writes snapshots to a stream, one JSON object per line
+type Encoder struct {
+	enc *json.Encoder
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{enc: json.NewEncoder(w)}
+}
+
+// Encode writes stats, collected at t.
+func (e *Encoder) Encode(t time.Time, stats Cmap) error {
+	return e.enc.Encode(Snapshot{Time: t, Stats: stats})
+}
+
+// Decoder reads snapshots written by an Encoder
+type Decoder struct {
+	dec *json.Decoder
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// Decode returns the next snapshot, or io.EOF at the end of the stream.
+func (d *Decoder) Decode() (Snapshot, error) {
+	var s Snapshot
+	err := d.dec.Decode(&s)
+	return s, err
+}
+
+// Replay reads the snapshots from dec and processes them as ReadStats
+// would have: Utilization and Derived are recomputed from consecutive
+// snapshots, then history is recorded, anomalies checked and sinks sent the
+// result, using the snapshot times rather than the current time. fn, if not
+// nil, is called with each processed snapshot; an error from it stops the
+// replay.
+//
+// Replay keeps its own history and does not affect live collection, but
+// delivers to the channels registered with NotifyAnomalies and AddSink.
+// As they don't block, receivers should keep up or be buffered. CPU
+// utilization of containers without a quota is relative to this host's
+// CPUs.
+func Replay(dec *Decoder, fn func(Snapshot) error) error {
+	return statsHolder.replay(dec, fn)
+}
+
+func (h *holder) replay(dec *Decoder, fn func(Snapshot) error) error {
+	h.Lock()
+	r := &holder{
+		cfg:       h.cfg,
+		anomalies: h.anomalies,
+		sinks:     append([]*sinkRoute(nil), h.sinks...),
+		derived:   append([]*derivedMetric(nil), h.derived...),
+	}
+	h.Unlock()
+	cfg := r.config()
+
+	prev := make(Cmap)
+	for {
+		s, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for id := range prev {
+			if _, ok := s.Stats[id]; !ok {
+				delete(prev, id)
+				delete(r.history, id)
+			}
+		}
+		for id, cs := range s.Stats {
+			p := prev[id]
+			cs.Utilization = utilization(cs, p)
+			cs.Derived = r.derive(id, cs, p)
+			r.record(cfg, id, cs, p, s.Time)
+			prev[id] = cs.clone()
+		}
+		r.snapshot = s.Stats
+		r.snapshotTime = s.Time
+		r.snapshotShared = false
+		r.snapshotCopied = nil
+		r.dispatch()
+		if fn != nil {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+}
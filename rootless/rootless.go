@@ -0,0 +1,54 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+// Package rootless discovers containers run by rootless Docker and Podman
+// in the cgroup v2 tree systemd delegates to a user, user@<UID>.service,
+// as on rootless CI runners. The files of that tree are owned by the
+// user, so the agent need not run as root.
+//
+// Call Configure before gocstat.Init:
+//
+//	rootless.Configure(os.Getuid())
+//	if err := gocstat.Init(errChan); err != nil {
+//		log.Fatal(err)
+//	}
+package rootless
+
+import (
+	"fmt"
+
+	"github.com/porjo/gocstat"
+)
+
+// DirRegexp matches the scopes rootless Docker (docker-<ID>.scope) and
+// Podman (libpod-<ID>.scope) create below a user's delegated tree,
+// capturing the container ID
+const DirRegexp = `.*/user@[0-9]+\.service/.*(?:docker|libpod)-([0-9a-f]{64})\.scope.*`
+
+// BasePath returns the cgroup v2 tree systemd delegates to uid, below the
+// cgroup2 mount at /sys/fs/cgroup.
+func BasePath(uid int) string {
+	return fmt.Sprintf("/sys/fs/cgroup/user.slice/user-%d.slice/user@%d.service", uid, uid)
+}
+
+// Configure sets gocstat.BasePath to the delegated tree of uid and
+// gocstat.ContainerDirRegexp to DirRegexp. It also sets
+// gocstat.SkipUnreadable, as some files of the tree, such as those of
+// controllers the user may not configure, can remain owned by root.
+func Configure(uid int) {
+	gocstat.BasePath = BasePath(uid)
+	gocstat.ContainerDirRegexp = DirRegexp
+	gocstat.SkipUnreadable = true
+}
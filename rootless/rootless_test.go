@@ -0,0 +1,62 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+package rootless
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/porjo/gocstat"
+)
+
+var id = strings.Repeat("0123456789abcdef", 4)
+
+func TestDirRegexp(t *testing.T) {
+	re := regexp.MustCompile(DirRegexp)
+	base := BasePath(1000)
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{base + "/user.slice/libpod-" + id + ".scope/memory.current", id},
+		{base + "/user.slice/libpod-" + id + ".scope/container/cpu.stat", id},
+		{base + "/user.slice/docker-" + id + ".scope", id},
+		{base + "/app.slice/podman.service", ""},
+		{"/sys/fs/cgroup/system.slice/docker-" + id + ".scope", ""},
+	}
+	for _, test := range tests {
+		var got string
+		if m := re.FindStringSubmatch(test.path); m != nil {
+			got = m[1]
+		}
+		if got != test.id {
+			t.Errorf("%s: expected ID %q, got %q", test.path, test.id, got)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	basePath, dirRegexp := gocstat.BasePath, gocstat.ContainerDirRegexp
+	defer func() {
+		gocstat.BasePath, gocstat.ContainerDirRegexp, gocstat.SkipUnreadable = basePath, dirRegexp, false
+	}()
+	Configure(1000)
+	if gocstat.BasePath != "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service" ||
+		gocstat.ContainerDirRegexp != DirRegexp || !gocstat.SkipUnreadable {
+		t.Errorf("unexpected configuration %s %s %v", gocstat.BasePath, gocstat.ContainerDirRegexp, gocstat.SkipUnreadable)
+	}
+}
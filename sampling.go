@@ -0,0 +1,115 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// subsystem returns the subsystem a stat file belongs to, as used by
+// SubsystemIntervals and SetSampleInterval. Filesystem usage, network
+// counters and PSS, which have no stat file, are subsystems "fs", "net" and
+// "pss".
+func subsystem(file string) string {
+	s := strings.SplitN(file, ".", 2)[0]
+	switch s {
+	case "cpuacct":
+		return "cpu"
+	case "blkio":
+		return "io"
+	case "freezer":
+		return "cgroup"
+	}
+	return s
+}
+
+// SetSampleInterval sets the minimum interval between reads of subsystem
+// (see SubsystemIntervals) for container id, overriding
+// SubsystemIntervals. An empty subsystem applies interval to all of the
+// container's subsystems. A zero interval removes the override.
+func SetSampleInterval(id, subsystem string, interval time.Duration) error {
+	return statsHolder.setSampleInterval(id, subsystem, interval)
+}
+
+func (h *holder) setSampleInterval(id, subsystem string, interval time.Duration) error {
+	h.Lock()
+	defer h.Unlock()
+	cs, ok := h.containers[id]
+	if !ok {
+		return fmt.Errorf("container %s not found", id)
+	}
+	if interval == 0 {
+		delete(cs.intervals, subsystem)
+		return nil
+	}
+	if cs.intervals == nil {
+		cs.intervals = make(map[string]time.Duration)
+	}
+	cs.intervals[subsystem] = interval
+	return nil
+}
+
+// interval returns the minimum interval between reads of subsystem.
+func (c *Cstats) interval(cfg *Config, subsystem string) time.Duration {
+	if d, ok := c.intervals[subsystem]; ok {
+		return d
+	}
+	if d, ok := c.intervals[""]; ok {
+		return d
+	}
+	if c.scope != nil {
+		if d, ok := c.scope.SubsystemIntervals[subsystem]; ok {
+			return d
+		}
+	}
+	return cfg.SubsystemIntervals[subsystem]
+}
+
+// due returns the subsystems of c not to be read at now, and records
+// now as the last read of the others.
+func (c *Cstats) due(cfg *Config, now time.Time) (skip map[string]bool) {
+	if len(cfg.SubsystemIntervals) == 0 && len(c.intervals) == 0 && (c.scope == nil || len(c.scope.SubsystemIntervals) == 0) {
+		return nil
+	}
+	if c.lastRead == nil {
+		c.lastRead = make(map[string]time.Time)
+	}
+	subsystems := make(map[string]bool)
+	for name := range c.files {
+		subsystems[subsystem(name)] = true
+	}
+	if cfg.FSUsage || cfg.TmpfsUsage {
+		subsystems["fs"] = true
+	}
+	if cfg.NetStats {
+		subsystems["net"] = true
+	}
+	if cfg.PSSUsage {
+		subsystems["pss"] = true
+	}
+	skip = make(map[string]bool, len(subsystems))
+	for s := range subsystems {
+		last, ok := c.lastRead[s]
+		skip[s] = ok && now.Sub(last) < c.interval(cfg, s)
+		if !skip[s] {
+			c.lastRead[s] = now
+		}
+	}
+	return skip
+}
@@ -0,0 +1,174 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+// MetricType tells exporters how a field's value behaves over time
+type MetricType string
+
+const (
+	// monotonically increasing, resetting only when the container restarts
+	Counter MetricType = "counter"
+	// a value which can go up and down
+	Gauge MetricType = "gauge"
+)
+
+// Unit of a field's value as returned by Field.Value
+type Unit string
+
+const (
+	UnitBytes        Unit = "bytes"
+	UnitSeconds      Unit = "seconds"
+	UnitMicroseconds Unit = "usec"
+	UnitPercent      Unit = "percent"
+	// a count of pages, tasks, operations or similar
+	UnitCount Unit = ""
+)
+
+// Field describes a per container statistic, so that exporters can name
+// and type every metric consistently without listing the fields of
+// Cstats themselves. Per device statistics are not included.
+type Field struct {
+	// path of the field in Cstats, such as "Memory.RSS"
+	Name string
+	// canonical metric name, in snake case with the unit as suffix and
+	// counters ending in "_total", such as "memory_rss_bytes"
+	Metric string
+	Unit   Unit
+	Type   MetricType
+	Help   string
+	value  func(c *Cstats) float64
+}
+
+// Value returns the field's value in c. CPU times are converted from
+// CPUStat.Units to seconds, limits which are not set are Unlimited.
+func (f Field) Value(c *Cstats) float64 {
+	return f.value(c)
+}
+
+var schema = []Field{
+	{"Memory.Usage", "memory_usage_bytes", UnitBytes, Gauge, "Memory usage including cache", func(c *Cstats) float64 { return float64(c.Memory.Usage) }},
+	{"Memory.RSS", "memory_rss_bytes", UnitBytes, Gauge, "Anonymous memory", func(c *Cstats) float64 { return float64(c.Memory.RSS) }},
+	{"Memory.MaxUsage", "memory_max_usage_bytes", UnitBytes, Gauge, "Highest memory usage recorded", func(c *Cstats) float64 { return float64(c.Memory.MaxUsage) }},
+	{"Memory.Failcnt", "memory_failcnt_total", UnitCount, Counter, "Times memory usage reached the limit, cgroup v1", func(c *Cstats) float64 { return float64(c.Memory.Failcnt) }},
+	{"Memory.Swap", "memory_swap_bytes", UnitBytes, Gauge, "Memory swapped out, cgroup v1", func(c *Cstats) float64 { return float64(c.Memory.Swap) }},
+	{"Memory.Cache", "memory_cache_bytes", UnitBytes, Gauge, "Page cache memory", func(c *Cstats) float64 { return float64(c.Memory.Cache) }},
+	{"Memory.Pgfault", "memory_pgfault_total", UnitCount, Counter, "Page faults", func(c *Cstats) float64 { return float64(c.Memory.Pgfault) }},
+	{"Memory.Pgmajfault", "memory_pgmajfault_total", UnitCount, Counter, "Major page faults", func(c *Cstats) float64 { return float64(c.Memory.Pgmajfault) }},
+	{"Memory.ActiveAnon", "memory_active_anon_bytes", UnitBytes, Gauge, "Anonymous memory on the active LRU list", func(c *Cstats) float64 { return float64(c.Memory.ActiveAnon) }},
+	{"Memory.InactiveAnon", "memory_inactive_anon_bytes", UnitBytes, Gauge, "Anonymous memory on the inactive LRU list", func(c *Cstats) float64 { return float64(c.Memory.InactiveAnon) }},
+	{"Memory.ActiveFile", "memory_active_file_bytes", UnitBytes, Gauge, "File backed memory on the active LRU list", func(c *Cstats) float64 { return float64(c.Memory.ActiveFile) }},
+	{"Memory.InactiveFile", "memory_inactive_file_bytes", UnitBytes, Gauge, "File backed memory on the inactive LRU list", func(c *Cstats) float64 { return float64(c.Memory.InactiveFile) }},
+	{"Memory.Unevictable", "memory_unevictable_bytes", UnitBytes, Gauge, "Memory which reclaim cannot free, such as mlocked pages", func(c *Cstats) float64 { return float64(c.Memory.Unevictable) }},
+	{"Memory.SlabReclaimable", "memory_slab_reclaimable_bytes", UnitBytes, Gauge, "Kernel slab memory which can be reclaimed", func(c *Cstats) float64 { return float64(c.Memory.SlabReclaimable) }},
+	{"Memory.SlabUnreclaimable", "memory_slab_unreclaimable_bytes", UnitBytes, Gauge, "Kernel slab memory which cannot be reclaimed", func(c *Cstats) float64 { return float64(c.Memory.SlabUnreclaimable) }},
+	{"Memory.PSS", "memory_pss_bytes", UnitBytes, Gauge, "Proportional set size of the processes, sharing pages between them", func(c *Cstats) float64 { return float64(c.Memory.PSS) }},
+	{"Memory.SwapPSS", "memory_swap_pss_bytes", UnitBytes, Gauge, "Proportional size of the processes' swapped out pages", func(c *Cstats) float64 { return float64(c.Memory.SwapPSS) }},
+	{"Memory.Dirty", "memory_dirty_bytes", UnitBytes, Gauge, "File backed memory waiting to be written", func(c *Cstats) float64 { return float64(c.Memory.Dirty) }},
+	{"Memory.Writeback", "memory_writeback_bytes", UnitBytes, Gauge, "File backed memory being written", func(c *Cstats) float64 { return float64(c.Memory.Writeback) }},
+	{"Memory.MappedFile", "memory_mapped_file_bytes", UnitBytes, Gauge, "File backed memory mapped into processes", func(c *Cstats) float64 { return float64(c.Memory.MappedFile) }},
+	{"Memory.Shmem", "memory_shmem_bytes", UnitBytes, Gauge, "Shared memory", func(c *Cstats) float64 { return float64(c.Memory.Shmem) }},
+	{"Memory.WorkingsetRefault", "memory_workingset_refault_total", UnitCount, Counter, "Evicted pages faulted back in", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetRefault) }},
+	{"Memory.WorkingsetActivate", "memory_workingset_activate_total", UnitCount, Counter, "Refaulted pages activated straight away", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetActivate) }},
+	{"Memory.WorkingsetRefaultAnon", "memory_workingset_refault_anon_total", UnitCount, Counter, "Evicted anonymous pages faulted back in", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetRefaultAnon) }},
+	{"Memory.WorkingsetRefaultFile", "memory_workingset_refault_file_total", UnitCount, Counter, "Evicted file backed pages faulted back in", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetRefaultFile) }},
+	{"Memory.WorkingsetActivateAnon", "memory_workingset_activate_anon_total", UnitCount, Counter, "Refaulted anonymous pages activated straight away", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetActivateAnon) }},
+	{"Memory.WorkingsetActivateFile", "memory_workingset_activate_file_total", UnitCount, Counter, "Refaulted file backed pages activated straight away", func(c *Cstats) float64 { return float64(c.Memory.WorkingsetActivateFile) }},
+	{"Memory.Zswap", "memory_zswap_bytes", UnitBytes, Gauge, "Compressed size of pages held in zswap", func(c *Cstats) float64 { return float64(c.Memory.Zswap) }},
+	{"Memory.Zswapped", "memory_zswapped_bytes", UnitBytes, Gauge, "Uncompressed size of pages held in zswap", func(c *Cstats) float64 { return float64(c.Memory.Zswapped) }},
+	{"Memory.Zswpin", "memory_zswpin_total", UnitCount, Counter, "Pages swapped in from zswap", func(c *Cstats) float64 { return float64(c.Memory.Zswpin) }},
+	{"Memory.Zswpout", "memory_zswpout_total", UnitCount, Counter, "Pages swapped out to zswap", func(c *Cstats) float64 { return float64(c.Memory.Zswpout) }},
+	{"Memory.Zswpwb", "memory_zswpwb_total", UnitCount, Counter, "Pages written back from zswap", func(c *Cstats) float64 { return float64(c.Memory.Zswpwb) }},
+	{"Memory.ZswapCurrent", "memory_zswap_current_bytes", UnitBytes, Gauge, "Zswap usage", func(c *Cstats) float64 { return float64(c.Memory.ZswapCurrent) }},
+	{"Memory.OOMKills", "memory_oom_kills_total", UnitCount, Counter, "Processes killed by the OOM killer", func(c *Cstats) float64 { return float64(c.Memory.OOMKills) }},
+	{"Memory.HighEvents", "memory_high_events_total", UnitCount, Counter, "Times usage went over memory.high", func(c *Cstats) float64 { return float64(c.Memory.HighEvents) }},
+	{"Memory.MaxEvents", "memory_max_events_total", UnitCount, Counter, "Times usage reached memory.max", func(c *Cstats) float64 { return float64(c.Memory.MaxEvents) }},
+	{"Memory.LocalHighEvents", "memory_local_high_events_total", UnitCount, Counter, "Times usage of the cgroup alone went over memory.high", func(c *Cstats) float64 { return float64(c.Memory.LocalHighEvents) }},
+	{"Memory.LocalMaxEvents", "memory_local_max_events_total", UnitCount, Counter, "Times usage of the cgroup alone reached memory.max", func(c *Cstats) float64 { return float64(c.Memory.LocalMaxEvents) }},
+	{"Memory.ZswapMax", "memory_zswap_max_bytes", UnitBytes, Gauge, "Zswap limit", func(c *Cstats) float64 { return float64(c.Memory.ZswapMax) }},
+
+	{"CPU.User", "cpu_user_seconds_total", UnitSeconds, Counter, "CPU time spent in user mode", func(c *Cstats) float64 { return c.CPU.UserTime().Seconds() }},
+	{"CPU.System", "cpu_system_seconds_total", UnitSeconds, Counter, "CPU time spent in kernel mode", func(c *Cstats) float64 { return c.CPU.SystemTime().Seconds() }},
+	{"CPU.Usage", "cpu_usage_seconds_total", UnitSeconds, Counter, "Total CPU time", func(c *Cstats) float64 { return c.CPU.Total().Seconds() }},
+	{"CPU.NrBursts", "cpu_bursts_total", UnitCount, Counter, "Periods in which the quota was exceeded by bursting", func(c *Cstats) float64 { return float64(c.CPU.NrBursts) }},
+	{"CPU.BurstTime", "cpu_burst_seconds_total", UnitSeconds, Counter, "CPU time used beyond the quota by bursting", func(c *Cstats) float64 { return c.CPU.BurstTime.Seconds() }},
+	{"CPU.NrPeriods", "cpu_periods_total", UnitCount, Counter, "CFS periods elapsed with the container runnable", func(c *Cstats) float64 { return float64(c.CPU.NrPeriods) }},
+	{"CPU.NrThrottled", "cpu_throttled_periods_total", UnitCount, Counter, "CFS periods in which the container was throttled", func(c *Cstats) float64 { return float64(c.CPU.NrThrottled) }},
+	{"CPU.ThrottledTime", "cpu_throttled_seconds_total", UnitSeconds, Counter, "Time the container was throttled", func(c *Cstats) float64 { return c.CPU.ThrottledTime.Seconds() }},
+
+	{"BlkIO.TotalReadBytes", "blkio_read_bytes_total", UnitBytes, Counter, "Bytes read from all block devices", func(c *Cstats) float64 { return float64(c.BlkIO.TotalReadBytes) }},
+	{"BlkIO.TotalWriteBytes", "blkio_write_bytes_total", UnitBytes, Counter, "Bytes written to all block devices", func(c *Cstats) float64 { return float64(c.BlkIO.TotalWriteBytes) }},
+	{"BlkIO.TotalReadOps", "blkio_read_ops_total", UnitCount, Counter, "Read operations on all block devices", func(c *Cstats) float64 { return float64(c.BlkIO.TotalReadOps) }},
+	{"BlkIO.TotalWriteOps", "blkio_write_ops_total", UnitCount, Counter, "Write operations on all block devices", func(c *Cstats) float64 { return float64(c.BlkIO.TotalWriteOps) }},
+
+	{"Cgroup.Procs", "cgroup_procs", UnitCount, Gauge, "Member processes", func(c *Cstats) float64 { return float64(c.Cgroup.Procs) }},
+	{"Cgroup.Threads", "cgroup_threads", UnitCount, Gauge, "Member threads", func(c *Cstats) float64 { return float64(c.Cgroup.Threads) }},
+	{"Cgroup.NrDescendants", "cgroup_descendants", UnitCount, Gauge, "Live descendant cgroups", func(c *Cstats) float64 { return float64(c.Cgroup.NrDescendants) }},
+	{"Cgroup.NrDyingDescendants", "cgroup_dying_descendants", UnitCount, Gauge, "Removed descendant cgroups not yet freed", func(c *Cstats) float64 { return float64(c.Cgroup.NrDyingDescendants) }},
+
+	{"Pids.Current", "pids_current", UnitCount, Gauge, "Tasks in the container", func(c *Cstats) float64 { return float64(c.Pids.Current) }},
+
+	{"Tasks.Running", "tasks_running", UnitCount, Gauge, "Running or runnable tasks", func(c *Cstats) float64 { return float64(c.Tasks.Running) }},
+	{"Tasks.Sleeping", "tasks_sleeping", UnitCount, Gauge, "Tasks in interruptible sleep", func(c *Cstats) float64 { return float64(c.Tasks.Sleeping) }},
+	{"Tasks.Uninterruptible", "tasks_uninterruptible", UnitCount, Gauge, "Tasks in uninterruptible sleep", func(c *Cstats) float64 { return float64(c.Tasks.Uninterruptible) }},
+	{"Tasks.Stopped", "tasks_stopped", UnitCount, Gauge, "Stopped or traced tasks", func(c *Cstats) float64 { return float64(c.Tasks.Stopped) }},
+	{"Tasks.Zombie", "tasks_zombie", UnitCount, Gauge, "Exited tasks not yet reaped", func(c *Cstats) float64 { return float64(c.Tasks.Zombie) }},
+	{"Tasks.Idle", "tasks_idle", UnitCount, Gauge, "Idle kernel threads", func(c *Cstats) float64 { return float64(c.Tasks.Idle) }},
+	{"Tasks.RunTime", "tasks_run_seconds", UnitSeconds, Gauge, "CPU time used by the live tasks", func(c *Cstats) float64 { return c.Tasks.RunTime.Seconds() }},
+	{"Tasks.RunDelay", "tasks_run_delay_seconds", UnitSeconds, Gauge, "Time the live tasks spent waiting for a CPU", func(c *Cstats) float64 { return c.Tasks.RunDelay.Seconds() }},
+	{"Tasks.Timeslices", "tasks_timeslices", UnitCount, Gauge, "Times the live tasks were scheduled on a CPU", func(c *Cstats) float64 { return float64(c.Tasks.Timeslices) }},
+
+	{"FS.Usage", "fs_usage_bytes", UnitBytes, Gauge, "Filesystem usage", func(c *Cstats) float64 { return float64(c.FS.Usage) }},
+	{"FS.Capacity", "fs_capacity_bytes", UnitBytes, Gauge, "Filesystem size", func(c *Cstats) float64 { return float64(c.FS.Capacity) }},
+	{"FS.Available", "fs_available_bytes", UnitBytes, Gauge, "Filesystem space available to unprivileged users", func(c *Cstats) float64 { return float64(c.FS.Available) }},
+	{"FS.InodesUsed", "fs_inodes_used", UnitCount, Gauge, "Inodes used", func(c *Cstats) float64 { return float64(c.FS.InodesUsed) }},
+	{"FS.Inodes", "fs_inodes", UnitCount, Gauge, "Inodes on the filesystem", func(c *Cstats) float64 { return float64(c.FS.Inodes) }},
+	{"FS.InodesFree", "fs_inodes_free", UnitCount, Gauge, "Free inodes on the filesystem", func(c *Cstats) float64 { return float64(c.FS.InodesFree) }},
+	{"Net.RxBytes", "net_receive_bytes_total", UnitBytes, Counter, "Bytes received on all interfaces but loopback", func(c *Cstats) float64 { return float64(c.Net.RxBytes) }},
+	{"Net.RxPackets", "net_receive_packets_total", UnitCount, Counter, "Packets received", func(c *Cstats) float64 { return float64(c.Net.RxPackets) }},
+	{"Net.RxErrors", "net_receive_errors_total", UnitCount, Counter, "Receive errors", func(c *Cstats) float64 { return float64(c.Net.RxErrors) }},
+	{"Net.RxDropped", "net_receive_dropped_total", UnitCount, Counter, "Received packets dropped", func(c *Cstats) float64 { return float64(c.Net.RxDropped) }},
+	{"Net.TxBytes", "net_transmit_bytes_total", UnitBytes, Counter, "Bytes transmitted on all interfaces but loopback", func(c *Cstats) float64 { return float64(c.Net.TxBytes) }},
+	{"Net.TxPackets", "net_transmit_packets_total", UnitCount, Counter, "Packets transmitted", func(c *Cstats) float64 { return float64(c.Net.TxPackets) }},
+	{"Net.TxErrors", "net_transmit_errors_total", UnitCount, Counter, "Transmit errors", func(c *Cstats) float64 { return float64(c.Net.TxErrors) }},
+	{"Net.TxDropped", "net_transmit_dropped_total", UnitCount, Counter, "Transmitted packets dropped", func(c *Cstats) float64 { return float64(c.Net.TxDropped) }},
+
+	{"Limits.Memory", "limits_memory_bytes", UnitBytes, Gauge, "Memory limit", func(c *Cstats) float64 { return float64(c.Limits.Memory) }},
+	{"Limits.MemorySoft", "limits_memory_soft_bytes", UnitBytes, Gauge, "Memory soft limit", func(c *Cstats) float64 { return float64(c.Limits.MemorySoft) }},
+	{"Limits.MemoryHigh", "limits_memory_high_bytes", UnitBytes, Gauge, "Memory throttling limit", func(c *Cstats) float64 { return float64(c.Limits.MemoryHigh) }},
+	{"Limits.MemSwap", "limits_memswap_bytes", UnitBytes, Gauge, "Memory plus swap limit", func(c *Cstats) float64 { return float64(c.Limits.MemSwap) }},
+	{"Limits.Swap", "limits_swap_bytes", UnitBytes, Gauge, "Swap limit", func(c *Cstats) float64 { return float64(c.Limits.Swap) }},
+	{"Limits.CPUQuota", "limits_cpu_quota_usec", UnitMicroseconds, Gauge, "CPU time allowed per period", func(c *Cstats) float64 { return float64(c.Limits.CPUQuota) }},
+	{"Limits.CPUPeriod", "limits_cpu_period_usec", UnitMicroseconds, Gauge, "CPU quota period", func(c *Cstats) float64 { return float64(c.Limits.CPUPeriod) }},
+	{"Limits.CPUShares", "limits_cpu_shares", UnitCount, Gauge, "Relative CPU weight, cgroup v1", func(c *Cstats) float64 { return float64(c.Limits.CPUShares) }},
+	{"Limits.CPUWeight", "limits_cpu_weight", UnitCount, Gauge, "Relative CPU weight, cgroup v2", func(c *Cstats) float64 { return float64(c.Limits.CPUWeight) }},
+	{"Limits.EffectiveMemory", "limits_effective_memory_bytes", UnitBytes, Gauge, "Memory limit, including those of ancestor cgroups", func(c *Cstats) float64 { return float64(c.Limits.EffectiveMemory) }},
+	{"Limits.EffectiveCPUQuota", "limits_effective_cpu_quota_usec", UnitMicroseconds, Gauge, "CPU time allowed per EffectiveCPUPeriod, including the quotas of ancestor cgroups", func(c *Cstats) float64 { return float64(c.Limits.EffectiveCPUQuota) }},
+	{"Limits.EffectiveCPUPeriod", "limits_effective_cpu_period_usec", UnitMicroseconds, Gauge, "CPU quota period of EffectiveCPUQuota", func(c *Cstats) float64 { return float64(c.Limits.EffectiveCPUPeriod) }},
+	{"Limits.Pids", "limits_pids", UnitCount, Gauge, "Maximum number of tasks", func(c *Cstats) float64 { return float64(c.Limits.Pids) }},
+	{"Limits.IOWeight", "limits_io_weight", UnitCount, Gauge, "Relative I/O weight", func(c *Cstats) float64 { return float64(c.Limits.IOWeight) }},
+	{"Limits.BFQWeight", "limits_bfq_weight", UnitCount, Gauge, "Relative I/O weight used by BFQ", func(c *Cstats) float64 { return float64(c.Limits.BFQWeight) }},
+
+	{"Utilization.MemoryPct", "utilization_memory_percent", UnitPercent, Gauge, "Memory usage as a percentage of the limit", func(c *Cstats) float64 { return c.Utilization.MemoryPct }},
+	{"Utilization.CPUPct", "utilization_cpu_percent", UnitPercent, Gauge, "CPU usage as a percentage of the quota or host CPUs", func(c *Cstats) float64 { return c.Utilization.CPUPct }},
+	{"Utilization.ThrottledPct", "utilization_cpu_throttled_percent", UnitPercent, Gauge, "CFS periods throttled as a percentage of those elapsed", func(c *Cstats) float64 { return c.Utilization.ThrottledPct }},
+	{"Utilization.PidsPct", "utilization_pids_percent", UnitPercent, Gauge, "Tasks as a percentage of the limit", func(c *Cstats) float64 { return c.Utilization.PidsPct }},
+}
+
+// Schema returns the description of every per container statistic.
+func Schema() []Field {
+	return append([]Field(nil), schema...)
+}
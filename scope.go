@@ -0,0 +1,150 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScopeLabel is the Metadata label holding the name of the Scope a
+// container belongs to. Select it to register sinks for a scope only, such
+// as with AddSink(sink, ScopeLabel+"=tenant-a", nil)
+const ScopeLabel = "gocstat.scope"
+
+// Scope applies its own configuration to the containers below a subtree
+// of the cgroup hierarchy, such as a kubepods QoS class or a tenant's
+// slice, so a shared agent can serve tenants with different policies
+type Scope struct {
+	Name string
+	// subtree, relative to the root of the cgroup hierarchy: BasePath on
+	// cgroup v2 or its controller directories on cgroup v1. For example
+	// "kubepods/burstable" or "kubepods.slice/kubepods-burstable.slice"
+	Path string
+	// SubsystemIntervals for the scope's containers, taking precedence
+	// over the package's. Intervals set with SetSampleInterval take
+	// precedence over both
+	SubsystemIntervals map[string]time.Duration
+	// HistoryRetention for the scope's containers, zero for the package's
+	HistoryRetention time.Duration
+}
+
+// AddScope registers scope, applying it to tracked containers below its
+// Path and to those found later. A container below the Paths of several
+// scopes belongs to the one added first.
+func AddScope(scope Scope) error {
+	return statsHolder.addScope(scope)
+}
+
+func (h *holder) addScope(scope Scope) error {
+	if scope.Name == "" || scope.Path == "" {
+		return fmt.Errorf("scope requires a name and a path")
+	}
+	scope.Path = strings.Trim(filepath.ToSlash(filepath.Clean(scope.Path)), "/")
+	intervals := make(map[string]time.Duration, len(scope.SubsystemIntervals))
+	for k, v := range scope.SubsystemIntervals {
+		intervals[k] = v
+	}
+	scope.SubsystemIntervals = intervals
+
+	h.Lock()
+	defer h.Unlock()
+	for _, s := range h.scopes {
+		if s.Name == scope.Name {
+			return fmt.Errorf("scope %s already exists", scope.Name)
+		}
+	}
+	h.scopes = append(h.scopes, &scope)
+	h.assignScopes()
+	return nil
+}
+
+// RemoveScope unregisters the scope called name. Its containers revert to
+// the package's configuration, or that of another scope they are below.
+func RemoveScope(name string) {
+	statsHolder.removeScope(name)
+}
+
+func (h *holder) removeScope(name string) {
+	h.Lock()
+	defer h.Unlock()
+	for i, s := range h.scopes {
+		if s.Name == name {
+			h.scopes = append(h.scopes[:i:i], h.scopes[i+1:]...)
+			break
+		}
+	}
+	h.assignScopes()
+}
+
+// assignScopes sets the scope of every tracked container. The holder
+// must be locked.
+func (h *holder) assignScopes() {
+	for id, cs := range h.containers {
+		h.assignScope(id, cs)
+	}
+}
+
+// assignScope sets the scope of cs, and its ScopeLabel, from its cgroup
+// path. The holder must be locked.
+func (h *holder) assignScope(id string, cs *Cstats) {
+	cs.scope = nil
+	for _, s := range h.scopes {
+		if cs.path == s.Path || strings.HasPrefix(cs.path, s.Path+"/") {
+			cs.scope = s
+			break
+		}
+	}
+	name := ""
+	if cs.scope != nil {
+		name = cs.scope.Name
+	}
+	if cs.Metadata[ScopeLabel] == name {
+		return
+	}
+	cs.setMetadata(ScopeLabel, name)
+	h.modifySnapshot(id, func(snap *Cstats) { snap.setMetadata(ScopeLabel, name) })
+}
+
+// hierarchyPath returns dir relative to the root of the cgroup hierarchy
+// below basePath.
+func (h *holder) hierarchyPath(basePath, dir string) string {
+	rel, err := filepath.Rel(basePath, dir)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if h.info.Version == 1 {
+		// drop the controller directory
+		if i := strings.Index(rel, "/"); i >= 0 {
+			return rel[i+1:]
+		}
+		return ""
+	}
+	return rel
+}
+
+// historyRetention returns how long the history of c is kept, given the
+// default retention.
+func (c *Cstats) historyRetention(retention time.Duration) time.Duration {
+	if c.scope != nil && c.scope.HistoryRetention > 0 {
+		return c.scope.HistoryRetention
+	}
+	return retention
+}
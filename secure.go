@@ -0,0 +1,124 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+package gocstat
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// secureRoot is the BasePath of a holder opened for SecureReads, files below
+// it are opened with openBeneath
+type secureRoot struct {
+	dir  *os.File
+	path string
+}
+
+// secureRoots are the roots of the holders with SecureReads set
+var secureRoots struct {
+	sync.Mutex
+	m map[*holder]secureRoot
+}
+
+// openSecureRoot opens basePath as the root of SecureReads for h if secure
+// is set, replacing its previous root, if any.
+func (h *holder) openSecureRoot(basePath string, secure bool) error {
+	var dir *os.File
+	if secure {
+		var err error
+		if dir, err = os.Open(basePath); err != nil {
+			return err
+		}
+		// probe for openat2, added in Linux 5.6
+		f, err := openBeneath(dir, ".", os.O_RDONLY)
+		if err != nil {
+			dir.Close()
+			return fmt.Errorf("SecureReads requires openat2, err %s", err)
+		}
+		f.Close()
+	}
+	secureRoots.Lock()
+	defer secureRoots.Unlock()
+	if r, ok := secureRoots.m[h]; ok {
+		r.dir.Close()
+		delete(secureRoots.m, h)
+	}
+	if dir != nil {
+		if secureRoots.m == nil {
+			secureRoots.m = make(map[*holder]secureRoot)
+		}
+		secureRoots.m[h] = secureRoot{dir: dir, path: filepath.Clean(basePath)}
+	}
+	return nil
+}
+
+// openFile opens path like os.OpenFile, using openBeneath for files below
+// a secure root, the deepest if several hold path.
+func openFile(path string, flag int) (*os.File, error) {
+	secureRoots.Lock()
+	defer secureRoots.Unlock()
+	var root secureRoot
+	for _, r := range secureRoots.m {
+		if strings.HasPrefix(path, r.path+string(filepath.Separator)) && len(r.path) > len(root.path) {
+			root = r
+		}
+	}
+	if root.dir != nil {
+		return openBeneath(root.dir, path[len(root.path)+1:], flag)
+	}
+	return os.OpenFile(path, flag, 0)
+}
+
+// Number of times a read failing with a transient error is retried
+const maxReadRetries = 3
+
+// readRetries counts the reads retried
+var readRetries uint64
+
+// readFile reads path, retrying reads which fail with EINTR or EAGAIN, as
+// cgroup files occasionally do under heavy load, up to maxReadRetries
+// times.
+func readFile(path string) (b []byte, err error) {
+	for i := 0; ; i++ {
+		b, err = readFileOnce(path)
+		if err == nil || i == maxReadRetries || !retryable(err) {
+			return b, err
+		}
+		atomic.AddUint64(&readRetries, 1)
+	}
+}
+
+// readFileOnce reads path, without retrying.
+var readFileOnce = func(path string) ([]byte, error) {
+	f, err := openFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// retryable reports whether err is transient.
+func retryable(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
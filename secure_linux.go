@@ -0,0 +1,61 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+//go:build linux
+
+package gocstat
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	resolveNoMagiclinks = 0x02
+	resolveNoSymlinks   = 0x04
+	resolveBeneath      = 0x08
+)
+
+// struct open_how
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// openBeneath opens name relative to dir with openat2, refusing to follow
+// symlinks or to resolve outside of dir. EINTR and EAGAIN are returned,
+// to be retried by readFile.
+func openBeneath(dir *os.File, name string, flag int) (*os.File, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	how := openHow{
+		flags:   uint64(flag | syscall.O_CLOEXEC),
+		resolve: resolveBeneath | resolveNoSymlinks | resolveNoMagiclinks,
+	}
+	path := filepath.Join(dir.Name(), name)
+	fd, _, errno := syscall.Syscall6(sysOpenat2, dir.Fd(), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	runtime.KeepAlive(dir)
+	if errno != 0 {
+		return nil, &os.PathError{Op: "openat2", Path: path, Err: errno}
+	}
+	return os.NewFile(fd, path), nil
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileSchemas validate the content of stat files when SelfCheck is set.
+// Files without a schema are not checked.
+var fileSchemas = map[string]func(content string) error{
+	memFile:           keyValueSchema(),
+	cPUFile:           keyValueSchema("user", "system"),
+	cPUStatFile:       keyValueSchema(),
+	cgroupStatFile:    keyValueSchema("nr_descendants", "nr_dying_descendants"),
+	cgroupEventsFile:  keyValueSchema("populated"),
+	memUsageFile:      uintSchema,
+	memCurrentFile:    uintSchema,
+	memEventsFile:     keyValueSchema("oom_kill"),
+	memOOMControlFile: keyValueSchema("oom_kill"),
+	pidsCurrentFile:   uintSchema,
+	blkIOIOPSFile:     blkIOSchema,
+	blkIOBytesFile:    blkIOSchema,
+	blkIOCFQIOPSFile:  blkIOSchema,
+	blkIOCFQBytesFile: blkIOSchema,
+	blkIOBFQIOPSFile:  blkIOSchema,
+	blkIOBFQBytesFile: blkIOSchema,
+
+	memEventsLocalFile: keyValueSchema("high", "max"),
+	memMaxUsageFile:    uintSchema,
+	memPeakFile:        uintSchema,
+	memFailcntFile:     uintSchema,
+	cPUUsageFile:       uintSchema,
+
+	blkIOIOPSRecursiveFile:     blkIOSchema,
+	blkIOBytesRecursiveFile:    blkIOSchema,
+	blkIOCFQIOPSRecursiveFile:  blkIOSchema,
+	blkIOCFQBytesRecursiveFile: blkIOSchema,
+	blkIOBFQIOPSRecursiveFile:  blkIOSchema,
+	blkIOBFQBytesRecursiveFile: blkIOSchema,
+
+	blkIOCFQQueuedFile:          blkIOSchema,
+	blkIOBFQQueuedFile:          blkIOSchema,
+	blkIOCFQQueuedRecursiveFile: blkIOSchema,
+	blkIOBFQQueuedRecursiveFile: blkIOSchema,
+}
+
+// keyValueSchema accepts "key value" lines, requiring the given keys.
+func keyValueSchema(required ...string) func(content string) error {
+	return func(content string) error {
+		keys := make(map[string]bool)
+		for _, line := range strings.Split(content, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) != 2 {
+				return fmt.Errorf("expected 'key value', got '%s'", line)
+			}
+			if _, err := strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return fmt.Errorf("expected an unsigned value for '%s', got '%s'", fields[0], fields[1])
+			}
+			keys[fields[0]] = true
+		}
+		for _, k := range required {
+			if !keys[k] {
+				return fmt.Errorf("missing key '%s'", k)
+			}
+		}
+		return nil
+	}
+}
+
+func uintSchema(content string) error {
+	if _, err := strconv.ParseUint(strings.TrimSpace(content), 10, 64); err != nil {
+		return fmt.Errorf("expected a single unsigned value, got '%s'", strings.TrimSpace(content))
+	}
+	return nil
+}
+
+// blkIOSchema accepts "major:minor Op value" lines and a final "Total value".
+func blkIOSchema(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+			if fields[0] != "Total" {
+				return fmt.Errorf("expected 'Total value', got '%s'", line)
+			}
+		case 3:
+			if _, _, ok := parseDevice(fields[0]); !ok {
+				return fmt.Errorf("expected a major:minor device, got '%s'", fields[0])
+			}
+			switch fields[1] {
+			case "Read", "Write", "Sync", "Async", "Discard", "Total":
+			default:
+				return fmt.Errorf("unknown operation '%s'", fields[1])
+			}
+		default:
+			return fmt.Errorf("expected 'major:minor Op value', got '%s'", line)
+		}
+		if _, err := strconv.ParseUint(fields[len(fields)-1], 10, 64); err != nil {
+			return fmt.Errorf("expected an unsigned value, got '%s'", fields[len(fields)-1])
+		}
+	}
+	return nil
+}
+
+// checkFile validates content of the stat file name against its schema.
+func checkFile(name, path, content string) error {
+	schema, ok := fileSchemas[name]
+	if !ok {
+		return nil
+	}
+	if err := schema(content); err != nil {
+		return fmt.Errorf("self check: '%s': %s", path, err)
+	}
+	return nil
+}
+
+// check validates relationships between parsed fields which can't be seen
+// in a single file.
+func (c *Cstats) check() error {
+	// every operation transfers at least one sector, so a device reading or
+	// writing fewer bytes than operations means the blkio files were mixed up
+	devices := c.BlkIO.Bytes.ByDevice()
+	for _, ops := range c.BlkIO.IOPS.Devices {
+		bytes, ok := devices[ops.Key()]
+		if !ok {
+			continue
+		}
+		if bytes.Read < ops.Read*512 || bytes.Write < ops.Write*512 {
+			return fmt.Errorf("self check: device %d:%d transferred fewer bytes than 512 per operation, BlkIO.Bytes and BlkIO.IOPS may be swapped",
+				ops.Major, ops.Minor)
+		}
+	}
+	return nil
+}
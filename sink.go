@@ -0,0 +1,162 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Sink receives the statistics of each collection, see AddSink. The
+// statistics are shared with other sinks and Views and must not be
+// modified.
+type Sink interface {
+	Send(stats Cmap) error
+}
+
+type sinkRoute struct {
+	sink     Sink
+	selector Selector
+	ch       chan Cmap
+}
+
+// AddSink registers sink to receive, after every collection by ReadStats,
+// ReadCachedStats or Watch, the containers whose Metadata labels match
+// selector (see ParseSelector). An empty selector matches every container.
+// Containers are keyed by their IDs as replaced by ExportID, see Export.
+//
+// Each sink is called from its own goroutine. If it is still busy with the
+// previous statistics when new ones are collected they are dropped.
+// errChan is optional and used for reporting errors returned by the sink.
+// Calling the returned function removes the sink.
+func AddSink(sink Sink, selector string, errChan chan<- error) (func(), error) {
+	return statsHolder.addSink(sink, selector, errChan)
+}
+
+func (h *holder) addSink(sink Sink, selector string, errChan chan<- error) (func(), error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	r := &sinkRoute{sink: sink, selector: sel, ch: make(chan Cmap, 1)}
+	h.Lock()
+	h.sinks = append(h.sinks, r)
+	h.Unlock()
+
+	go func() {
+		for stats := range r.ch {
+			if err := r.sink.Send(stats); err != nil && errChan != nil {
+				select {
+				case errChan <- err:
+				default:
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	remove := func() {
+		once.Do(func() {
+			h.Lock()
+			defer h.Unlock()
+			for i, s := range h.sinks {
+				if s == r {
+					h.sinks = append(h.sinks[:i:i], h.sinks[i+1:]...)
+					break
+				}
+			}
+			close(r.ch)
+		})
+	}
+	return remove, nil
+}
+
+// dispatch hands the current snapshot to every sink. The holder must be
+// locked.
+func (h *holder) dispatch() {
+	if len(h.sinks) == 0 {
+		return
+	}
+	h.snapshotShared = true
+	excludeEmpty := h.config().ExcludeEmpty
+	for _, r := range h.sinks {
+		stats := make(Cmap)
+		for id, cs := range h.snapshot {
+			if (cs.Inactive && excludeEmpty) || !r.selector.match(id, cs) {
+				continue
+			}
+			stats[exportID(id)] = exportStats(id, cs)
+		}
+		select {
+		case r.ch <- stats:
+		default:
+		}
+	}
+}
+
+// Selector matches containers by their Metadata labels. It is a Filter
+// comparing labels only, see ParseSelector.
+type Selector struct {
+	filter *Filter
+}
+
+// ParseSelector parses a comma separated list of key=value and key!=value
+// requirements, all of which must hold for a container to match. A key
+// which is not set equals the empty string. The requirement key=value is
+// the ParseFilter comparison label.key=="value".
+func ParseSelector(s string) (Selector, error) {
+	var root filterNode
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var key string
+		n := filterString{equal: true}
+		if i := strings.Index(term, "!="); i >= 0 {
+			key, n.value, n.equal = term[:i], term[i+2:], false
+		} else if i := strings.Index(term, "="); i >= 0 {
+			key, n.value = term[:i], strings.TrimPrefix(term[i+1:], "=")
+		} else {
+			return Selector{}, fmt.Errorf("invalid selector term '%s', expected key=value or key!=value", term)
+		}
+		key, n.value = strings.TrimSpace(key), strings.TrimSpace(n.value)
+		if key == "" {
+			return Selector{}, fmt.Errorf("invalid selector term '%s', missing key", term)
+		}
+		n.get = stringProperty("label." + key)
+		if root == nil {
+			root = n
+		} else {
+			root = filterAnd{root, n}
+		}
+	}
+	if root == nil {
+		return Selector{}, nil
+	}
+	return Selector{&Filter{expr: s, root: root}}, nil
+}
+
+// Matches reports whether labels satisfy every requirement of s.
+func (s Selector) Matches(labels map[string]string) bool {
+	return s.match("", &Cstats{Metadata: labels})
+}
+
+func (s Selector) match(id string, c *Cstats) bool {
+	return s.filter == nil || s.filter.Match(id, c)
+}
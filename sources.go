@@ -0,0 +1,68 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// preferPath returns which of old and new, paths of a file of the same
+// name found for a container, is to be read. The same ID may be found in
+// several hierarchies, such as every cgroup v1 controller's and the
+// unified one of a hybrid setup, and in its nested cgroups. The shallowest
+// path wins, so that a nested cgroup never shadows the container's own,
+// then the first in lexical order, so that the choice does not depend on
+// the order of the walk.
+func preferPath(old, new string) string {
+	if old == "" {
+		return new
+	}
+	od, nd := strings.Count(old, string(filepath.Separator)), strings.Count(new, string(filepath.Separator))
+	if nd < od || nd == od && new < old {
+		return new
+	}
+	return old
+}
+
+// updateSources sets c.Sources from the files found for c below basePath.
+// Subsystems whose files come from several directories are attributed to
+// that of the first file by name.
+func (c *Cstats) updateSources(basePath string) {
+	var names []string
+	paths := make(map[string]string)
+	for _, files := range []map[string]string{c.files, c.limitFiles, c.controlFiles} {
+		for name, p := range files {
+			names = append(names, name)
+			paths[name] = p
+		}
+	}
+	sort.Strings(names)
+	c.Sources = make(map[string]string)
+	for _, name := range names {
+		s := subsystem(name)
+		if _, ok := c.Sources[s]; ok {
+			continue
+		}
+		dir := filepath.Dir(paths[name])
+		if rel, err := filepath.Rel(basePath, dir); err == nil {
+			dir = filepath.ToSlash(rel)
+		}
+		c.Sources[s] = dir
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// state is what SaveState writes
+type state struct {
+	Time       time.Time
+	Snapshot   Cmap
+	Accounting *accountingState
+}
+
+type accountingState struct {
+	MaxGap time.Duration
+	Usage  map[string]Usage
+	Last   map[string]accountSample
+}
+
+// SaveState writes the statistics of the last collection, and the usage
+// accumulated since StartAccounting if started, to w as JSON, to be
+// restored with LoadState when the process restarts.
+func SaveState(w io.Writer) error {
+	return statsHolder.saveState(w)
+}
+
+func (h *holder) saveState(w io.Writer) error {
+	h.Lock()
+	s := state{Time: time.Now(), Snapshot: h.copySnapshot()}
+	if a := h.accounting; a != nil {
+		s.Accounting = &accountingState{
+			MaxGap: a.maxGap,
+			Usage:  make(map[string]Usage, len(a.usage)),
+			Last:   make(map[string]accountSample, len(a.last)),
+		}
+		for id, u := range a.usage {
+			s.Accounting.Usage[id] = *u
+		}
+		for id, l := range a.last {
+			s.Accounting.Last[id] = l
+		}
+	}
+	h.Unlock()
+	return json.NewEncoder(w).Encode(s)
+}
+
+// LoadState restores the state written by SaveState, and must be called
+// after Init. Containers still present are collected relative to their
+// saved statistics, so Utilization and Derived rates resume, averaged
+// over the time the state was not updated, rather than starting from
+// scratch. Accounting is started, or continued, with the saved usage,
+// counting the usage of still present containers since the state was
+// saved.
+func LoadState(r io.Reader) error {
+	return statsHolder.loadState(r)
+}
+
+func (h *holder) loadState(r io.Reader) error {
+	var s state
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	h.Lock()
+	defer h.Unlock()
+	for id, saved := range s.Snapshot {
+		cs, ok := h.containers[id]
+		if !ok || cs.prev != nil {
+			continue
+		}
+		cs.prev = saved
+		cs.Utilization = saved.Utilization
+		cs.Revision = saved.Revision
+	}
+	if s.Accounting == nil {
+		return nil
+	}
+	a := h.accounting
+	if a == nil {
+		a = &accountant{
+			maxGap: s.Accounting.MaxGap,
+			usage:  make(map[string]*Usage),
+			last:   make(map[string]accountSample),
+		}
+		h.accounting = a
+	}
+	for id, u := range s.Accounting.Usage {
+		if _, ok := a.usage[id]; ok {
+			continue
+		}
+		u := u
+		a.usage[id] = &u
+		if l, ok := s.Accounting.Last[id]; ok {
+			a.last[id] = l
+		}
+	}
+	return nil
+}
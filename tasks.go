@@ -0,0 +1,123 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of the container's tasks (threads) in each scheduler state, from
+// /proc/<pid>/task/<tid>/stat, collected when TaskStates is set. And their
+// scheduler statistics, collected when SchedStats is set
+type TaskStat struct {
+	// R, running or runnable
+	Running uint64
+	// S, interruptible sleep
+	Sleeping uint64
+	// D, uninterruptible sleep, usually waiting on I/O. A count which keeps
+	// rising points at a container stuck on storage
+	Uninterruptible uint64
+	// T and t, stopped or traced
+	Stopped uint64
+	// Z, exited and not yet reaped
+	Zombie uint64
+	// I, idle kernel threads
+	Idle uint64
+
+	// CPU time used by the live tasks, and the time they spent runnable
+	// waiting for a CPU. A run delay rising faster than RunTime means the
+	// container is starved by others on an oversubscribed host. Tasks
+	// exiting take their times with them, so the sums may go down
+	RunTime  time.Duration
+	RunDelay time.Duration
+	// number of times the tasks were scheduled on a CPU
+	Timeslices uint64
+	Timestamp  time.Time
+}
+
+// readTasks counts the states of the tasks of c's member processes, for
+// TaskStates, and sums their schedstat, for SchedStats. Tasks exiting
+// while being read are skipped.
+func (c *Cstats) readTasks(cfg *Config) error {
+	pids, err := c.memberPIDs()
+	if err != nil {
+		return err
+	}
+	t := TaskStat{Timestamp: time.Now()}
+	for _, pid := range pids {
+		dirs, _ := filepath.Glob(filepath.Join(cfg.ProcPath, pid, "task", "*"))
+		for _, dir := range dirs {
+			if cfg.TaskStates {
+				if b, err := readFile(filepath.Join(dir, "stat")); err == nil {
+					t.count(taskState(string(b)))
+				}
+			}
+			if cfg.SchedStats {
+				if b, err := readFile(filepath.Join(dir, "schedstat")); err == nil {
+					t.addSchedStat(string(b))
+				}
+			}
+		}
+	}
+	c.Tasks = t
+	return nil
+}
+
+// addSchedStat adds a schedstat file: the nanoseconds spent on a CPU and
+// waiting for one, and the number of timeslices.
+func (t *TaskStat) addSchedStat(content string) {
+	fields := strings.Fields(content)
+	if len(fields) != 3 {
+		return
+	}
+	run, _ := strconv.ParseUint(fields[0], 10, 64)
+	delay, _ := strconv.ParseUint(fields[1], 10, 64)
+	slices, _ := strconv.ParseUint(fields[2], 10, 64)
+	t.RunTime += time.Duration(run)
+	t.RunDelay += time.Duration(delay)
+	t.Timeslices += slices
+}
+
+// taskState returns the state field of a /proc stat file. It follows the
+// command name, which is in parentheses and may itself contain them.
+func taskState(content string) byte {
+	i := strings.LastIndexByte(content, ')')
+	if i < 0 || i+2 >= len(content) {
+		return 0
+	}
+	return content[i+2]
+}
+
+func (t *TaskStat) count(state byte) {
+	switch state {
+	case 'R':
+		t.Running++
+	case 'S':
+		t.Sleeping++
+	case 'D':
+		t.Uninterruptible++
+	case 'T', 't':
+		t.Stopped++
+	case 'Z':
+		t.Zombie++
+	case 'I':
+		t.Idle++
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// UsageRecord is a container's Usage over a period in the form consumed by
+// billing pipelines
+type UsageRecord struct {
+	Container   string    `json:"container"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	CPUSeconds  float64   `json:"cpu_seconds"`
+	// RSSByteHours in GiB (2^30 bytes) hours
+	GBHours float64 `json:"gb_hours"`
+	// bytes read and written
+	IOBytes uint64 `json:"io_bytes"`
+}
+
+// UsageRecords converts usage, as returned by ReadAccounting, to records
+// ordered by container ID, as replaced by ExportID.
+func UsageRecords(usage map[string]Usage) []UsageRecord {
+	records := make([]UsageRecord, 0, len(usage))
+	for id, u := range usage {
+		records = append(records, UsageRecord{
+			Container:   exportID(id),
+			PeriodStart: u.Start,
+			PeriodEnd:   u.End,
+			CPUSeconds:  u.CPUSeconds,
+			GBHours:     u.RSSByteHours / (1 << 30),
+			IOBytes:     u.ReadBytes + u.WriteBytes,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Container < records[j].Container })
+	return records
+}
+
+// UsageWriter writes usage records to a stream
+type UsageWriter interface {
+	Write(records []UsageRecord) error
+}
+
+// JSONUsageWriter writes usage records as one JSON object per line
+type JSONUsageWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONUsageWriter returns a JSONUsageWriter writing to w.
+func NewJSONUsageWriter(w io.Writer) *JSONUsageWriter {
+	return &JSONUsageWriter{enc: json.NewEncoder(w)}
+}
+
+// Write writes records, implementing UsageWriter.
+func (u *JSONUsageWriter) Write(records []UsageRecord) error {
+	for _, r := range records {
+		if err := u.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSVUsageWriter writes usage records as CSV, preceded by a header line
+// on the first Write. Times are in RFC 3339 format
+type CSVUsageWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVUsageWriter returns a CSVUsageWriter writing to w.
+func NewCSVUsageWriter(w io.Writer) *CSVUsageWriter {
+	return &CSVUsageWriter{w: csv.NewWriter(w)}
+}
+
+// Write writes records, implementing UsageWriter.
+func (u *CSVUsageWriter) Write(records []UsageRecord) error {
+	if !u.header {
+		if err := u.w.Write([]string{"container", "period_start", "period_end", "cpu_seconds", "gb_hours", "io_bytes"}); err != nil {
+			return err
+		}
+		u.header = true
+	}
+	for _, r := range records {
+		err := u.w.Write([]string{
+			r.Container,
+			r.PeriodStart.Format(time.RFC3339Nano),
+			r.PeriodEnd.Format(time.RFC3339Nano),
+			strconv.FormatFloat(r.CPUSeconds, 'f', -1, 64),
+			strconv.FormatFloat(r.GBHours, 'f', -1, 64),
+			strconv.FormatUint(r.IOBytes, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	u.w.Flush()
+	return u.w.Error()
+}
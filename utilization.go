@@ -0,0 +1,154 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"runtime"
+)
+
+// userHZ is the unit of cpuacct.stat, USER_HZ ticks per second
+const userHZ = 100
+
+// Resource usage as a percentage of the container's limits. Percentages
+// are zero where no limit is set.
+type Utilization struct {
+	// Memory.Usage as a percentage of Limits.Memory
+	MemoryPct float64
+	// CPU time used since the previous ReadStats as a percentage of the
+	// CPU quota, or of all host CPUs when there is no quota
+	CPUPct float64
+	// CFS periods in which the container was throttled since the previous
+	// ReadStats, as a percentage of the periods elapsed. Zero without a
+	// CPU quota
+	ThrottledPct float64
+	// Pids.Current as a percentage of Limits.Pids
+	PidsPct float64
+	// I/O since the previous ReadStats as a percentage of the throttling
+	// limits, for each device in Limits.IO
+	IO []IOUtilization
+}
+
+// Block device I/O as a percentage of the device's IOLimit
+type IOUtilization struct {
+	// block device major number
+	Major uint64
+	// block device minor number
+	Minor        uint64
+	ReadBpsPct   float64
+	WriteBpsPct  float64
+	ReadIOPSPct  float64
+	WriteIOPSPct float64
+}
+
+// pct returns v as a percentage of limit, or 0 if there is no limit.
+func pct(v, limit float64) float64 {
+	if limit <= 0 || limit == float64(Unlimited) {
+		return 0
+	}
+	return v / limit * 100
+}
+
+// utilization computes cur's Utilization. prev is the sample taken
+// by the previous ReadStats and may be nil.
+func utilization(cur, prev *Cstats) Utilization {
+	u := Utilization{
+		MemoryPct: pct(float64(cur.Memory.Usage), float64(cur.Limits.Memory)),
+		PidsPct:   pct(float64(cur.Pids.Current), float64(cur.Limits.Pids)),
+	}
+	if prev == nil {
+		return u
+	}
+
+	if secs := cur.CPU.Timestamp.Sub(prev.CPU.Timestamp).Seconds(); secs > 0 && !prev.CPU.Timestamp.IsZero() {
+		// counters going down mean the cgroup was recreated
+		if c, p := cur.CPU.Total(), prev.CPU.Total(); c >= p {
+			used := (c - p).Seconds() / secs
+			cpus := float64(runtime.NumCPU())
+			if l := cur.Limits; l.CPUQuota != 0 && l.CPUQuota != Unlimited && l.CPUPeriod != 0 {
+				cpus = float64(l.CPUQuota) / float64(l.CPUPeriod)
+			}
+			u.CPUPct = pct(used, cpus)
+		}
+		if c, p := cur.CPU, prev.CPU; c.NrPeriods > p.NrPeriods && c.NrThrottled >= p.NrThrottled {
+			u.ThrottledPct = pct(float64(c.NrThrottled-p.NrThrottled), float64(c.NrPeriods-p.NrPeriods))
+		}
+	} else if cur.CPU.Timestamp.Equal(prev.CPU.Timestamp) {
+		// not read this time, see SubsystemIntervals
+		u.CPUPct = prev.Utilization.CPUPct
+		u.ThrottledPct = prev.Utilization.ThrottledPct
+	}
+
+	if len(cur.Limits.IO) == 0 {
+		return u
+	}
+	if cur.BlkIO.Bytes.Timestamp.Equal(prev.BlkIO.Bytes.Timestamp) {
+		u.IO = append([]IOUtilization(nil), prev.Utilization.IO...)
+		return u
+	}
+	bytes := newDeviceRates(&cur.BlkIO.Bytes, &prev.BlkIO.Bytes)
+	ops := newDeviceRates(&cur.BlkIO.IOPS, &prev.BlkIO.IOPS)
+	for _, l := range cur.Limits.IO {
+		iu := IOUtilization{Major: l.Major, Minor: l.Minor}
+		key := BlkDevice{Major: l.Major, Minor: l.Minor}.Key()
+		bytes := bytes.rate(key)
+		iu.ReadBpsPct = pct(bytes.Read, float64(l.ReadBps))
+		iu.WriteBpsPct = pct(bytes.Write, float64(l.WriteBps))
+		ops := ops.rate(key)
+		iu.ReadIOPSPct = pct(ops.Read, float64(l.ReadIOPS))
+		iu.WriteIOPSPct = pct(ops.Write, float64(l.WriteIOPS))
+		u.IO = append(u.IO, iu)
+	}
+	return u
+}
+
+type rwRate struct {
+	Read  float64
+	Write float64
+}
+
+// deviceRates computes per device rates between two BlkServiced samples.
+type deviceRates struct {
+	cur, prev map[string]BlkDevice
+	secs      float64
+}
+
+func newDeviceRates(cur, prev *BlkServiced) deviceRates {
+	r := deviceRates{secs: cur.Timestamp.Sub(prev.Timestamp).Seconds()}
+	if r.secs <= 0 || prev.Timestamp.IsZero() {
+		return deviceRates{}
+	}
+	r.cur = cur.ByDevice()
+	r.prev = prev.ByDevice()
+	return r
+}
+
+// rate returns the per second read and write rate of the device with the
+// given key. Devices missing from either sample have no rate.
+func (r deviceRates) rate(key string) rwRate {
+	c, ok := r.cur[key]
+	if !ok {
+		return rwRate{}
+	}
+	p, ok := r.prev[key]
+	if !ok || c.Read < p.Read || c.Write < p.Write {
+		return rwRate{}
+	}
+	return rwRate{
+		Read:  float64(c.Read-p.Read) / r.secs,
+		Write: float64(c.Write-p.Write) / r.secs,
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// View is a read-only view of the statistics of a collection, shared by
+// every reader of that collection rather than copied for each, see
+// ReadView. The statistics it holds must not be modified: call Copy, or
+// Clone for a single container, to get statistics of one's own.
+type View struct {
+	stats        Cmap
+	time         time.Time
+	excludeEmpty bool
+}
+
+// ReadView is like ReadCachedStats, returning a View of the statistics
+// instead of a copy. Views are cheap to hand to many concurrent readers,
+// such as HTTP handlers, on hosts with thousands of containers: the
+// statistics are only copied when a change, such as by SetMetadata, is
+// made while a View of them is held.
+func ReadView() (*View, error) {
+	return statsHolder.readView()
+}
+
+// ReadView is like the package level ReadView, for the containers of c.
+func (c *Collector) ReadView() (*View, error) {
+	return c.h.readView()
+}
+
+func (h *holder) readView() (*View, error) {
+	h.Lock()
+	defer h.Unlock()
+	if h.containers == nil {
+		return nil, fmt.Errorf("not initialized")
+	}
+	cfg := h.config()
+	if h.snapshot == nil || time.Since(h.snapshotTime) > cfg.MaxStaleness {
+		if err := h.collect(); err != nil {
+			return nil, err
+		}
+	}
+	h.snapshotShared = true
+	return &View{stats: h.snapshot, time: h.snapshotTime, excludeEmpty: cfg.ExcludeEmpty}, nil
+}
+
+// Time returns when the statistics were collected.
+func (v *View) Time() time.Time {
+	return v.time
+}
+
+// IDs returns the IDs of the containers, sorted.
+func (v *View) IDs() []string {
+	ids := make([]string, 0, len(v.stats))
+	for id, cs := range v.stats {
+		if !v.excluded(cs) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Get returns the statistics of container id, which must not be modified.
+func (v *View) Get(id string) (*Cstats, bool) {
+	cs, ok := v.stats[id]
+	if !ok || v.excluded(cs) {
+		return nil, false
+	}
+	return cs, true
+}
+
+// Range calls fn for each container, in no particular order, until it
+// returns false. The statistics passed must not be modified.
+func (v *View) Range(fn func(id string, cs *Cstats) bool) {
+	for id, cs := range v.stats {
+		if !v.excluded(cs) && !fn(id, cs) {
+			return
+		}
+	}
+}
+
+// Map returns the statistics as a Cmap sharing them, for functions
+// taking one which don't modify it, such as Export or a JSON encoder.
+func (v *View) Map() Cmap {
+	if !v.excludeEmpty {
+		return v.stats
+	}
+	stats := make(Cmap, len(v.stats))
+	v.Range(func(id string, cs *Cstats) bool {
+		stats[id] = cs
+		return true
+	})
+	return stats
+}
+
+// Copy returns a deep copy of the statistics, as ReadCachedStats would
+// have, which the caller is free to keep or modify.
+func (v *View) Copy() Cmap {
+	stats := make(Cmap, len(v.stats))
+	v.Range(func(id string, cs *Cstats) bool {
+		stats[id] = cs.clone()
+		return true
+	})
+	return stats
+}
+
+// Clone returns a deep copy of the statistics of container id.
+func (v *View) Clone(id string) (*Cstats, bool) {
+	cs, ok := v.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return cs.clone(), true
+}
+
+func (v *View) excluded(cs *Cstats) bool {
+	return cs.Inactive && v.excludeEmpty
+}
+
+// modifySnapshot applies fn to the snapshot statistics of container id, if
+// any, copying them and the snapshot first if a View may hold them. The
+// holder must be locked.
+func (h *holder) modifySnapshot(id string, fn func(cs *Cstats)) {
+	cs, ok := h.snapshot[id]
+	if !ok {
+		return
+	}
+	if h.snapshotShared {
+		h.unshareSnapshot()
+	}
+	if h.snapshotCopied != nil && !h.snapshotCopied[id] {
+		cs = cs.clone()
+		h.snapshot[id] = cs
+		h.snapshotCopied[id] = true
+	}
+	fn(cs)
+}
+
+// dropSnapshot removes container id from the snapshot, copying it first if
+// a View holds it. The holder must be locked.
+func (h *holder) dropSnapshot(id string) {
+	if _, ok := h.snapshot[id]; !ok {
+		return
+	}
+	if h.snapshotShared {
+		h.unshareSnapshot()
+	}
+	delete(h.snapshot, id)
+}
+
+// unshareSnapshot replaces the snapshot, held by a View, by a copy of the
+// map, still sharing the statistics until modifySnapshot copies them. The
+// holder must be locked.
+func (h *holder) unshareSnapshot() {
+	snapshot := make(Cmap, len(h.snapshot))
+	for id, cs := range h.snapshot {
+		snapshot[id] = cs
+	}
+	h.snapshot = snapshot
+	h.snapshotShared = false
+	h.snapshotCopied = make(map[string]bool)
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2014 Ian Bishop
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package gocstat
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// What Watch does with a snapshot when the previous one has not been
+// received yet
+type BackpressurePolicy int
+
+const (
+	// drop the new snapshot
+	BackpressureDropNewest BackpressurePolicy = iota
+	// replace the snapshot waiting with the new one, so the receiver gets
+	// the most recent statistics
+	BackpressureDropOldest
+	// wait up to WatchBlockTimeout for the receiver, then drop the new
+	// snapshot. Collection is delayed meanwhile
+	BackpressureBlock
+)
+
+// Watch calls ReadCachedStats every interval and sends the result on the returned
+// channel until the returned stop function is called, after which the
+// channel is closed. An interval shorter than 100ms, including zero or
+// negative, is raised to 100ms.
+//
+// Ticks may be aligned to the wall clock and jittered, see AlignTicks and
+// TickJitter, and stretched on busy hosts, see AdaptiveSampling.
+//
+// A snapshot is dropped if the previous one has not been received yet,
+// unless WatchBackpressure says otherwise. errChan is optional and used for
+// reporting ReadCachedStats errors, which are dropped if it is not ready to
+// receive, it is never closed by Watch. Dropped snapshots are counted in
+// CollectionReport.WatchDropped.
+func Watch(interval time.Duration, errChan chan<- error) (<-chan Cmap, func()) {
+	return statsHolder.watch(interval, nil, errChan)
+}
+
+// WatchFilter is like Watch, sending only the containers selected by
+// filter, or all of them if it is nil. Snapshots are sent even when no
+// container is selected, so that receivers keep their pace.
+func WatchFilter(interval time.Duration, filter *Filter, errChan chan<- error) (<-chan Cmap, func()) {
+	return statsHolder.watch(interval, filter, errChan)
+}
+
+// minWatchInterval is the shortest interval of Watch
+const minWatchInterval = 100 * time.Millisecond
+
+func (h *holder) watch(interval time.Duration, filter *Filter, errChan chan<- error) (<-chan Cmap, func()) {
+	if interval < minWatchInterval {
+		interval = minWatchInterval
+	}
+	ch := make(chan Cmap, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(done) })
+	}
+	go func() {
+		defer close(ch)
+		var a adaptive
+		timer := time.NewTimer(nextTick(h.config(), time.Now(), interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			start := time.Now()
+			stats, err := h.readCachedStats()
+			cfg := h.config()
+			timer.Reset(nextTick(cfg, time.Now(), a.update(cfg, interval, time.Since(start))))
+			if err != nil {
+				if errChan != nil {
+					select {
+					case errChan <- err:
+					default:
+					}
+				}
+				continue
+			}
+			sendSnapshot(ch, filter.Apply(stats), cfg.WatchBackpressure, cfg.WatchBlockTimeout, &h.watchDropped, done)
+		}
+	}()
+	return ch, stop
+}
+
+// nextTick returns how long to wait after now for the next tick, taking
+// cfg.AlignTicks and cfg.TickJitter into account.
+func nextTick(cfg *Config, now time.Time, interval time.Duration) time.Duration {
+	d := interval
+	if cfg.AlignTicks {
+		d = now.Truncate(interval).Add(interval).Sub(now)
+	}
+	if cfg.TickJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(cfg.TickJitter)))
+	}
+	return d
+}
+
+// sendSnapshot delivers v on ch according to policy, waiting up to timeout
+// under BackpressureBlock, and counts drops in dropped.
+func sendSnapshot(ch chan Cmap, v Cmap, policy BackpressurePolicy, timeout time.Duration, dropped *uint64, done <-chan struct{}) {
+	select {
+	case ch <- v:
+		return
+	default:
+	}
+	switch policy {
+	case BackpressureDropOldest:
+		select {
+		case <-ch:
+			atomic.AddUint64(dropped, 1)
+		default:
+		}
+		select {
+		case ch <- v:
+			return
+		default:
+		}
+	case BackpressureBlock:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case ch <- v:
+			return
+		case <-done:
+			return
+		case <-timer.C:
+		}
+	}
+	atomic.AddUint64(dropped, 1)
+}